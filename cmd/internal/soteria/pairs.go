@@ -0,0 +1,64 @@
+// Package soteria defines the repository pairs synced from soterium to soteria-dag.
+// The pairs for the opposite direction are derived from them with RepoPair.Mirror.
+package soteria
+
+import (
+	"github.com/soterium/sync_priv_pub/repo"
+)
+
+var (
+	// Repositories involved in sync process
+	soteriumSoterd = repo.GitRepo{Path: "github.com/soterium/soterd"}
+	soteriaSoterd  = repo.GitRepo{Path: "github.com/soteria-dag/soterd"}
+
+	soteriumSoterDash = repo.GitRepo{Path: "github.com/soterium/soterdash"}
+	soteriaSoterDash  = repo.GitRepo{Path: "github.com/soteria-dag/soterdash"}
+
+	soteriumSoterWallet = repo.GitRepo{Path: "github.com/soterium/soterwallet"}
+	soteriaSoterWallet  = repo.GitRepo{Path: "github.com/soteria-dag/soterwallet"}
+
+	soteriumSoterTools = repo.GitRepo{Path: "github.com/soterium/sotertools"}
+	soteriaSoterTools  = repo.GitRepo{Path: "github.com/soteria-dag/sotertools"}
+
+	// Define the sync direction of repositories
+	Soterd = repo.RepoPair{
+		Source:        soteriumSoterd,
+		SourceGitTree: "exp0",
+		Dest:          soteriaSoterd,
+		DestGitTree:   "master",
+		Replace: [][]string{
+			{"soterium", "soteria-dag"},
+			{"Soterium", "Soteria DAG"},
+		},
+	}
+
+	SoterDash = repo.RepoPair{
+		Source:        soteriumSoterDash,
+		SourceGitTree: "master",
+		Dest:          soteriaSoterDash,
+		DestGitTree:   "master",
+		Dependencies:  []*repo.RepoPair{&Soterd},
+	}
+
+	SoterWallet = repo.RepoPair{
+		Source:        soteriumSoterWallet,
+		SourceGitTree: "exp0",
+		Dest:          soteriaSoterWallet,
+		DestGitTree:   "master",
+		Dependencies:  []*repo.RepoPair{&Soterd},
+		Replace: [][]string{
+			{"soterium", "soteria-dag"},
+		},
+	}
+
+	SoterTools = repo.RepoPair{
+		Source:        soteriumSoterTools,
+		SourceGitTree: "master",
+		Dest:          soteriaSoterTools,
+		DestGitTree:   "master",
+		Dependencies:  []*repo.RepoPair{&Soterd, &SoterWallet},
+		Replace: [][]string{
+			{"Soterium", "Soteria DAG"},
+		},
+	}
+)
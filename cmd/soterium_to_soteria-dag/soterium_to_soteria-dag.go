@@ -3,69 +3,22 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"syscall"
 
+	"github.com/soterium/sync_priv_pub/cmd/internal/soteria"
 	"github.com/soterium/sync_priv_pub/repo"
 	"github.com/soterium/sync_priv_pub/tools"
 )
 
 var (
-	neededCmds = []string{"git", "tar", "diff"}
-
-	// Repositories involved in sync process
-	soteriumSoterd = repo.GitRepo{Path: "github.com/soterium/soterd"}
-	soteriaSoterd = repo.GitRepo{Path: "github.com/soteria-dag/soterd"}
-
-	soteriumSoterDash = repo.GitRepo{Path: "github.com/soterium/soterdash"}
-	soteriaSoterDash = repo.GitRepo{Path: "github.com/soteria-dag/soterdash"}
-
-	soteriumSoterWallet = repo.GitRepo{Path: "github.com/soterium/soterwallet"}
-	soteriaSoterWallet = repo.GitRepo{Path: "github.com/soteria-dag/soterwallet"}
-
-	soteriumSoterTools = repo.GitRepo{Path: "github.com/soterium/sotertools"}
-	soteriaSoterTools = repo.GitRepo{Path: "github.com/soteria-dag/sotertools"}
+	neededCmds = []string{"git", "tar"}
 
 	// Define the sync direction of repositories
-	soterd = repo.RepoPair{
-		Source:        soteriumSoterd,
-		SourceGitTree: "exp0",
-		Dest: soteriaSoterd,
-		DestGitTree: "master",
-		Replace: [][]string{
-			{"soterium", "soteria-dag"},
-			{"Soterium", "Soteria DAG"},
-		},
-	}
-
-	soterdash = repo.RepoPair{
-		Source: soteriumSoterDash,
-		SourceGitTree: "master",
-		Dest: soteriaSoterDash,
-		DestGitTree: "master",
-		Dependencies: []*repo.RepoPair{&soterd},
-	}
-
-	soterwallet = repo.RepoPair{
-		Source: soteriumSoterWallet,
-		SourceGitTree: "exp0",
-		Dest: soteriaSoterWallet,
-		DestGitTree: "master",
-		Dependencies: []*repo.RepoPair{&soterd},
-		Replace: [][]string{
-			{"soterium", "soteria-dag"},
-		},
-	}
-
-	sotertools = repo.RepoPair{
-		Source: soteriumSoterTools,
-		SourceGitTree: "master",
-		Dest: soteriaSoterTools,
-		DestGitTree: "master",
-		Dependencies: []*repo.RepoPair{&soterd, &soterwallet},
-		Replace: [][]string{
-			{"Soterium", "Soteria DAG"},
-		},
-	}
+	soterd      = &soteria.Soterd
+	soterdash   = &soteria.SoterDash
+	soterwallet = &soteria.SoterWallet
+	sotertools  = &soteria.SoterTools
 )
 
 // abort prints the message and exits with code 1
@@ -77,15 +30,21 @@ func abort(msg string) {
 func main() {
 	defaultCommitMsg := fmt.Sprintf("%s - Auto code sync", tools.ThisFile())
 
-	var commitMsg, emailAddr, userName string
-	var keepStaging, skipAsk, skipDeps bool
+	var commitMsg, emailAddr, userName, format string
+	var keepStaging, skipAsk, skipDeps, review, importDest, checkRoundTrip, check, goGit bool
 	var syncAll, syncSoterd, syncSoterDash, syncSoterWallet, syncSoterTools bool
 	flag.BoolVar(&keepStaging, "k", false,"Keep staging area after completed")
-	flag.StringVar(&commitMsg, "m", defaultCommitMsg, "Commit message to use")
+	flag.StringVar(&commitMsg, "m", defaultCommitMsg, "Commit message to use, or a text/template of it (see repo.CommitInfo)")
 	flag.StringVar(&emailAddr, "e", "", "Email address to use for commit")
 	flag.StringVar(&userName, "u", "", "User name to use for commit")
 	flag.BoolVar(&skipAsk, "y", false,"Skip confirmation with user before git commit & push of synced repo contents")
 	flag.BoolVar(&skipDeps, "nodep", false, "Skip processing of repo dependencies")
+	flag.BoolVar(&review, "review", false, "Push synced changes to a new sync/<pair>/<dest tree>/<timestamp> branch and open a pull request, instead of pushing to the dest tree")
+	flag.BoolVar(&importDest, "import", false, "Import dest repo commits made since the last sync into a new source repo branch, instead of syncing")
+	flag.BoolVar(&checkRoundTrip, "roundtrip", false, "Check that replacements made by sync can be reversed by syncing in the opposite direction, instead of syncing")
+	flag.BoolVar(&check, "check", false, "Print the paths that syncing would add, remove or modify in the dest trees, instead of syncing")
+	flag.StringVar(&format, "format", tools.TreeDiffText, "Format of -check output, text or json")
+	flag.BoolVar(&goGit, "gogit", false, "Use the built-in git implementation instead of the git and tar commands")
 	flag.BoolVar(&syncAll, "all", false, "Sync all repos")
 	flag.BoolVar(&syncSoterd, "soterd", false, "Sync soterd")
 	flag.BoolVar(&syncSoterDash, "soterdash", false, "Sync soterdash")
@@ -104,6 +63,20 @@ func main() {
 		syncSoterTools = true
 	}
 
+	if goGit {
+		tools.SetGitBackend(tools.NewGoGitBackend())
+		neededCmds = []string{}
+
+		// Diff stats, patches and signatures still come from the git command, for the pairs that use them
+		selected := map[*repo.RepoPair]bool{soterd: syncSoterd, soterdash: syncSoterDash, soterwallet: syncSoterWallet, sotertools: syncSoterTools}
+		for r, sync := range selected {
+			if sync && r.NeedsGitCommand(review, importDest, commitMsg) {
+				neededCmds = append(neededCmds, "git")
+				break
+			}
+		}
+	}
+
 	// Look for needed commands
 	for _, cmd := range neededCmds {
 		_, exists := tools.Which(cmd)
@@ -112,10 +85,46 @@ func main() {
 		}
 	}
 
-	// Sync repositories
+	// Fail on an unsupported -check format before cloning anything. JSON output is a single document on stdout, so
+	// what's printed along the way goes to stderr.
+	stdout := os.Stdout
+	checks := make([]repo.TreeCheck, 0)
+	if check {
+		_, err := repo.RenderChecks(checks, format)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		if format == tools.TreeDiffJSON {
+			os.Stdout = os.Stderr
+		}
+	}
+
+	// Sync repositories, or import from them
+	run := func(r *repo.RepoPair) error {
+		if checkRoundTrip {
+			fmt.Println("Checking round-trip of", r.String())
+			return r.CheckRoundTrip(keepStaging)
+		}
+
+		if check {
+			fmt.Println("Checking", r.String())
+			found, err := r.Check(keepStaging)
+			checks = append(checks, found...)
+			return err
+		}
+
+		if importDest {
+			fmt.Println("Importing", r.String())
+			return r.Import(keepStaging, skipAsk, emailAddr, userName)
+		}
+
+		fmt.Println("Syncing", r.String())
+		return r.Sync(keepStaging, skipAsk, skipDeps, review, commitMsg, emailAddr, userName)
+	}
+
 	if syncSoterd {
-		fmt.Println("Syncing", soterd.String())
-		err := soterd.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(soterd)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", soterd.String(), err))
 		}
@@ -124,8 +133,7 @@ func main() {
 	}
 
 	if syncSoterDash {
-		fmt.Println("Syncing", soterdash.String())
-		err := soterdash.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(soterdash)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", soterdash.String(), err))
 		}
@@ -134,8 +142,7 @@ func main() {
 	}
 
 	if syncSoterWallet {
-		fmt.Println("Syncing", soterwallet.String())
-		err := soterwallet.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(soterwallet)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", soterwallet.String(), err))
 		}
@@ -144,12 +151,20 @@ func main() {
 	}
 
 	if syncSoterTools {
-		fmt.Println("Syncing", sotertools.String())
-		err := sotertools.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(sotertools)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", sotertools.String(), err))
 		}
 
 		fmt.Println()
 	}
+
+	if check {
+		out, err := repo.RenderChecks(checks, format)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		fmt.Fprint(stdout, out)
+	}
 }
\ No newline at end of file
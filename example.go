@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"syscall"
 
 	"github.com/soterium/sync_priv_pub/repo"
@@ -10,7 +11,7 @@ import (
 )
 
 var (
-	neededCmds = []string{"git", "tar", "diff"}
+	neededCmds = []string{"git", "tar"}
 
 	// Repositories involved in sync process
 	soteriumSoterd = repo.GitRepo{Path: "github.com/soterium/soterd"}
@@ -49,15 +50,21 @@ func abort(msg string) {
 func main() {
 	defaultCommitMsg := fmt.Sprintf("%s - Auto code sync", tools.ThisFile())
 
-	var commitMsg, emailAddr, userName string
-	var keepStaging, skipAsk, skipDeps bool
+	var commitMsg, emailAddr, userName, format string
+	var keepStaging, skipAsk, skipDeps, review, importDest, checkRoundTrip, check, goGit bool
 	var syncAll, syncSoterd, syncSoterDash bool
 	flag.BoolVar(&keepStaging, "k", false,"Keep staging area after completed")
-	flag.StringVar(&commitMsg, "m", defaultCommitMsg, "Commit message to use")
+	flag.StringVar(&commitMsg, "m", defaultCommitMsg, "Commit message to use, or a text/template of it (see repo.CommitInfo)")
 	flag.StringVar(&emailAddr, "e", "", "Email address to use for commit")
 	flag.StringVar(&userName, "u", "", "User name to use for commit")
 	flag.BoolVar(&skipAsk, "y", false,"Skip confirmation with user before git commit & push of synced repo contents")
 	flag.BoolVar(&skipDeps, "nodep", false, "Skip processing of repo dependencies")
+	flag.BoolVar(&review, "review", false, "Push synced changes to a new sync/<pair>/<dest tree>/<timestamp> branch and open a pull request, instead of pushing to the dest tree")
+	flag.BoolVar(&importDest, "import", false, "Import dest repo commits made since the last sync into a new source repo branch, instead of syncing")
+	flag.BoolVar(&checkRoundTrip, "roundtrip", false, "Check that replacements made by sync can be reversed by syncing in the opposite direction, instead of syncing")
+	flag.BoolVar(&check, "check", false, "Print the paths that syncing would add, remove or modify in the dest trees, instead of syncing")
+	flag.StringVar(&format, "format", tools.TreeDiffText, "Format of -check output, text or json")
+	flag.BoolVar(&goGit, "gogit", false, "Use the built-in git implementation instead of the git and tar commands")
 	flag.BoolVar(&syncAll, "all", false, "Sync all repos")
 	flag.BoolVar(&syncSoterd, "soterd", false, "Sync soterd")
 	flag.BoolVar(&syncSoterDash, "soterdash", false, "Sync soterdash")
@@ -72,6 +79,20 @@ func main() {
 		syncSoterDash = true
 	}
 
+	if goGit {
+		tools.SetGitBackend(tools.NewGoGitBackend())
+		neededCmds = []string{}
+
+		// Diff stats, patches and signatures still come from the git command, for the pairs that use them
+		selected := map[*repo.RepoPair]bool{&soterd: syncSoterd, &soterdash: syncSoterDash}
+		for r, sync := range selected {
+			if sync && r.NeedsGitCommand(review, importDest, commitMsg) {
+				neededCmds = append(neededCmds, "git")
+				break
+			}
+		}
+	}
+
 	// Look for needed commands
 	for _, cmd := range neededCmds {
 		_, exists := tools.Which(cmd)
@@ -80,10 +101,46 @@ func main() {
 		}
 	}
 
-	// Sync repositories
+	// Fail on an unsupported -check format before cloning anything. JSON output is a single document on stdout, so
+	// what's printed along the way goes to stderr.
+	stdout := os.Stdout
+	checks := make([]repo.TreeCheck, 0)
+	if check {
+		_, err := repo.RenderChecks(checks, format)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		if format == tools.TreeDiffJSON {
+			os.Stdout = os.Stderr
+		}
+	}
+
+	// Sync repositories, or import from them
+	run := func(r *repo.RepoPair) error {
+		if checkRoundTrip {
+			fmt.Println("Checking round-trip of", r.String())
+			return r.CheckRoundTrip(keepStaging)
+		}
+
+		if check {
+			fmt.Println("Checking", r.String())
+			found, err := r.Check(keepStaging)
+			checks = append(checks, found...)
+			return err
+		}
+
+		if importDest {
+			fmt.Println("Importing", r.String())
+			return r.Import(keepStaging, skipAsk, emailAddr, userName)
+		}
+
+		fmt.Println("Syncing", r.String())
+		return r.Sync(keepStaging, skipAsk, skipDeps, review, commitMsg, emailAddr, userName)
+	}
+
 	if syncSoterd {
-		fmt.Println("Syncing", soterd.String())
-		err := soterd.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(&soterd)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", soterd.String(), err))
 		}
@@ -92,12 +149,20 @@ func main() {
 	}
 
 	if syncSoterDash {
-		fmt.Println("Syncing", soterdash.String())
-		err := soterdash.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+		err := run(&soterdash)
 		if err != nil {
 			abort(fmt.Sprintf("Failed to sync %s:\n%s", soterdash.String(), err))
 		}
 
 		fmt.Println()
 	}
+
+	if check {
+		out, err := repo.RenderChecks(checks, format)
+		if err != nil {
+			abort(err.Error())
+		}
+
+		fmt.Fprint(stdout, out)
+	}
 }
@@ -0,0 +1,198 @@
+// Package forge opens pull requests through the APIs of git hosting services (forges).
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Kinds of forges that pull requests can be opened on
+const (
+	GitHub = "github"
+	// Gitea, and Forgejo which shares its API
+	Gitea  = "gitea"
+	GitLab = "gitlab"
+)
+
+// Timeout for requests to forge APIs
+const requestTimeout = 30 * time.Second
+
+// Config describes how to reach the API of a forge
+type Config struct {
+	// The kind of forge (GitHub, Gitea or GitLab)
+	Kind string
+	// Base URL of the API (ex: https://api.github.com, https://gitea.example.com/api/v1, https://gitlab.com/api/v4).
+	// Defaults to the public API of the kind of forge, on the host of the repo.
+	URL string
+	// Name of the environment variable holding the API token.
+	// Defaults to GITHUB_TOKEN, GITEA_TOKEN or GITLAB_TOKEN depending on the kind of forge.
+	TokenEnv string
+}
+
+// PullRequest describes a pull request (or merge request) to open
+type PullRequest struct {
+	// Branch with the changes
+	Head string
+	// Branch the changes should be merged to
+	Base  string
+	Title string
+	Body  string
+}
+
+// Client opens pull requests on a forge
+type Client interface {
+	// OpenPullRequest opens the pull request in the repo (ex: owner/repo_name), and returns its web URL
+	OpenPullRequest(repo string, pr PullRequest) (string, error)
+	// ClosePullRequests closes the open pull requests in the repo that pr supersedes: those to the same base branch,
+	// from other head branches of the repo starting with prefix. The comment is left on each of them before it's
+	// closed. It returns their web URLs.
+	ClosePullRequests(repo string, pr PullRequest, prefix, comment string) ([]string, error)
+}
+
+// Number of open pull requests looked at for ClosePullRequests, which is the first page of them. Syncs leave few open.
+const openPullsPage = 100
+
+// supersedes returns true if pr supersedes the open pull request from the head branch of the same repo: it's from
+// another branch starting with prefix
+func supersedes(pr PullRequest, head, prefix string) bool {
+	return head != pr.Head && strings.HasPrefix(head, prefix)
+}
+
+// New returns a client for the forge described by the config, for repos on the host (ex: github.com)
+func New(c Config, host string) (Client, error) {
+	switch c.Kind {
+	case GitHub:
+		url := c.URL
+		if len(url) == 0 {
+			url = "https://api.github.com"
+			if host != "github.com" {
+				// GitHub Enterprise
+				url = fmt.Sprintf("https://%s/api/v3", host)
+			}
+		}
+		token, err := lookupToken(c.TokenEnv, "GITHUB_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		return &gitHub{api: api{url: url, header: "Authorization", token: "token " + token}}, nil
+	case Gitea:
+		url := c.URL
+		if len(url) == 0 {
+			url = fmt.Sprintf("https://%s/api/v1", host)
+		}
+		token, err := lookupToken(c.TokenEnv, "GITEA_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		return &gitea{api: api{url: url, header: "Authorization", token: "token " + token}}, nil
+	case GitLab:
+		url := c.URL
+		if len(url) == 0 {
+			url = fmt.Sprintf("https://%s/api/v4", host)
+		}
+		token, err := lookupToken(c.TokenEnv, "GITLAB_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		return &gitLab{api: api{url: url, header: "PRIVATE-TOKEN", token: token}}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported forge kind %q", c.Kind)
+	}
+}
+
+// lookupToken returns the value of the environment variable env, or def if env is empty
+func lookupToken(env, def string) (string, error) {
+	if len(env) == 0 {
+		env = def
+	}
+
+	token, exists := os.LookupEnv(env)
+	if !exists || len(token) == 0 {
+		return "", fmt.Errorf("Need an API token in environment variable %s", env)
+	}
+
+	return token, nil
+}
+
+// api performs authenticated JSON requests against a forge API
+type api struct {
+	// Base URL of the API
+	url string
+	// Header and value used to authenticate requests
+	header string
+	token  string
+}
+
+// get decodes the JSON response of the endpoint under the API url into out
+func (a *api) get(endpoint string, out interface{}) error {
+	return a.do(http.MethodGet, endpoint, nil, out)
+}
+
+// post sends in as JSON to the endpoint under the API url, and decodes the JSON response into out
+func (a *api) post(endpoint string, in, out interface{}) error {
+	return a.do(http.MethodPost, endpoint, in, out)
+}
+
+// do sends a request with the method to the endpoint under the API url, with in as its JSON body if it isn't nil,
+// and decodes the JSON response into out if it isn't nil
+func (a *api) do(method, endpoint string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, a.url+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(a.header, a.token)
+
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{method: method, endpoint: endpoint, code: resp.StatusCode, status: resp.Status, body: respBody}
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(respBody, out)
+}
+
+// statusError is returned for responses of forge APIs that don't have a successful status
+type statusError struct {
+	method   string
+	endpoint string
+	code     int
+	status   string
+	body     []byte
+}
+
+// Error returns the request, the status of its response and the response body
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s returned %s\n%s", e.method, e.endpoint, e.status, e.body)
+}
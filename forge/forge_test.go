@@ -0,0 +1,319 @@
+package forge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Environment variable holding the token used in tests
+const testTokenEnv = "SYNC_PRIV_PUB_TEST_TOKEN"
+
+// request is a request received by the stand-in of a forge API
+type request struct {
+	method string
+	path   string
+	query  string
+	auth   string
+	body   map[string]string
+}
+
+// forgeTest describes how the API of a kind of forge is expected to be used
+type forgeTest struct {
+	kind string
+	// Header and value that authenticate requests
+	header string
+	auth   string
+	// Requests to open a pull request, to list the open ones, and to comment on and close the superseded one
+	create  string
+	list    string
+	comment string
+	close   string
+	// Response of the list of open pull requests, where only number 7 is superseded
+	open interface{}
+	// Fields of the request bodies holding the body of the pull request, and of responses holding its URL
+	bodyField string
+	urlField  string
+	// Field and value of the request body that closes a pull request
+	closeField string
+	closeValue string
+}
+
+// testPull is the pull request opened in tests, which supersedes those from other sync/pair/ branches
+var testPull = PullRequest{Head: "sync/pair/2", Base: "master", Title: "Sync", Body: "Synced commits"}
+
+// gitHubOpen returns an open pull request, as the GitHub and Gitea APIs describe it
+func gitHubOpen(number int, head, headRepo string) map[string]interface{} {
+	return map[string]interface{}{
+		"number":   number,
+		"html_url": fmt.Sprintf("https://example.org/pull/%d", number),
+		"head":     map[string]interface{}{"ref": head, "repo": map[string]string{"full_name": headRepo}},
+		"base":     map[string]interface{}{"ref": "master", "repo": map[string]string{"full_name": "owner/repo"}},
+	}
+}
+
+var forgeTests = []forgeTest{
+	{
+		kind:    GitHub,
+		header:  "Authorization",
+		auth:    "token secret",
+		create:  "POST /repos/owner/repo/pulls",
+		list:    "GET /repos/owner/repo/pulls?base=master&per_page=100&state=open",
+		comment: "POST /repos/owner/repo/issues/7/comments",
+		close:   "PATCH /repos/owner/repo/pulls/7",
+		open: []map[string]interface{}{
+			gitHubOpen(7, "sync/pair/1", "owner/repo"),
+			gitHubOpen(8, "feature", "owner/repo"),
+			gitHubOpen(9, "sync/pair/2", "owner/repo"),
+			gitHubOpen(10, "sync/pair/0", "fork/repo"),
+		},
+		bodyField:  "body",
+		urlField:   "html_url",
+		closeField: "state",
+		closeValue: "closed",
+	},
+	{
+		kind:    Gitea,
+		header:  "Authorization",
+		auth:    "token secret",
+		create:  "POST /repos/owner/repo/pulls",
+		list:    "GET /repos/owner/repo/pulls?limit=100&state=open",
+		comment: "POST /repos/owner/repo/issues/7/comments",
+		close:   "PATCH /repos/owner/repo/pulls/7",
+		open: []map[string]interface{}{
+			gitHubOpen(7, "sync/pair/1", "owner/repo"),
+			gitHubOpen(8, "feature", "owner/repo"),
+			gitHubOpen(9, "sync/pair/2", "owner/repo"),
+			gitHubOpen(10, "sync/pair/0", "fork/repo"),
+			// Gitea lists pull requests to every base branch
+			{
+				"number":   11,
+				"html_url": "https://example.org/pull/11",
+				"head":     map[string]interface{}{"ref": "sync/pair/3", "repo": map[string]string{"full_name": "owner/repo"}},
+				"base":     map[string]interface{}{"ref": "release", "repo": map[string]string{"full_name": "owner/repo"}},
+			},
+		},
+		bodyField:  "body",
+		urlField:   "html_url",
+		closeField: "state",
+		closeValue: "closed",
+	},
+	{
+		kind:    GitLab,
+		header:  "PRIVATE-TOKEN",
+		auth:    "secret",
+		create:  "POST /projects/group%2Fowner%2Frepo/merge_requests",
+		list:    "GET /projects/group%2Fowner%2Frepo/merge_requests?per_page=100&state=opened&target_branch=master",
+		comment: "POST /projects/group%2Fowner%2Frepo/merge_requests/7/notes",
+		close:   "PUT /projects/group%2Fowner%2Frepo/merge_requests/7",
+		open: []map[string]interface{}{
+			{"iid": 7, "web_url": "https://example.org/pull/7", "source_branch": "sync/pair/1", "source_project_id": 1, "target_project_id": 1},
+			{"iid": 8, "web_url": "https://example.org/pull/8", "source_branch": "feature", "source_project_id": 1, "target_project_id": 1},
+			{"iid": 9, "web_url": "https://example.org/pull/9", "source_branch": "sync/pair/2", "source_project_id": 1, "target_project_id": 1},
+			{"iid": 10, "web_url": "https://example.org/pull/10", "source_branch": "sync/pair/0", "source_project_id": 2, "target_project_id": 1},
+		},
+		bodyField:  "description",
+		urlField:   "web_url",
+		closeField: "state_event",
+		closeValue: "close",
+	},
+}
+
+// repo returns the repo that pull requests are opened in
+func (f *forgeTest) repo() string {
+	if f.kind == GitLab {
+		return "group/owner/repo"
+	}
+
+	return "owner/repo"
+}
+
+// standIn returns a stand-in of the forge API, which records the requests it receives. The list of open pull
+// requests is always found, and other requests get the status.
+func (f *forgeTest) standIn(t *testing.T, status int, requests *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := request{
+			method: req.Method,
+			path:   req.URL.EscapedPath(),
+			query:  req.URL.RawQuery,
+			auth:   req.Header.Get(f.header),
+		}
+
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("Failed to read request body: %s", err)
+		}
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &r.body)
+			if err != nil {
+				t.Errorf("Request body isn't JSON: %s", err)
+			}
+		}
+		*requests = append(*requests, r)
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.String() == f.list {
+			_ = json.NewEncoder(w).Encode(f.open)
+			return
+		}
+
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{f.urlField: "https://example.org/pull/12", "message": "nope"})
+	}))
+}
+
+// client returns a client for the forge, using the API at url
+func (f *forgeTest) client(t *testing.T, url string) Client {
+	t.Setenv(testTokenEnv, "secret")
+	c, err := New(Config{Kind: f.kind, URL: url, TokenEnv: testTokenEnv}, "example.org")
+	if err != nil {
+		t.Fatalf("Failed to create %s client: %s", f.kind, err)
+	}
+
+	return c
+}
+
+// String returns the method, path and query of the request
+func (r request) String() string {
+	if len(r.query) == 0 {
+		return fmt.Sprintf("%s %s", r.method, r.path)
+	}
+
+	return fmt.Sprintf("%s %s?%s", r.method, r.path, r.query)
+}
+
+// checkRequests checks that the requests are the wanted ones, authenticated for the forge
+func (f *forgeTest) checkRequests(t *testing.T, requests []request, want ...string) {
+	got := make([]string, 0, len(requests))
+	for _, r := range requests {
+		got = append(got, r.String())
+		if r.auth != f.auth {
+			t.Errorf("Request %s has %s %q, want %q", r.String(), f.header, r.auth, f.auth)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got requests %q, want %q", got, want)
+	}
+}
+
+func TestOpenPullRequest(t *testing.T) {
+	for _, f := range forgeTests {
+		f := f
+		t.Run(f.kind, func(t *testing.T) {
+			requests := make([]request, 0)
+			srv := f.standIn(t, http.StatusCreated, &requests)
+			defer srv.Close()
+
+			url, err := f.client(t, srv.URL).OpenPullRequest(f.repo(), testPull)
+			if err != nil {
+				t.Fatalf("OpenPullRequest failed: %s", err)
+			}
+			if url != "https://example.org/pull/12" {
+				t.Errorf("OpenPullRequest returned %q", url)
+			}
+
+			f.checkRequests(t, requests, f.create)
+			sent := requests[len(requests)-1].body
+			if sent["title"] != testPull.Title || sent[f.bodyField] != testPull.Body {
+				t.Errorf("Request %s has body %v", f.create, sent)
+			}
+		})
+	}
+}
+
+func TestOpenPullRequestError(t *testing.T) {
+	for _, f := range forgeTests {
+		f := f
+		t.Run(f.kind, func(t *testing.T) {
+			requests := make([]request, 0)
+			srv := f.standIn(t, http.StatusUnprocessableEntity, &requests)
+			defer srv.Close()
+
+			_, err := f.client(t, srv.URL).OpenPullRequest(f.repo(), testPull)
+			if err == nil {
+				t.Fatalf("OpenPullRequest succeeded with an error response")
+			}
+			if !strings.Contains(err.Error(), "422") || !strings.Contains(err.Error(), "nope") {
+				t.Errorf("Error doesn't have the status and response: %s", err)
+			}
+		})
+	}
+}
+
+func TestClosePullRequests(t *testing.T) {
+	for _, f := range forgeTests {
+		f := f
+		t.Run(f.kind, func(t *testing.T) {
+			requests := make([]request, 0)
+			srv := f.standIn(t, http.StatusOK, &requests)
+			defer srv.Close()
+
+			closed, err := f.client(t, srv.URL).ClosePullRequests(f.repo(), testPull, "sync/pair/", "Superseded")
+			if err != nil {
+				t.Fatalf("ClosePullRequests failed: %s", err)
+			}
+			if !reflect.DeepEqual(closed, []string{"https://example.org/pull/7"}) {
+				t.Errorf("ClosePullRequests returned %q", closed)
+			}
+
+			f.checkRequests(t, requests, f.list, f.comment, f.close)
+			if len(requests) == 3 {
+				if requests[1].body["body"] != "Superseded" {
+					t.Errorf("Request %s has body %v", f.comment, requests[1].body)
+				}
+				if requests[2].body[f.closeField] != f.closeValue {
+					t.Errorf("Request %s has body %v", f.close, requests[2].body)
+				}
+			}
+		})
+	}
+}
+
+func TestClosePullRequestsError(t *testing.T) {
+	for _, f := range forgeTests {
+		f := f
+		t.Run(f.kind, func(t *testing.T) {
+			requests := make([]request, 0)
+			srv := f.standIn(t, http.StatusForbidden, &requests)
+			defer srv.Close()
+
+			// Pull requests aren't closed without the comment saying why
+			_, err := f.client(t, srv.URL).ClosePullRequests(f.repo(), testPull, "sync/pair/", "Superseded")
+			if err == nil || !strings.Contains(err.Error(), "403") {
+				t.Errorf("ClosePullRequests didn't fail with the status of the comment: %v", err)
+			}
+			f.checkRequests(t, requests, f.list, f.comment)
+		})
+	}
+}
+
+func TestClosePullRequestsLookupError(t *testing.T) {
+	for _, f := range forgeTests {
+		f := f
+		t.Run(f.kind, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				http.Error(w, `{"message": "unauthorized"}`, http.StatusUnauthorized)
+			}))
+			defer srv.Close()
+
+			_, err := f.client(t, srv.URL).ClosePullRequests(f.repo(), testPull, "sync/pair/", "Superseded")
+			if err == nil || !strings.Contains(err.Error(), "401") {
+				t.Errorf("ClosePullRequests didn't fail with the status of the lookup: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewNeedsToken(t *testing.T) {
+	t.Setenv(testTokenEnv, "")
+	_, err := New(Config{Kind: GitHub, TokenEnv: testTokenEnv}, "github.com")
+	if err == nil {
+		t.Errorf("New succeeded without a token")
+	}
+}
@@ -0,0 +1,82 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// gitea opens pull requests with the Gitea (or Forgejo) REST API
+type gitea struct {
+	api
+}
+
+// giteaBranch is the head or base branch of a pull request, as the Gitea API describes it
+type giteaBranch struct {
+	Ref  string `json:"ref"`
+	Repo struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+// giteaPull is a pull request, as the Gitea API describes it
+type giteaPull struct {
+	Number  int         `json:"number"`
+	HTMLURL string      `json:"html_url"`
+	Head    giteaBranch `json:"head"`
+	Base    giteaBranch `json:"base"`
+}
+
+// OpenPullRequest opens the pull request in the repo, and returns its web URL
+func (g *gitea) OpenPullRequest(repo string, pr PullRequest) (string, error) {
+	in := map[string]string{
+		"title": pr.Title,
+		"head":  pr.Head,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}
+
+	var out giteaPull
+	err := g.post(fmt.Sprintf("/repos/%s/pulls", repo), in, &out)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open pull request in %s: %s", repo, err)
+	}
+
+	return out.HTMLURL, nil
+}
+
+// ClosePullRequests comments on and closes the open pull requests in the repo that pr supersedes, and returns their
+// web URLs
+func (g *gitea) ClosePullRequests(repo string, pr PullRequest, prefix, comment string) ([]string, error) {
+	// Gitea can't filter pull requests by base branch, so it's checked here
+	query := url.Values{"state": {"open"}, "limit": {strconv.Itoa(openPullsPage)}}
+	var open []giteaPull
+	err := g.get(fmt.Sprintf("/repos/%s/pulls?%s", repo, query.Encode()), &open)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up pull requests in %s: %s", repo, err)
+	}
+
+	closed := make([]string, 0)
+	for _, p := range open {
+		// Pull requests from forks aren't ones the sync opened
+		if p.Base.Ref != pr.Base || p.Head.Repo.FullName != repo || !supersedes(pr, p.Head.Ref, prefix) {
+			continue
+		}
+
+		// Comments on pull requests are the comments of their issue
+		err = g.post(fmt.Sprintf("/repos/%s/issues/%d/comments", repo, p.Number), map[string]string{"body": comment}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to comment on pull request %d in %s: %s", p.Number, repo, err)
+		}
+
+		err = g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", repo, p.Number), map[string]string{"state": "closed"}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to close pull request %d in %s: %s", p.Number, repo, err)
+		}
+
+		closed = append(closed, p.HTMLURL)
+	}
+
+	return closed, nil
+}
@@ -0,0 +1,77 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// gitHub opens pull requests with the GitHub REST API
+type gitHub struct {
+	api
+}
+
+// gitHubPull is a pull request, as the GitHub API describes it
+type gitHubPull struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref  string `json:"ref"`
+		Repo struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
+	} `json:"head"`
+}
+
+// OpenPullRequest opens the pull request in the repo, and returns its web URL
+func (g *gitHub) OpenPullRequest(repo string, pr PullRequest) (string, error) {
+	in := map[string]string{
+		"title": pr.Title,
+		"head":  pr.Head,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}
+
+	var out gitHubPull
+	err := g.post(fmt.Sprintf("/repos/%s/pulls", repo), in, &out)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open pull request in %s: %s", repo, err)
+	}
+
+	return out.HTMLURL, nil
+}
+
+// ClosePullRequests comments on and closes the open pull requests in the repo that pr supersedes, and returns their
+// web URLs
+func (g *gitHub) ClosePullRequests(repo string, pr PullRequest, prefix, comment string) ([]string, error) {
+	query := url.Values{"state": {"open"}, "base": {pr.Base}, "per_page": {strconv.Itoa(openPullsPage)}}
+	var open []gitHubPull
+	err := g.get(fmt.Sprintf("/repos/%s/pulls?%s", repo, query.Encode()), &open)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up pull requests in %s: %s", repo, err)
+	}
+
+	closed := make([]string, 0)
+	for _, p := range open {
+		// Pull requests from forks aren't ones the sync opened
+		if p.Head.Repo.FullName != repo || !supersedes(pr, p.Head.Ref, prefix) {
+			continue
+		}
+
+		// Comments on pull requests are the comments of their issue
+		err = g.post(fmt.Sprintf("/repos/%s/issues/%d/comments", repo, p.Number), map[string]string{"body": comment}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to comment on pull request %d in %s: %s", p.Number, repo, err)
+		}
+
+		err = g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", repo, p.Number), map[string]string{"state": "closed"}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to close pull request %d in %s: %s", p.Number, repo, err)
+		}
+
+		closed = append(closed, p.HTMLURL)
+	}
+
+	return closed, nil
+}
@@ -0,0 +1,80 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// gitLab opens merge requests with the GitLab REST API
+type gitLab struct {
+	api
+}
+
+// gitLabMerge is a merge request, as the GitLab API describes it
+type gitLabMerge struct {
+	IID             int    `json:"iid"`
+	WebURL          string `json:"web_url"`
+	SourceBranch    string `json:"source_branch"`
+	SourceProjectID int    `json:"source_project_id"`
+	TargetProjectID int    `json:"target_project_id"`
+}
+
+// OpenPullRequest opens a merge request in the repo, and returns its web URL
+func (g *gitLab) OpenPullRequest(repo string, pr PullRequest) (string, error) {
+	in := map[string]string{
+		"title":         pr.Title,
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+		"description":   pr.Body,
+	}
+
+	var out gitLabMerge
+	err := g.post(gitLabMerges(repo), in, &out)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open merge request in %s: %s", repo, err)
+	}
+
+	return out.WebURL, nil
+}
+
+// ClosePullRequests comments on and closes the open merge requests in the repo that pr supersedes, and returns their
+// web URLs
+func (g *gitLab) ClosePullRequests(repo string, pr PullRequest, prefix, comment string) ([]string, error) {
+	project := gitLabMerges(repo)
+	query := url.Values{"state": {"opened"}, "target_branch": {pr.Base}, "per_page": {strconv.Itoa(openPullsPage)}}
+	var open []gitLabMerge
+	err := g.get(fmt.Sprintf("%s?%s", project, query.Encode()), &open)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up merge requests in %s: %s", repo, err)
+	}
+
+	closed := make([]string, 0)
+	for _, m := range open {
+		// Merge requests from forks aren't ones the sync opened
+		if m.SourceProjectID != m.TargetProjectID || !supersedes(pr, m.SourceBranch, prefix) {
+			continue
+		}
+
+		err = g.post(fmt.Sprintf("%s/%d/notes", project, m.IID), map[string]string{"body": comment}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to comment on merge request %d in %s: %s", m.IID, repo, err)
+		}
+
+		err = g.do(http.MethodPut, fmt.Sprintf("%s/%d", project, m.IID), map[string]string{"state_event": "close"}, nil)
+		if err != nil {
+			return closed, fmt.Errorf("Failed to close merge request %d in %s: %s", m.IID, repo, err)
+		}
+
+		closed = append(closed, m.WebURL)
+	}
+
+	return closed, nil
+}
+
+// gitLabMerges returns the endpoint of the merge requests of the repo. GitLab identifies projects by their
+// URL-encoded path, which may include subgroups.
+func gitLabMerges(repo string) string {
+	return fmt.Sprintf("/projects/%s/merge_requests", url.PathEscape(repo))
+}
@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// Transports that repositories can be cloned with
+const (
+	SSH   = "ssh"
+	HTTPS = "https"
+)
+
+// Auth configures how a repository is connected to, so that repositories can use different identities.
+//
+// The settings are kept with the clone of the repository (in its local git config, for the git command),
+// so that they're also used when fetching from and pushing to the clone later on.
+type Auth struct {
+	// Transport to clone with, SSH or HTTPS. Defaults to SSH.
+	Transport string
+
+	// Path of the ssh private key to use, instead of the default identities
+	SSHKey string
+	// Path of the socket of the ssh-agent to use, instead of SSH_AUTH_SOCK
+	SSHAgent string
+
+	// Name of the environment variable holding the token to use for https (ex: GITHUB_TOKEN).
+	// The token isn't written to disk; git reads it from the environment of this process.
+	TokenEnv string
+	// User name to send with the token. Defaults to x-access-token (use oauth2 for GitLab).
+	TokenUser string
+	// Path of a git credentials file (as written by git-credential-store) to use for https
+	CredentialsFile string
+}
+
+// useHTTPS returns true if the repo should be cloned with https
+func (g *GitRepo) useHTTPS() bool {
+	return g.Auth != nil && g.Auth.Transport == HTTPS
+}
+
+// gitAuth returns the credentials to connect to the repo with
+func (g *GitRepo) gitAuth() (*tools.GitAuth, error) {
+	a := g.Auth
+	if a == nil {
+		return nil, nil
+	}
+
+	switch a.Transport {
+	case "", SSH, HTTPS:
+	default:
+		return nil, fmt.Errorf("Unsupported transport %q for %s", a.Transport, g.Path)
+	}
+
+	return &tools.GitAuth{
+		SSHKey:          a.SSHKey,
+		SSHAgent:        a.SSHAgent,
+		TokenEnv:        a.TokenEnv,
+		TokenUser:       a.TokenUser,
+		CredentialsFile: a.CredentialsFile,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BranchMap maps source git branches to dest git branches.
+//
+// Source and Dest are glob patterns, where * matches any run of characters other than /, and ? matches a single one.
+// The text matched by each wildcard in Source is substituted, in order, for the wildcards in Dest, so both need the
+// same number of wildcards.
+// (ex: {"release/*", "release/*"} maps release/v1 to release/v1, and {"exp0", "master"} maps exp0 to master)
+type BranchMap struct {
+	// Glob pattern of branches in the source git repo
+	Source string
+	// Pattern of the branch name to use in the dest git repo
+	Dest string
+
+	// Source and Dest, compiled on first use
+	glob *globMap
+}
+
+// branchTarget is a single source branch and the dest branch it maps to
+type branchTarget struct {
+	Source string
+	Dest   string
+}
+
+// globMap is a source glob pattern compiled to a regular expression, and the dest pattern its matches map to
+type globMap struct {
+	source *regexp.Regexp
+	dest   string
+}
+
+// String returns a string representing the BranchMap
+func (b *BranchMap) String() string {
+	return fmt.Sprintf("%s -> %s", b.Source, b.Dest)
+}
+
+// Match returns the dest branch name for the given source branch, and a boolean of if the branch matched the map
+func (b *BranchMap) Match(branch string) (string, bool, error) {
+	if b.glob == nil {
+		glob, err := newGlobMap(b.Source, b.Dest)
+		if err != nil {
+			return "", false, err
+		}
+		b.glob = glob
+	}
+
+	name, ok := b.glob.match(branch)
+	return name, ok, nil
+}
+
+// newGlobMap compiles the source glob pattern, and returns an error if the source and dest patterns have different
+// numbers of wildcards
+func newGlobMap(source, dest string) (*globMap, error) {
+	if globWildcards(source) != globWildcards(dest) {
+		return nil, fmt.Errorf("Source pattern %s and dest pattern %s have different numbers of wildcards", source, dest)
+	}
+
+	re, err := globRegexp(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &globMap{source: re, dest: dest}, nil
+}
+
+// match matches the name against the source pattern, and returns the dest pattern with its wildcards
+// replaced by the text matched by the wildcards in the source pattern.
+func (g *globMap) match(name string) (string, bool) {
+	m := g.source.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+
+	captures := m[1:]
+	var mapped strings.Builder
+	for _, c := range g.dest {
+		if c != '*' && c != '?' {
+			mapped.WriteRune(c)
+			continue
+		}
+
+		mapped.WriteString(captures[0])
+		captures = captures[1:]
+	}
+
+	return mapped.String(), true
+}
+
+// globWildcards returns the number of wildcards in the glob pattern
+func globWildcards(pattern string) int {
+	return strings.Count(pattern, "*") + strings.Count(pattern, "?")
+}
+
+// globRegexp returns a regular expression matching the same names as the glob pattern,
+// with a capture group for each wildcard.
+// Consecutive wildcards each get their own group, so ** matches the same names as *, and doesn't cross /.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			expr.WriteString("([^/]*)")
+		case '?':
+			expr.WriteString("([^/])")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
+
+// branchTargets returns the source and dest branches to sync, based on the branches that exist in the source repo.
+// If the pair has no branch maps, SourceGitTree and DestGitTree are used.
+func (r *RepoPair) branchTargets(branches []string) ([]branchTarget, error) {
+	targets := make([]branchTarget, 0)
+	if len(r.Branches) == 0 {
+		targets = append(targets, branchTarget{Source: r.SourceGitTree, Dest: r.DestGitTree})
+		return targets, nil
+	}
+
+	// Track dest branches, so that two source branches can't be synced to the same dest branch
+	seen := make(map[string]string)
+	for i := range r.Branches {
+		b := &r.Branches[i]
+		for _, branch := range branches {
+			dest, ok, err := b.Match(branch)
+			if err != nil {
+				return targets, fmt.Errorf("Can't apply branch map (%s): %s", b.String(), err)
+			}
+			if !ok {
+				continue
+			}
+
+			prev, exists := seen[dest]
+			if exists {
+				if prev == branch {
+					// An earlier map already selected this branch
+					continue
+				}
+				return targets, fmt.Errorf("Source branches %s and %s both map to dest branch %s", prev, branch, dest)
+			}
+			seen[dest] = branch
+
+			targets = append(targets, branchTarget{Source: branch, Dest: dest})
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestBranchMapMatch(t *testing.T) {
+	for _, tc := range []struct {
+		source  string
+		dest    string
+		branch  string
+		want    string
+		matched bool
+	}{
+		{source: "exp0", dest: "master", branch: "exp0", want: "master", matched: true},
+		{source: "exp0", dest: "master", branch: "exp01", matched: false},
+		{source: "release/*", dest: "release/*", branch: "release/v1", want: "release/v1", matched: true},
+		{source: "release/*", dest: "rel-*", branch: "release/v1", want: "rel-v1", matched: true},
+		{source: "release/*", dest: "release/*", branch: "release/v1/fix", matched: false},
+		{source: "release/*", dest: "release/*", branch: "release/", want: "release/", matched: true},
+		{source: "v?.*", dest: "*/v?", branch: "v1.2", want: "1/v2", matched: true},
+		{source: "v?.*", dest: "*/v?", branch: "v10.2", matched: false},
+		// ** is two wildcards that don't cross /, not a recursive match, and the first one is greedy
+		{source: "feature/**", dest: "feature/**", branch: "feature/a/b", matched: false},
+		{source: "feature/**", dest: "feature/*-*", branch: "feature/ab", want: "feature/ab-", matched: true},
+		// Regexp characters in patterns are literal
+		{source: "fix.(*)", dest: "fix-*", branch: "fix.(1)", want: "fix-1", matched: true},
+		{source: "fix.(*)", dest: "fix-*", branch: "fixa(1)", matched: false},
+	} {
+		b := &BranchMap{Source: tc.source, Dest: tc.dest}
+		got, matched, err := b.Match(tc.branch)
+		if err != nil || matched != tc.matched || got != tc.want {
+			t.Errorf("%s: Match(%q) returned %q, %t, %v, want %q, %t", b.String(), tc.branch, got, matched, err,
+				tc.want, tc.matched)
+		}
+	}
+}
+
+func TestBranchMapWildcardCount(t *testing.T) {
+	for _, b := range []BranchMap{
+		{Source: "release/*", Dest: "master"},
+		{Source: "release/*", Dest: "release/*/*"},
+		{Source: "v?.*", Dest: "v*"},
+	} {
+		_, _, err := b.Match("release/v1")
+		if err == nil {
+			t.Errorf("%s: Match didn't fail with different numbers of wildcards", b.String())
+		}
+	}
+}
+
+func TestBranchTargets(t *testing.T) {
+	r := &RepoPair{
+		SourceGitTree: "exp0",
+		DestGitTree:   "master",
+		Branches: []BranchMap{
+			{Source: "exp0", Dest: "master"},
+			{Source: "release/*", Dest: "release/*"},
+			{Source: "exp?", Dest: "exp?"},
+		},
+	}
+	targets, err := r.branchTargets([]string{"exp0", "exp1", "release/v1", "other"})
+	want := []branchTarget{{"exp0", "master"}, {"release/v1", "release/v1"}, {"exp0", "exp0"}, {"exp1", "exp1"}}
+	if err != nil || len(targets) != len(want) {
+		t.Fatalf("branchTargets returned %v, %v, want %v", targets, err, want)
+	}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("branchTargets returned %v, want %v", targets, want)
+			break
+		}
+	}
+
+	// The maps are compiled once, and kept by the pair
+	for i := range r.Branches {
+		if r.Branches[i].glob == nil {
+			t.Errorf("%s: map wasn't compiled", r.Branches[i].String())
+		}
+	}
+
+	// Two source branches can't map to the same dest branch
+	r.Branches = append(r.Branches, BranchMap{Source: "release/*", Dest: "exp*"})
+	_, err = r.branchTargets([]string{"exp1", "release/1"})
+	if err == nil {
+		t.Errorf("branchTargets didn't fail when two source branches map to the same dest branch")
+	}
+}
@@ -0,0 +1,162 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// TreeCheck is what syncing a tree of a pair would change, as found by Check
+type TreeCheck struct {
+	// Paths of the source and dest repos of the pair
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+	// The source git tree, and the dest git tree it's synced to
+	SourceTree string `json:"source_tree"`
+	DestTree   string `json:"dest_tree"`
+	// Paths that syncing would add, remove or modify in the dest tree
+	Changes tools.TreeDiff `json:"changes"`
+}
+
+// RenderChecks returns the checks in the format: tools.TreeDiffText, with a line introducing the changes to each dest
+// tree, or tools.TreeDiffJSON, as a single JSON list of the checks
+func RenderChecks(checks []TreeCheck, format string) (string, error) {
+	switch format {
+	case "", tools.TreeDiffText:
+		var out strings.Builder
+		for _, c := range checks {
+			fmt.Fprintf(&out, "%s\tsyncing tree %s would change %d paths of tree %s\n", c.Dest, c.SourceTree, len(c.Changes), c.DestTree)
+			out.WriteString(c.Changes.String())
+		}
+
+		return out.String(), nil
+	case tools.TreeDiffJSON:
+		// Render no checks and no changes as empty lists rather than null
+		list := make([]TreeCheck, 0, len(checks))
+		for _, c := range checks {
+			if c.Changes == nil {
+				c.Changes = tools.TreeDiff{}
+			}
+			list = append(list, c)
+		}
+
+		out, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return "", err
+		}
+
+		return string(out) + "\n", nil
+	default:
+		return "", fmt.Errorf("Unsupported check format %q", format)
+	}
+}
+
+// Check stages the sync of each branch of the pair the same way Sync does, without committing or pushing anything,
+// and returns the paths that syncing would add, remove or modify in each dest tree, for RenderChecks. What it prints
+// is progress, like Sync does.
+//
+// Dependencies aren't synced first, so their current dest versions are used for go modules. The post-clone and
+// post-transform hooks of the pair are run, since they can change the staged files, but not the others.
+func (r *RepoPair) Check(keepStaging bool) ([]TreeCheck, error) {
+	checks := make([]TreeCheck, 0)
+	err := r.checkHooks()
+	if err != nil {
+		return checks, err
+	}
+
+	staging, err := ioutil.TempDir("", "sync_priv_pub-")
+	if err != nil {
+		return checks, fmt.Errorf("Failed to create staging staging: %s", err)
+	}
+
+	// If we encounter an error, we'll leave the staging area behind
+	cleanup := true
+	defer func() {
+		if cleanup && !keepStaging {
+			_ = os.RemoveAll(staging)
+		}
+	}()
+
+	src, dst, goEnv, err := r.clone(staging)
+	if err != nil {
+		cleanup = false
+		return checks, err
+	}
+
+	srcBranches, err := tools.GitBranches(src, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return checks, fmt.Errorf("Failed to list remote branches for %s: %s", src, err)
+	}
+
+	dstBranches, err := tools.GitBranches(dst, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return checks, fmt.Errorf("Failed to list remote branches for %s: %s", dst, err)
+	}
+
+	targets, err := r.branchTargets(srcBranches)
+	if err != nil {
+		cleanup = false
+		return checks, err
+	}
+
+	for _, t := range targets {
+		newBranch := len(r.Branches) > 0 && !contains(dstBranches, t.Dest)
+		_, err = r.stageTree(src, dst, t, newBranch, goEnv)
+		if err != nil {
+			cleanup = false
+			return checks, err
+		}
+
+		err = r.scanTree(dst)
+		if err != nil {
+			cleanup = false
+			return checks, err
+		}
+
+		// Compare the staged files to the dest tree before staging (or the tree a new branch would start from), as it's
+		// committed rather than as it's archived, so that its own export attributes don't show up as changes
+		base := filepath.Join(staging, "check", t.Dest)
+		err = os.MkdirAll(base, tempPathMode)
+		if err != nil {
+			cleanup = false
+			return checks, fmt.Errorf("Failed to create %s: %s", base, err)
+		}
+
+		err = tools.GitSnapshot(dst, "HEAD", base)
+		if err != nil {
+			cleanup = false
+			return checks, fmt.Errorf("Failed to copy %s of %s to %s: %s", "HEAD", dst, base, err)
+		}
+
+		diff, err := tools.CompareTrees(base, dst, diffExclude...)
+		if err != nil {
+			cleanup = false
+			return checks, fmt.Errorf("Failed to compare %s to %s: %s", dst, base, err)
+		}
+
+		fmt.Printf("%s\tchecked tree %s\n", r.Dest.Path, t.Dest)
+		checks = append(checks, TreeCheck{
+			Source:     r.Source.Path,
+			Dest:       r.Dest.Path,
+			SourceTree: t.Source,
+			DestTree:   t.Dest,
+			Changes:    diff,
+		})
+
+		// Discard the staged changes, so that the next target starts from a clean checkout
+		err = tools.GitReset(dst)
+		if err != nil {
+			cleanup = false
+			return checks, fmt.Errorf("Failed to reset %s: %s", dst, err)
+		}
+	}
+
+	return checks, nil
+}
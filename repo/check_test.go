@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// testChecks are the checks of two trees of a pair, one of which has no changes
+var testChecks = []TreeCheck{
+	{
+		Source:     "github.com/soterium/soterd",
+		Dest:       "github.com/soteria-dag/soterd",
+		SourceTree: "master",
+		DestTree:   "master",
+		Changes: tools.TreeDiff{
+			{Path: "a.go", Kind: tools.TreeModified, Reason: "content"},
+			{Path: "b.go", Kind: tools.TreeAdded},
+		},
+	},
+	{
+		Source:     "github.com/soterium/soterd",
+		Dest:       "github.com/soteria-dag/soterd",
+		SourceTree: "release",
+		DestTree:   "release",
+	},
+}
+
+func TestRenderChecksText(t *testing.T) {
+	want := "github.com/soteria-dag/soterd\tsyncing tree master would change 2 paths of tree master\n" +
+		tools.TreeModified + "\ta.go\tcontent\n" + tools.TreeAdded + "\tb.go\n" +
+		"github.com/soteria-dag/soterd\tsyncing tree release would change 0 paths of tree release\n"
+	got, err := RenderChecks(testChecks, tools.TreeDiffText)
+	if err != nil || got != want {
+		t.Errorf("RenderChecks returned %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRenderChecksJSON(t *testing.T) {
+	// All trees are in a single document, which has every change and no nulls
+	out, err := RenderChecks(testChecks, tools.TreeDiffJSON)
+	if err != nil {
+		t.Fatalf("RenderChecks returned %v", err)
+	}
+
+	var got []TreeCheck
+	err = json.Unmarshal([]byte(out), &got)
+	if err != nil {
+		t.Fatalf("RenderChecks returned %q, which isn't a JSON document: %s", out, err)
+	}
+
+	want := append([]TreeCheck(nil), testChecks...)
+	want[1].Changes = tools.TreeDiff{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RenderChecks returned %q, want the checks %+v", out, want)
+	}
+
+	out, err = RenderChecks(nil, tools.TreeDiffJSON)
+	if err != nil || out != "[]\n" {
+		t.Errorf("RenderChecks of no checks returned %q, %v", out, err)
+	}
+}
+
+func TestRenderChecksUnsupported(t *testing.T) {
+	_, err := RenderChecks(testChecks, "yaml")
+	if err == nil {
+		t.Errorf("RenderChecks didn't fail for an unsupported format")
+	}
+}
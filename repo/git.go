@@ -2,35 +2,117 @@ package repo
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"path"
 	"strings"
+	"text/template"
 
+	"github.com/soterium/sync_priv_pub/forge"
 	"github.com/soterium/sync_priv_pub/tools"
 )
 
 type GitRepo struct {
-	// The path to the repository (ex: github.com/user/repo_name)
+	// The path to the repository (ex: github.com/user/repo_name).
+	// This is also the go module path of the repository, unless Module is set.
 	Path string
+	// The go module path of the repository, if it's different from Path (ex: a vanity import path like go.example.org/repo_name)
+	Module string
+	// The major version of the go module of the repository, when a pair changes it (ex: 2 for github.com/user/repo_name/v2,
+	// or 1 for no /vN suffix). It needs to be set on both repos of the pair. If it isn't, major version suffixes of
+	// module paths are kept as they are.
+	Major int
+	// The URL to clone the repository from, if it can't be derived from Path
+	// (ex: ssh://git@git.example.org:2222/group/subgroup/repo_name.git, file:///srv/git/repo_name.git, or a bare repo directory).
+	// It's a text/template, which can refer to the Host, RepoPath and Path of the repository
+	// (ex: ssh://git@{{.Host}}:2222/{{.RepoPath}}.git).
+	URL string
+	// How to connect to the repository (transport and credentials). If nil, ssh is used with the default identities.
+	Auth *Auth
+	// The forge hosting the repository, used to open pull requests.
+	// If nil, it's determined from the host for github.com, gitlab.com and codeberg.org.
+	Forge *forge.Config
+	// The URL of the Git LFS server of the repository, if it isn't the https url of the repository followed by
+	// /info/lfs, which is where git-lfs looks by default (ex: https://lfs.example.org/repo_name)
+	LFS string
 }
 
-// Clone clones the repo to the dst
-func (g *GitRepo) Clone(dst string, useHttps bool) error {
-	git, exists := tools.Which("git")
-	if !exists {
-		return fmt.Errorf("Couldn't find git command")
+// Check checks that the repo can be reached with its auth settings, by listing its remote branches
+func (g *GitRepo) Check() error {
+	if g.Auth != nil && len(g.Auth.TokenEnv) > 0 {
+		if len(os.Getenv(g.Auth.TokenEnv)) == 0 {
+			return fmt.Errorf("Need a token in environment variable %s to connect to %s", g.Auth.TokenEnv, g.Path)
+		}
 	}
 
-	var src string
-	if useHttps {
-		src = g.HTTPSURL()
-	} else {
-		src = g.SSHUrl()
+	src, err := g.CloneURL(g.useHTTPS())
+	if err != nil {
+		return err
 	}
 
-	cmd := exec.Command(git, "clone", "--quiet", src, dst)
-	err := cmd.Run()
-	return err
+	auth, err := g.gitAuth()
+	if err != nil {
+		return err
+	}
+
+	return tools.GitLsRemote(src, auth)
+}
+
+// Clone clones the repo to the dst, with the transport and credentials of its auth settings.
+// The auth settings are kept with the clone, for fetching and pushing later on.
+func (g *GitRepo) Clone(dst string) error {
+	src, err := g.CloneURL(g.useHTTPS())
+	if err != nil {
+		return err
+	}
+
+	auth, err := g.gitAuth()
+	if err != nil {
+		return err
+	}
+
+	return tools.GitClone(src, dst, auth)
+}
+
+// CloneURL returns the url to clone the repo from. This is URL if it's set, or an https or ssh url for the repo otherwise.
+func (g *GitRepo) CloneURL(useHttps bool) (string, error) {
+	if len(g.URL) == 0 {
+		if useHttps {
+			return g.HTTPSURL(), nil
+		}
+
+		return g.SSHUrl(), nil
+	}
+
+	tmpl, err := template.New(g.Path).Parse(g.URL)
+	if err != nil {
+		return "", fmt.Errorf("Can't parse URL template of %s: %s", g.Path, err)
+	}
+
+	var url strings.Builder
+	err = tmpl.Execute(&url, g)
+	if err != nil {
+		return "", fmt.Errorf("Can't render URL template of %s: %s", g.Path, err)
+	}
+
+	return url.String(), nil
+}
+
+// ForgeClient returns a client for the API of the forge hosting the repo
+func (g *GitRepo) ForgeClient() (forge.Client, error) {
+	if g.Forge != nil {
+		return forge.New(*g.Forge, g.Host())
+	}
+
+	switch g.Host() {
+	case "github.com":
+		return forge.New(forge.Config{Kind: forge.GitHub}, g.Host())
+	case "gitlab.com":
+		return forge.New(forge.Config{Kind: forge.GitLab}, g.Host())
+	case "codeberg.org":
+		return forge.New(forge.Config{Kind: forge.Gitea}, g.Host())
+	default:
+		return nil, fmt.Errorf("Don't know which forge hosts %s, it needs to be configured", g.Path)
+	}
 }
 
 // Host returns the host of the repo path
@@ -44,6 +126,51 @@ func (g *GitRepo) HTTPSURL() string {
 	return fmt.Sprintf("https://%s/%s.git", g.Host(), g.RepoPath())
 }
 
+// LFSURL returns the url of the Git LFS server of the repo. This is LFS if it's set, or the https url that the repo is
+// cloned from followed by /info/lfs otherwise.
+func (g *GitRepo) LFSURL() (string, error) {
+	if len(g.LFS) > 0 {
+		return g.LFS, nil
+	}
+
+	repoURL := g.HTTPSURL()
+	if len(g.URL) > 0 {
+		u, err := g.CloneURL(true)
+		if err != nil {
+			return "", err
+		}
+
+		if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return "", fmt.Errorf("Can't determine the Git LFS server of %s from %s, it needs to be configured", g.Path, u)
+		}
+
+		repoURL = strings.TrimSuffix(u, "/")
+		if !strings.HasSuffix(repoURL, ".git") {
+			repoURL += ".git"
+		}
+	}
+
+	return repoURL + "/info/lfs", nil
+}
+
+// ModulePath returns the go module path of the repo
+func (g *GitRepo) ModulePath() string {
+	if len(g.Module) > 0 {
+		return g.Module
+	}
+
+	return g.Path
+}
+
+// MajorModulePath returns the go module path of the repo, with the /vN suffix of its major version for versions 2 and up
+func (g *GitRepo) MajorModulePath() string {
+	if g.Major < 2 {
+		return g.ModulePath()
+	}
+
+	return fmt.Sprintf("%s/v%d", g.ModulePath(), g.Major)
+}
+
 // RepoPath returns the repository identifier part of the path (without the git host)
 func (g *GitRepo) RepoPath() string {
 	parts := strings.Split(g.Path, "/")
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneURL(t *testing.T) {
+	soterd := func(url string) *GitRepo {
+		return &GitRepo{Path: "git.example.org/group/soterd", Module: "go.soteria.org/soterd", Major: 3, URL: url}
+	}
+
+	for _, tc := range []struct {
+		repo  *GitRepo
+		https bool
+		want  string
+	}{
+		{soterd(""), false, "git@git.example.org:group/soterd.git"},
+		{soterd(""), true, "https://git.example.org/group/soterd.git"},
+		{soterd("ssh://git@{{.Host}}:2222/{{.RepoPath}}.git"), false, "ssh://git@git.example.org:2222/group/soterd.git"},
+		// The URL is used for https too
+		{soterd("file:///srv/git/{{.Path}}"), true, "file:///srv/git/git.example.org/group/soterd"},
+		{soterd("https://{{.Host}}/mirrors/{{.Module}}/v{{.Major}}"), true, "https://git.example.org/mirrors/go.soteria.org/soterd/v3"},
+		{soterd("/srv/git/{{.MajorModulePath}}.git"), false, "/srv/git/go.soteria.org/soterd/v3.git"},
+	} {
+		got, err := tc.repo.CloneURL(tc.https)
+		if err != nil || got != tc.want {
+			t.Errorf("CloneURL(%t) of %q returned %q, %v, want %q", tc.https, tc.repo.URL, got, err, tc.want)
+		}
+	}
+
+	// Templates that can't be parsed, or refer to something a repo doesn't have
+	for _, url := range []string{"ssh://{{.Host", "ssh://{{.Hostname}}/soterd.git", "{{template \"none\"}}"} {
+		got, err := soterd(url).CloneURL(false)
+		if err == nil {
+			t.Errorf("CloneURL of %q returned %q, want an error", url, got)
+		}
+	}
+}
+
+func TestModulePaths(t *testing.T) {
+	for _, tc := range []struct {
+		repo   GitRepo
+		module string
+		major  string
+	}{
+		{GitRepo{Path: "github.com/soterium/soterd"}, "github.com/soterium/soterd", "github.com/soterium/soterd"},
+		{GitRepo{Path: "github.com/soterium/soterd", Major: 1}, "github.com/soterium/soterd", "github.com/soterium/soterd"},
+		{GitRepo{Path: "github.com/soterium/soterd", Major: 2}, "github.com/soterium/soterd", "github.com/soterium/soterd/v2"},
+		{GitRepo{Path: "github.com/soterium/soterd", Module: "go.soterium.org/soterd", Major: 4}, "go.soterium.org/soterd",
+			"go.soterium.org/soterd/v4"},
+	} {
+		if got := tc.repo.ModulePath(); got != tc.module {
+			t.Errorf("ModulePath of %+v = %q, want %q", tc.repo, got, tc.module)
+		}
+		if got := tc.repo.MajorModulePath(); got != tc.major {
+			t.Errorf("MajorModulePath of %+v = %q, want %q", tc.repo, got, tc.major)
+		}
+	}
+}
+
+func TestGitRepoCheck(t *testing.T) {
+	const ann = "Ann <ann@example.org>"
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "soterd.git")
+	testGit(t, dir)(ann, "init", "--quiet", "--bare", bare)
+
+	t.Setenv("SYNC_PRIV_PUB_TEST_TOKEN", "")
+	for _, tc := range []struct {
+		name  string
+		repo  *GitRepo
+		valid bool
+	}{
+		{"url", &GitRepo{Path: "git.example.org/soterd", URL: dir + "/{{.RepoPath}}.git"}, true},
+		{"bad url template", &GitRepo{Path: "git.example.org/soterd", URL: dir + "/{{.Repo}}.git"}, false},
+		{"missing repo", &GitRepo{Path: "git.example.org/soterwallet", URL: dir + "/{{.RepoPath}}.git"}, false},
+		{
+			name: "missing token",
+			repo: &GitRepo{
+				Path: "git.example.org/soterd",
+				URL:  dir + "/{{.RepoPath}}.git",
+				Auth: &Auth{Transport: HTTPS, TokenEnv: "SYNC_PRIV_PUB_TEST_TOKEN"},
+			},
+		},
+	} {
+		if err := tc.repo.Check(); (err == nil) != tc.valid {
+			t.Errorf("%s: Check returned %v", tc.name, err)
+		}
+	}
+}
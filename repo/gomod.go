@@ -0,0 +1,348 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// goModule is a go module of a synced tree
+type goModule struct {
+	// Directory of the module, relative to the tree
+	dir string
+	// Path to its go.mod file
+	file string
+	// Content of its go.mod file before the sync changed it
+	mod *tools.GoModFile
+}
+
+// updateGoModules updates every go module in dst (the one at its root, and nested ones like tools/go.mod):
+// each module is renamed under the Dest module path, requirements on modules of Source are changed
+// to their Dest names, replace directives, retractions and go.work files are mapped through the module paths of the
+// pair and its dependencies, and the pair's dependencies are replaced. Modules that changed requirements are tidied.
+// renamer is the path renamer of the pair, which the directories of go.work files are moved with, or nil.
+//
+// A module whose path is under the Source module path gets the same path under the Dest module path (so
+// github.com/soterium/soterd/tools becomes github.com/soteria-dag/soterd/tools). A module with any other path, like
+// an example module named example.com/demo in examples/go.mod, is named after its directory in the tree instead:
+// Dest.ModulePath() + "/examples". Its old path is mapped to that name wherever the other modules refer to it.
+func (r *RepoPair) updateGoModules(dst string, renamer *tools.Replacer, goEnv []string) error {
+	dirs, err := tools.FindGoMods(dst, diffExclude...)
+	if err != nil {
+		return fmt.Errorf("Failed to find go modules in %s: %s", dst, err)
+	}
+
+	// Module paths of the tree before the sync, and what they're renamed to
+	modules := make([]goModule, 0, len(dirs))
+	names := make(map[string]string)
+	for _, dir := range dirs {
+		file := filepath.Join(dst, filepath.FromSlash(dir), "go.mod")
+		mod, err := tools.GoReadMod(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read go module file %s: %s", file, err)
+		}
+
+		// Modules keep their place under the module path, so that their import paths get the same replacements as
+		// the rest of the tree. Others are named after their directory (see above).
+		name, isSource := r.renameModule(mod.Module.Path, nil)
+		if !isSource {
+			name = path.Join(r.Dest.ModulePath(), dir)
+		}
+
+		modules = append(modules, goModule{dir: dir, file: file, mod: mod})
+		names[mod.Module.Path] = name
+	}
+
+	// Replace directives, retractions and workspaces can refer to any module of the pair or its dependencies.
+	// Requirements on dependencies are replaced with go get instead, since their versions change too.
+	rename := func(p string) string {
+		renamed, _ := r.mapModule(p, names)
+		return renamed
+	}
+	renameRequire := func(p string) string {
+		renamed, _ := r.renameModule(p, names)
+		return renamed
+	}
+
+	// All modules are renamed before any is tidied, since modules that replace each other with their directories
+	// need each other's new names.
+	changed := make(map[string]bool)
+	for _, m := range modules {
+		in := moduleLocation(m.dir)
+		name := names[m.mod.Module.Path]
+		err := tools.GoSetMod(m.file, name, goEnv)
+		if err != nil {
+			return fmt.Errorf("Failed to set go module name to %s in %s: %s", name, m.file, err)
+		}
+
+		fmt.Printf("%s\tset module name%s\n", r.Dest.Path, in)
+
+		rewritten, err := tools.RewriteGoMod(m.file, tools.GoModMapping{
+			Module:  rename,
+			Require: renameRequire,
+			Dir:     moveDir(m.dir, renamer),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to rewrite go module file %s: %s", m.file, err)
+		}
+
+		if rewritten > 0 {
+			fmt.Printf("%s\trewrote %d requirements, replace and retract directives%s\n", r.Dest.Path, rewritten, in)
+			changed[m.dir] = true
+		}
+	}
+
+	err = r.updateGoWorks(dst, rename, renamer)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range modules {
+		in := moduleLocation(m.dir)
+		moduleDir := filepath.Dir(m.file)
+
+		// Nested modules get the dependencies that they require, with the major versions they require, and the root
+		// module gets all of the pair's dependencies
+		deps := make([][]string, 0, len(r.Dependencies))
+		for _, dep := range r.Dependencies {
+			required := false
+			for _, req := range m.mod.Require {
+				renamed, isDep := dep.destModulePath(req.Path)
+				if isDep {
+					deps = append(deps, []string{req.Path, renamed})
+					required = true
+				}
+			}
+
+			if !required && m.dir == "." {
+				old, new := dep.modulePaths()
+				deps = append(deps, []string{old, new})
+			}
+		}
+
+		// First, drop all old dependency names. We drop all of the old dependencies first, because "go get" attempts to
+		// resolve ~all~ modules before performing its operation.
+		for _, dep := range deps {
+			err := tools.GoDropMod(m.file, dep[0], goEnv)
+			if err != nil {
+				return fmt.Errorf("Failed to drop old go module dependency %s in %s: %s", dep[0], m.file, err)
+			}
+
+			fmt.Printf("%s\tdropped old dependency %s%s\n", r.Dest.Path, dep[0], in)
+		}
+
+		// Next, add the new dependency names
+		for _, dep := range deps {
+			err := tools.GoGetMod(moduleDir, dep[1], goEnv)
+			if err != nil {
+				return fmt.Errorf("Failed to get go module dependency %s%s: %s", dep[1], in, err)
+			}
+
+			fmt.Printf("%s\tadded new dependency %s%s\n", r.Dest.Path, dep[1], in)
+		}
+
+		if len(deps) == 0 && !changed[m.dir] {
+			continue
+		}
+
+		// Finally, we remove stale references to old dependencies and their related modules
+		err := tools.GoTidyMod(moduleDir, goEnv)
+		if err != nil {
+			return fmt.Errorf("Failed to tidy go module dependencies%s: %s", in, err)
+		}
+
+		fmt.Printf("%s\ttidied go module dependencies%s\n", r.Dest.Path, in)
+	}
+
+	return nil
+}
+
+// renameModule returns the Dest name of a module path of Source: names maps the modules of the synced tree to their
+// new names, and other paths under the Source module path keep their place under the Dest module path (see
+// destModulePath). The boolean is false for modules outside of Source, which are returned as they are.
+func (r *RepoPair) renameModule(p string, names map[string]string) (string, bool) {
+	if name, exists := names[p]; exists {
+		return name, true
+	}
+
+	return r.destModulePath(p)
+}
+
+// destModulePath returns the Dest path of a module or package path under the Source module path of the pair. Paths
+// under the module path with the Source major version get the Dest one instead, if the pair changes it, and other
+// paths keep their major version suffix. The boolean is false for paths outside of Source, which are returned as
+// they are.
+func (r *RepoPair) destModulePath(p string) (string, bool) {
+	old, new := r.modulePaths()
+	if isUnderModule(p, old) {
+		return new + strings.TrimPrefix(p, old), true
+	}
+
+	if isUnderModule(p, r.Source.ModulePath()) {
+		return r.Dest.ModulePath() + strings.TrimPrefix(p, r.Source.ModulePath()), true
+	}
+
+	return p, false
+}
+
+// mapModule returns the Dest name of a module path like renameModule, or the Dest module path of a dependency of the
+// pair (or of their own dependencies) that it's under. The boolean is false for modules outside of all of them.
+func (r *RepoPair) mapModule(p string, names map[string]string) (string, bool) {
+	renamed, mapped := r.renameModule(p, names)
+	if mapped {
+		return renamed, true
+	}
+
+	dep := r.dependencyOf(p)
+	if dep == nil {
+		return p, false
+	}
+
+	return dep.destModulePath(p)
+}
+
+// dependencyOf returns the dependency of the pair (or of its dependencies) whose Source module path p is under, or nil.
+// The longest module path wins, in case one dependency is under another.
+func (r *RepoPair) dependencyOf(p string) *RepoPair {
+	var dep *RepoPair
+	for _, d := range r.allDependencies() {
+		src := d.Source.ModulePath()
+		if isUnderModule(p, src) && (dep == nil || len(src) > len(dep.Source.ModulePath())) {
+			dep = d
+		}
+	}
+
+	return dep
+}
+
+// allDependencies returns the dependencies of the pair and their own dependencies, each once
+func (r *RepoPair) allDependencies() []*RepoPair {
+	all := make([]*RepoPair, 0)
+	seen := make(map[*RepoPair]bool)
+	deps := append([]*RepoPair{}, r.Dependencies...)
+	for len(deps) > 0 {
+		d := deps[0]
+		deps = append(deps[1:], d.Dependencies...)
+		if !seen[d] {
+			seen[d] = true
+			all = append(all, d)
+		}
+	}
+
+	return all
+}
+
+// updateGoWorks maps the replace directives of the go.work files in dst with rename, and moves the directories of
+// their use and replace directives with the renamer. Checksums of mapped modules are dropped from
+// go.work.sum files, since they're for the Source modules.
+func (r *RepoPair) updateGoWorks(dst string, rename func(string) string, renamer *tools.Replacer) error {
+	dirs, err := tools.FindGoWorks(dst, diffExclude...)
+	if err != nil {
+		return fmt.Errorf("Failed to find go workspaces in %s: %s", dst, err)
+	}
+
+	for _, dir := range dirs {
+		file := filepath.Join(dst, filepath.FromSlash(dir), "go.work")
+		rewritten, err := tools.RewriteGoWork(file, tools.GoModMapping{Module: rename, Dir: moveDir(dir, renamer)})
+		if err != nil {
+			return fmt.Errorf("Failed to rewrite go workspace %s: %s", file, err)
+		}
+
+		if rewritten > 0 {
+			fmt.Printf("%s\trewrote %d directives in %s\n", r.Dest.Path, rewritten, path.Join(dir, "go.work"))
+		}
+
+		sumFile := file + ".sum"
+		if _, err := os.Stat(sumFile); err != nil {
+			continue
+		}
+
+		dropped, err := tools.DropGoSums(sumFile, func(p string) bool {
+			return rename(p) != p
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to drop checksums of renamed modules from %s: %s", sumFile, err)
+		}
+
+		if dropped > 0 {
+			fmt.Printf("%s\tdropped %d checksums of renamed modules from %s\n", r.Dest.Path, dropped, path.Join(dir, "go.work.sum"))
+		}
+	}
+
+	return nil
+}
+
+// moveDir returns a function that moves the directories that go.mod or go.work files in the relative directory dir
+// refer to (ex: ../tools) with the renamer, when they're in the tree. Other directories are returned as they are.
+func moveDir(dir string, renamer *tools.Replacer) func(string) string {
+	return func(d string) string {
+		rel := path.Join(dir, filepath.ToSlash(d))
+		if renamer == nil || filepath.IsAbs(d) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return d
+		}
+
+		// Renames apply to files, so the directory is moved with its go.mod file
+		moved := path.Dir(renamer.ReplaceString(path.Join(rel, "go.mod")))
+		if moved == rel {
+			return d
+		}
+
+		to, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(moved))
+		if err != nil {
+			return d
+		}
+		to = filepath.ToSlash(to)
+
+		// Directories need to start with ./ or ../ in go.mod files
+		if !strings.HasPrefix(to, "../") {
+			to = "./" + to
+		}
+
+		return to
+	}
+}
+
+// isUnderModule returns true if p is the module path base, or a path under it
+func isUnderModule(p, base string) bool {
+	return p == base || strings.HasPrefix(p, base+"/")
+}
+
+// moduleLocation returns where the module in the relative directory dir is, for progress messages: nothing for the
+// root module, and " in <dir>/go.mod" for nested ones
+func moduleLocation(dir string) string {
+	if dir == "." {
+		return ""
+	}
+
+	return fmt.Sprintf(" in %s/go.mod", dir)
+}
+
+// moduleFiles returns the go.mod and go.sum files of the modules nested in the tree at root, and the go.work and
+// go.work.sum files of nested workspaces, relative to it. Like the ones at the root, they're updated by go commands
+// and structured edits rather than by replacements.
+func moduleFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+	for _, names := range [][]string{{"go.mod", "go.sum"}, {"go.work", "go.work.sum"}} {
+		find := tools.FindGoMods
+		if names[0] == "go.work" {
+			find = tools.FindGoWorks
+		}
+
+		dirs, err := find(root, diffExclude...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find %s files in %s: %s", names[0], root, err)
+		}
+
+		for _, dir := range dirs {
+			if dir != "." {
+				files = append(files, path.Join(dir, names[0]), path.Join(dir, names[1]))
+			}
+		}
+	}
+
+	return files, nil
+}
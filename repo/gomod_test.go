@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+func TestDestModulePath(t *testing.T) {
+	soterd := func(srcMajor, dstMajor int) *RepoPair {
+		return &RepoPair{
+			Source: GitRepo{Path: "github.com/soterium/soterd", Major: srcMajor},
+			Dest:   GitRepo{Path: "github.com/soteria-dag/soterd", Major: dstMajor},
+		}
+	}
+	vanity := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd", Module: "go.soterium.org/soterd"},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soterd"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		pair   *RepoPair
+		path   string
+		want   string
+		mapped bool
+	}{
+		{"module", soterd(0, 0), "github.com/soterium/soterd", "github.com/soteria-dag/soterd", true},
+		{"package", soterd(0, 0), "github.com/soterium/soterd/chaincfg", "github.com/soteria-dag/soterd/chaincfg", true},
+		{"suffix kept", soterd(0, 0), "github.com/soterium/soterd/v2/dag", "github.com/soteria-dag/soterd/v2/dag", true},
+		{"other module", soterd(0, 0), "github.com/soterium/soterdash", "github.com/soterium/soterdash", false},
+		{"outside", soterd(0, 0), "example.com/soterd", "example.com/soterd", false},
+		{"major added", soterd(1, 2), "github.com/soterium/soterd/dag", "github.com/soteria-dag/soterd/v2/dag", true},
+		{"major changed", soterd(2, 3), "github.com/soterium/soterd/v2/dag", "github.com/soteria-dag/soterd/v3/dag", true},
+		{"major dropped", soterd(3, 1), "github.com/soterium/soterd/v3", "github.com/soteria-dag/soterd", true},
+		// Paths of other major versions keep their suffix
+		{"other major", soterd(2, 3), "github.com/soterium/soterd/v4/dag", "github.com/soteria-dag/soterd/v4/dag", true},
+		{"v1 path of v2 pair", soterd(2, 3), "github.com/soterium/soterd/dag", "github.com/soteria-dag/soterd/dag", true},
+		{"vanity", vanity, "go.soterium.org/soterd/dag", "github.com/soteria-dag/soterd/dag", true},
+		{"vanity repo path", vanity, "github.com/soterium/soterd/dag", "github.com/soterium/soterd/dag", false},
+	} {
+		got, mapped := tc.pair.destModulePath(tc.path)
+		if got != tc.want || mapped != tc.mapped {
+			t.Errorf("%s: destModulePath(%q) = %q, %t, want %q, %t", tc.name, tc.path, got, mapped, tc.want, tc.mapped)
+		}
+	}
+}
+
+func TestCheckMajor(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst int
+		valid    bool
+	}{
+		{0, 0, true},
+		{1, 2, true},
+		{2, 2, true},
+		{0, 2, false},
+		{2, 0, false},
+		{-1, 2, false},
+	} {
+		r := &RepoPair{Source: GitRepo{Path: "a", Major: tc.src}, Dest: GitRepo{Path: "b", Major: tc.dst}}
+		if err := r.checkMajor(); (err == nil) != tc.valid {
+			t.Errorf("checkMajor of %d => %d returned %v", tc.src, tc.dst, err)
+		}
+	}
+}
+
+func TestMapModuleDependencies(t *testing.T) {
+	soterd := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd", Major: 2},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soterd", Major: 3},
+	}
+	wallet := &RepoPair{
+		Source:       GitRepo{Path: "github.com/soterium/soterwallet"},
+		Dest:         GitRepo{Path: "github.com/soteria-dag/soterwallet"},
+		Dependencies: []*RepoPair{soterd},
+	}
+	// A dependency under the module path of another one
+	nested := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterwallet/tools"},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/wallet-tools"},
+	}
+	sotertools := &RepoPair{
+		Source:       GitRepo{Path: "github.com/soterium/sotertools"},
+		Dest:         GitRepo{Path: "github.com/soteria-dag/sotertools"},
+		Dependencies: []*RepoPair{wallet, nested},
+	}
+	names := map[string]string{"github.com/soterium/sotertools/cmd/x": "github.com/soteria-dag/x"}
+
+	for _, tc := range []struct {
+		path   string
+		want   string
+		mapped bool
+	}{
+		{"github.com/soterium/sotertools", "github.com/soteria-dag/sotertools", true},
+		{"github.com/soterium/sotertools/cmd/x", "github.com/soteria-dag/x", true},
+		{"github.com/soterium/soterwallet", "github.com/soteria-dag/soterwallet", true},
+		{"github.com/soterium/soterwallet/tools/gen", "github.com/soteria-dag/wallet-tools/gen", true},
+		// Dependencies of dependencies
+		{"github.com/soterium/soterd/v2", "github.com/soteria-dag/soterd/v3", true},
+		{"example.com/other", "example.com/other", false},
+	} {
+		got, mapped := sotertools.mapModule(tc.path, names)
+		if got != tc.want || mapped != tc.mapped {
+			t.Errorf("mapModule(%q) = %q, %t, want %q, %t", tc.path, got, mapped, tc.want, tc.mapped)
+		}
+	}
+}
+
+func TestUpdateNestedGoModules(t *testing.T) {
+	if _, exists := tools.Which("go"); !exists {
+		t.Skip("The go command is needed to update go modules")
+	}
+
+	// The import paths are already replaced, as they are when go modules are updated
+	dst := writeTree(t, map[string]string{
+		"go.mod": "module github.com/soterium/soterd\n\ngo 1.21\n",
+		"doc.go": "package soterd\n",
+		"tools/go.mod": "module github.com/soterium/soterd/tools\n\ngo 1.21\n\n" +
+			"require github.com/soterium/soterd v0.0.0\n\nreplace github.com/soterium/soterd => ../\n",
+		"tools/tools.go": "package tools\n\nimport _ \"github.com/soteria-dag/soterd\"\n",
+		// A module outside of the source module path is named after its directory
+		"examples/go.mod": "module example.com/demo\n\ngo 1.21\n\n" +
+			"require github.com/soterium/soterd/tools v0.0.0\n\n" +
+			"replace (\n\tgithub.com/soterium/soterd => ../\n\tgithub.com/soterium/soterd/tools => ../tools\n)\n",
+		"examples/main.go": "package main\n\nimport _ \"github.com/soteria-dag/soterd/tools\"\n\nfunc main() {}\n",
+		// The go command ignores testdata, so its modules are left as they are
+		"testdata/go.mod": "module example.com/fixture\n\ngo 1.21\n",
+	})
+	goEnv := append(os.Environ(), "GOPATH="+t.TempDir(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off",
+		"GOTOOLCHAIN=local")
+
+	r := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd"},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soterd"},
+	}
+	err := r.updateGoModules(dst, nil, goEnv)
+	if err != nil {
+		t.Fatalf("updateGoModules failed: %s", err)
+	}
+
+	for dir, want := range map[string]struct {
+		module  string
+		require []string
+	}{
+		".":     {"github.com/soteria-dag/soterd", nil},
+		"tools": {"github.com/soteria-dag/soterd/tools", []string{"github.com/soteria-dag/soterd"}},
+		"examples": {
+			"github.com/soteria-dag/soterd/examples",
+			[]string{"github.com/soteria-dag/soterd", "github.com/soteria-dag/soterd/tools"},
+		},
+		"testdata": {"example.com/fixture", nil},
+	} {
+		mod, err := tools.GoReadMod(filepath.Join(dst, dir, "go.mod"))
+		if err != nil {
+			t.Errorf("Failed to read go.mod of %s: %s", dir, err)
+			continue
+		}
+
+		var require []string
+		for _, req := range mod.Require {
+			require = append(require, req.Path)
+		}
+		sort.Strings(require)
+		if mod.Module.Path != want.module || !reflect.DeepEqual(require, want.require) {
+			t.Errorf("Module in %s is %s requiring %q, want %s requiring %q", dir, mod.Module.Path, require,
+				want.module, want.require)
+		}
+	}
+
+	// Replace directives refer to the modules by their new names
+	data, err := ioutil.ReadFile(filepath.Join(dst, "examples", "go.mod"))
+	if err != nil || !strings.Contains(string(data), "github.com/soteria-dag/soterd/tools => ../tools") {
+		t.Errorf("Replace directives of examples/go.mod aren't renamed: %v\n%s", err, data)
+	}
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// HookStage is a stage of a sync that hooks run at
+type HookStage string
+
+const (
+	// After the Source and Dest repos are cloned to the staging area
+	HookPostClone HookStage = "post-clone"
+	// After the transformers of the pair changed the staged dest tree, for each synced tree (also with -check)
+	HookPostTransform HookStage = "post-transform"
+	// Before the changes to the dest tree are committed, for each synced tree
+	HookPreCommit HookStage = "pre-commit"
+	// After the dest tree (or the review branch) and its tags are pushed, for each synced tree
+	HookPostPush HookStage = "post-push"
+	// When the sync of the pair fails
+	HookOnFailure HookStage = "on-failure"
+)
+
+// Hook is an external command that's run at a stage of a sync, in the dest clone of the staging area.
+//
+// It gets the environment of go commands (which use the staging area as GOPATH), along with variables describing the
+// sync: SYNC_HOOK (the stage), SYNC_SOURCE_REPO, SYNC_DEST_REPO, SYNC_SOURCE_MODULE, SYNC_DEST_MODULE, SYNC_STAGING,
+// SYNC_SOURCE_DIR and SYNC_DEST_DIR (the clones), and depending on the stage SYNC_SOURCE_TREE, SYNC_DEST_TREE,
+// SYNC_SOURCE_COMMIT, SYNC_DEST_COMMIT, SYNC_DEST_BRANCH (where the commit was pushed), SYNC_PULL_REQUEST (with
+// -review) and SYNC_ERROR.
+type Hook struct {
+	// Stage the hook runs at
+	Stage HookStage
+	// The command and its arguments (ex: {"go", "generate", "./..."}, or {"sh", "-c", "make docs"}).
+	// Relative paths of commands are relative to the dest clone (ex: ./scripts/regenerate.sh).
+	Command []string
+}
+
+// String returns the command of the hook
+func (h *Hook) String() string {
+	return strings.Join(h.Command, " ")
+}
+
+// hookEnv returns the variables describing the sync of the pair to hooks, for the staging area and the clones in it
+func (r *RepoPair) hookEnv(staging, src, dst string) []string {
+	return []string{
+		"SYNC_SOURCE_REPO=" + r.Source.Path,
+		"SYNC_DEST_REPO=" + r.Dest.Path,
+		"SYNC_SOURCE_MODULE=" + r.Source.ModulePath(),
+		"SYNC_DEST_MODULE=" + r.Dest.ModulePath(),
+		"SYNC_STAGING=" + staging,
+		"SYNC_SOURCE_DIR=" + src,
+		"SYNC_DEST_DIR=" + dst,
+	}
+}
+
+// treeEnv returns the variables describing the synced trees of the target to hooks
+func treeEnv(t branchTarget) []string {
+	return []string{"SYNC_SOURCE_TREE=" + t.Source, "SYNC_DEST_TREE=" + t.Dest}
+}
+
+// checkHooks checks that the hooks of the pair have a known stage and a command
+func (r *RepoPair) checkHooks() error {
+	for _, h := range r.Hooks {
+		switch h.Stage {
+		case HookPostClone, HookPostTransform, HookPreCommit, HookPostPush, HookOnFailure:
+		default:
+			return fmt.Errorf("Unknown stage %q of hook %s of %s", h.Stage, h.String(), r.String())
+		}
+
+		if len(h.Command) == 0 {
+			return fmt.Errorf("The %s hook of %s has no command", h.Stage, r.String())
+		}
+	}
+
+	return nil
+}
+
+// runHooks runs the hooks of the pair for the stage in order, in dir, with the environment env and the variables vars
+// (ex: SYNC_SOURCE_COMMIT=...). It stops at the first hook that fails, and returns an error with its output.
+func (r *RepoPair) runHooks(stage HookStage, dir string, env []string, vars ...string) error {
+	env = append(append(append([]string{}, env...), vars...), "SYNC_HOOK="+string(stage))
+	for _, h := range r.Hooks {
+		if h.Stage != stage {
+			continue
+		}
+
+		output, err := tools.RunCommand(dir, h.Command, env)
+		if err != nil {
+			return fmt.Errorf("The %s hook %s failed: %s\n%s", stage, h.String(), err, output)
+		}
+
+		fmt.Printf("%s\tran %s hook %s\n", r.Dest.Path, stage, h.String())
+		if len(output) > 0 {
+			fmt.Print(string(output))
+		}
+	}
+
+	return nil
+}
+
+// runFailureHooks runs the on-failure hooks of the pair for the error of its sync, in the dest clone, or the staging
+// area if the dest repo wasn't cloned. Hooks that fail are reported, without hiding the error.
+func (r *RepoPair) runFailureHooks(syncErr error, staging, src, dst string, goEnv []string) {
+	dir := dst
+	if _, err := os.Stat(dst); len(dst) == 0 || err != nil {
+		dir = staging
+	}
+
+	if goEnv == nil {
+		goEnv = append(os.Environ(), r.hookEnv(staging, src, dst)...)
+	}
+
+	err := r.runHooks(HookOnFailure, dir, goEnv, "SYNC_ERROR="+syncErr.Error())
+	if err != nil {
+		fmt.Printf("%s\t%s\n", r.Dest.Path, err)
+	}
+}
@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// testHook returns a hook of the stage that appends the name and the expansion of the shell words to the log file
+func testHook(stage HookStage, log, name, words string) Hook {
+	return Hook{Stage: stage, Command: []string{"sh", "-c", `echo "` + name + ` ` + words + `" >> "` + log + `"`}}
+}
+
+// readLog returns the lines of the log file written by hooks
+func readLog(t *testing.T, log string) []string {
+	data, err := ioutil.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestCheckHooks(t *testing.T) {
+	for _, tc := range []struct {
+		hook  Hook
+		valid bool
+	}{
+		{Hook{Stage: HookPostClone, Command: []string{"make"}}, true},
+		{Hook{Stage: HookOnFailure, Command: []string{"./notify.sh"}}, true},
+		{Hook{Stage: "pre-push", Command: []string{"make"}}, false},
+		{Hook{Stage: HookPreCommit}, false},
+	} {
+		r := &RepoPair{Hooks: []Hook{tc.hook}}
+		if err := r.checkHooks(); (err == nil) != tc.valid {
+			t.Errorf("checkHooks of the %q hook %s returned %v", tc.hook.Stage, tc.hook.String(), err)
+		}
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	if _, exists := tools.Which("sh"); !exists {
+		t.Skip("The sh command is needed to run hooks")
+	}
+
+	staging := t.TempDir()
+	dst := filepath.Join(staging, "dst")
+	err := os.Mkdir(dst, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(staging, "hooks.log")
+
+	// Hooks of a stage run in the order they're listed, in the dest clone, and hooks of other stages don't run
+	r := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd"},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soterd", Module: "go.soteria.org/soterd"},
+		Hooks: []Hook{
+			testHook(HookPreCommit, log, "first", `$SYNC_HOOK $PWD $SYNC_SOURCE_COMMIT`),
+			testHook(HookPostClone, log, "clone", `$SYNC_HOOK`),
+			testHook(HookPreCommit, log, "second",
+				`$SYNC_SOURCE_REPO $SYNC_DEST_MODULE $SYNC_STAGING $SYNC_DEST_DIR $SYNC_SOURCE_TREE $SYNC_DEST_TREE`),
+		},
+	}
+	env := append(os.Environ(), r.hookEnv(staging, filepath.Join(staging, "src"), dst)...)
+	target := branchTarget{Source: "master", Dest: "main"}
+
+	err = r.runHooks(HookPreCommit, dst, env, append(treeEnv(target), "SYNC_SOURCE_COMMIT=0123abc")...)
+	if err != nil {
+		t.Fatalf("runHooks failed: %s", err)
+	}
+	want := []string{
+		"first pre-commit " + dst + " 0123abc",
+		"second github.com/soterium/soterd go.soteria.org/soterd " + staging + " " + dst + " master main",
+	}
+	if got := readLog(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("pre-commit hooks logged %q, want %q", got, want)
+	}
+
+	// A hook that fails stops the hooks after it, and its output is in the error
+	os.Remove(log)
+	r.Hooks = []Hook{
+		{Stage: HookPostTransform, Command: []string{"sh", "-c", "echo go.sum is stale; exit 3"}},
+		testHook(HookPostTransform, log, "after", `$SYNC_HOOK`),
+	}
+	err = r.runHooks(HookPostTransform, dst, env)
+	if err == nil || !strings.Contains(err.Error(), "go.sum is stale") {
+		t.Errorf("runHooks with a failing hook returned %v, want its output", err)
+	}
+	if got := readLog(t, log); got != nil {
+		t.Errorf("Hooks after the failing one logged %q", got)
+	}
+}
+
+func TestRunFailureHooks(t *testing.T) {
+	if _, exists := tools.Which("sh"); !exists {
+		t.Skip("The sh command is needed to run hooks")
+	}
+
+	staging := t.TempDir()
+	log := filepath.Join(staging, "hooks.log")
+	r := &RepoPair{
+		Dest: GitRepo{Path: "github.com/soteria-dag/soterd"},
+		Hooks: []Hook{
+			{Stage: HookOnFailure, Command: []string{"sh", "-c", "exit 1"}},
+			testHook(HookOnFailure, log, "failed", `$PWD $SYNC_DEST_DIR $SYNC_ERROR`),
+		},
+	}
+
+	// An on-failure hook that fails is only reported, and like hooks of other stages, it stops the ones after it
+	dst := filepath.Join(staging, "dst")
+	r.runFailureHooks(os.ErrNotExist, staging, filepath.Join(staging, "src"), dst, nil)
+	if got := readLog(t, log); got != nil {
+		t.Errorf("on-failure hooks after a failing one logged %q", got)
+	}
+
+	// The dest repo wasn't cloned, so the hooks run in the staging area
+	r.Hooks = r.Hooks[1:]
+	r.runFailureHooks(os.ErrNotExist, staging, filepath.Join(staging, "src"), dst, nil)
+	want := []string{"failed " + staging + " " + dst + " " + os.ErrNotExist.Error()}
+	if got := readLog(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("on-failure hooks without a dest clone logged %q, want %q", got, want)
+	}
+
+	// Once it's cloned, they run in the dest clone
+	os.Remove(log)
+	err := os.Mkdir(dst, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.runFailureHooks(os.ErrNotExist, staging, filepath.Join(staging, "src"), dst, nil)
+	want = []string{"failed " + dst + " " + dst + " " + os.ErrNotExist.Error()}
+	if got := readLog(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("on-failure hooks with a dest clone logged %q, want %q", got, want)
+	}
+}
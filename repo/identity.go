@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// mapIdentities returns the identities ("name <email>") mapped through the mailmap of the pair, sorted and without
+// duplicates. Identities that aren't mapped are kept, unless the pair's mailmap is strict.
+func (r *RepoPair) mapIdentities(ids []string) ([]string, error) {
+	mailmap, err := tools.ParseMailmap(r.Mailmap)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse mailmap of %s: %s", r.String(), err)
+	}
+
+	seen := make(map[string]bool)
+	mapped := make([]string, 0, len(ids))
+	unmapped := make([]string, 0)
+	for _, id := range ids {
+		name, email, err := tools.ParseIdentity(id)
+		if err != nil {
+			return nil, err
+		}
+
+		name, email, found := mailmap.Map(name, email)
+		if !found {
+			unmapped = append(unmapped, id)
+		}
+
+		id = fmt.Sprintf("%s <%s>", name, email)
+		if !seen[id] {
+			seen[id] = true
+			mapped = append(mapped, id)
+		}
+	}
+	sort.Strings(mapped)
+
+	if r.StrictMailmap && len(unmapped) > 0 {
+		return nil, fmt.Errorf("Authors of synced commits of %s aren't in its mailmap: %s", r.String(), strings.Join(unmapped, ", "))
+	}
+
+	return mapped, nil
+}
+
+// mapCommitter returns the name and email that sync commits are attributed to (the -u and -e flags) mapped through the
+// mailmap of the pair, so that they're the public identity of the user running the sync. Without an email, there's
+// nothing to map them by, so they're kept. Unlike the authors of synced commits, they're kept if they aren't mapped,
+// since they're given explicitly.
+func (r *RepoPair) mapCommitter(name, email string) (string, string, error) {
+	if len(email) == 0 {
+		return name, email, nil
+	}
+
+	mailmap, err := tools.ParseMailmap(r.Mailmap)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to parse mailmap of %s: %s", r.String(), err)
+	}
+
+	name, email, _ = mailmap.Map(name, email)
+	return name, email, nil
+}
+
+// mapPatchAuthor returns the patch (in mailbox format) with its author, in the From: header that git am applies it
+// with, mapped through the mailmap of the pair. It fails if the author isn't mapped and the pair's mailmap is strict.
+func (r *RepoPair) mapPatchAuthor(patch []byte) ([]byte, error) {
+	mailmap, err := tools.ParseMailmap(r.Mailmap)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse mailmap of %s: %s", r.String(), err)
+	}
+
+	return rewritePatchAuthor(patch, func(name, email string) (string, string, error) {
+		mappedName, mappedEmail, found := mailmap.Map(name, email)
+		if !found && r.StrictMailmap {
+			return "", "", fmt.Errorf("Author %s <%s> of an imported commit isn't in the mailmap of %s", name, email, r.String())
+		}
+
+		return mappedName, mappedEmail, nil
+	})
+}
+
+// rewritePatchAuthor returns the patch with the name and email of its From: header replaced by what mapAuthor returns
+// for them. Long headers folded on several lines, and names encoded as RFC 2047 words or quoted, are decoded first.
+func rewritePatchAuthor(patch []byte, mapAuthor func(name, email string) (string, string, error)) ([]byte, error) {
+	lines := strings.SplitAfter(string(patch), "\n")
+	for i := 0; i < len(lines) && lines[i] != "\n"; i++ {
+		if !strings.HasPrefix(lines[i], "From: ") {
+			continue
+		}
+
+		// The header goes on over the lines starting with whitespace
+		end := i + 1
+		for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t")) {
+			end++
+		}
+		value := strings.TrimPrefix(strings.Join(lines[i:end], ""), "From: ")
+		value = strings.Join(strings.Fields(value), " ")
+
+		decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid From: header %q in patch: %s", value, err)
+		}
+
+		name, email, err := tools.ParseIdentity(decoded)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid From: header %q in patch: %s", value, err)
+		}
+		if len(name) > 1 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) {
+			name = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(name[1 : len(name)-1])
+		}
+
+		name, email, err = mapAuthor(name, email)
+		if err != nil {
+			return nil, err
+		}
+
+		header := fmt.Sprintf("From: %s <%s>\n", encodeHeaderName(name), email)
+		lines = append(lines[:i], append([]string{header}, lines[end:]...)...)
+		break
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// encodeHeaderName returns the name as it's written in a mail header: encoded as an RFC 2047 word if it isn't ASCII,
+// and quoted if it has characters that are special in headers
+func encodeHeaderName(name string) string {
+	for _, c := range name {
+		if c > unicode.MaxASCII {
+			return mime.QEncoding.Encode("utf-8", name)
+		}
+	}
+
+	if strings.ContainsAny(name, `()<>[]:;@\,."`) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	return name
+}
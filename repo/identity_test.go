@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRewritePatchAuthor(t *testing.T) {
+	patch := func(from string) string {
+		return "From 0123456789abcdef Mon Sep 17 00:00:00 2001\n" + from +
+			"Date: Wed, 1 Jan 2020 00:00:00 +0000\nSubject: [PATCH] Fix\n\nBody\nFrom: not a header\n---\n"
+	}
+	upper := func(name, email string) (string, string, error) {
+		return strings.ToUpper(name), "mapped@example.org", nil
+	}
+
+	for _, tc := range []struct {
+		name string
+		from string
+		want string
+	}{
+		{
+			name: "plain",
+			from: "From: Jane Doe <jane@example.org>\n",
+			want: "From: JANE DOE <mapped@example.org>\n",
+		},
+		{
+			name: "quoted",
+			from: "From: \"Doe, Jane\" <jane@example.org>\n",
+			want: "From: \"DOE, JANE\" <mapped@example.org>\n",
+		},
+		{
+			name: "encoded",
+			from: "From: =?UTF-8?q?J=C3=A9r=C3=B4me?= <jerome@example.org>\n",
+			want: "From: =?utf-8?q?J=C3=89R=C3=94ME?= <mapped@example.org>\n",
+		},
+		{
+			name: "folded",
+			from: "From: A Very Long Name\n <long@example.org>\n",
+			want: "From: A VERY LONG NAME <mapped@example.org>\n",
+		},
+	} {
+		got, err := rewritePatchAuthor([]byte(patch(tc.from)), upper)
+		if err != nil || string(got) != patch(tc.want) {
+			t.Errorf("%s: rewritePatchAuthor returned %q, %v, want %q", tc.name, got, err, patch(tc.want))
+		}
+	}
+
+	_, err := rewritePatchAuthor([]byte(patch("From: Jane <jane@example.org>\n")), func(name, email string) (string, string, error) {
+		return "", "", fmt.Errorf("unmapped")
+	})
+	if err == nil {
+		t.Errorf("rewritePatchAuthor didn't fail when mapping failed")
+	}
+}
+
+func TestMapPatchAuthor(t *testing.T) {
+	r := &RepoPair{Mailmap: "Jane Doe <jane@example.org> <jane.doe@corp.example.com>"}
+	got, err := r.mapPatchAuthor([]byte("From 0123 Mon Sep 17 00:00:00 2001\nFrom: Jane <jane.doe@corp.example.com>\n\nBody\n"))
+	if err != nil || !strings.Contains(string(got), "\nFrom: Jane Doe <jane@example.org>\n") {
+		t.Errorf("mapPatchAuthor returned %q, %v", got, err)
+	}
+
+	// Authors that aren't mapped are kept, unless the mailmap is strict
+	unmapped := []byte("From 0123 Mon Sep 17 00:00:00 2001\nFrom: Bob <bob@example.org>\n\nBody\n")
+	got, err = r.mapPatchAuthor(unmapped)
+	if err != nil || string(got) != string(unmapped) {
+		t.Errorf("mapPatchAuthor of an unmapped author returned %q, %v", got, err)
+	}
+
+	r.StrictMailmap = true
+	_, err = r.mapPatchAuthor(unmapped)
+	if err == nil {
+		t.Errorf("mapPatchAuthor of an unmapped author didn't fail with a strict mailmap")
+	}
+}
+
+func TestMapCommitter(t *testing.T) {
+	r := &RepoPair{Mailmap: "Sync Bot <sync-bot@example.org> <build@corp.example.com>", StrictMailmap: true}
+	for _, tc := range []struct {
+		name, email         string
+		wantName, wantEmail string
+	}{
+		{"", "build@corp.example.com", "Sync Bot", "sync-bot@example.org"},
+		{"Build", "build@corp.example.com", "Sync Bot", "sync-bot@example.org"},
+		{"Ann", "ann@example.org", "Ann", "ann@example.org"},
+		{"Ann", "", "Ann", ""},
+	} {
+		name, email, err := r.mapCommitter(tc.name, tc.email)
+		if err != nil || name != tc.wantName || email != tc.wantEmail {
+			t.Errorf("mapCommitter(%q, %q) = %q, %q, %v, want %q, %q", tc.name, tc.email, name, email, err,
+				tc.wantName, tc.wantEmail)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// Import imports commits made to the Dest repo since its last sync (such as external contributions) into the Source repo.
+//
+// For each synced branch, the dest commits after the last sync commit are turned into patches, the replacements
+// of the pair are applied to them in reverse, and they're applied with their original authors (mapped through the
+// pair's mailmap) to a new branch in the Source repo, starting from the source commit of the last sync. The branch is
+// then pushed for review.
+func (r *RepoPair) Import(keepStaging, skipAsk bool, emailAddr, userName string) error {
+	staging, err := ioutil.TempDir("", "sync_priv_pub-")
+	if err != nil {
+		return fmt.Errorf("Failed to create staging staging: %s", err)
+	}
+
+	// If we encounter an error, we'll leave the staging area behind
+	cleanup := true
+	defer func() {
+		if cleanup && !keepStaging {
+			_ = os.RemoveAll(staging)
+		}
+	}()
+
+	src, dst, _, err := r.clone(staging)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	srcBranches, err := tools.GitBranches(src, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return fmt.Errorf("Failed to list remote branches for %s: %s", src, err)
+	}
+
+	targets, err := r.branchTargets(srcBranches)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	// Imported commits keep their authors, but are committed by the user running the import
+	if len(emailAddr) > 0 {
+		err = tools.GitEmail(src, emailAddr)
+		if err != nil {
+			cleanup = false
+			return fmt.Errorf("Failed to set git config email address in %s to %s: %s", src, emailAddr, err)
+		}
+	}
+
+	if len(userName) > 0 {
+		err = tools.GitUserName(src, userName)
+		if err != nil {
+			cleanup = false
+			return fmt.Errorf("Failed to set git config user name in %s to %s: %s", src, userName, err)
+		}
+	}
+
+	for _, t := range targets {
+		fmt.Printf("Importing %s tree %s to %s tree %s\n", r.Dest.Path, t.Dest, r.Source.Path, t.Source)
+
+		err = r.importTree(src, dst, t, skipAsk)
+		if err != nil {
+			cleanup = false
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importTree imports the commits on the dest tree of the target since its last sync, to a new branch in src
+func (r *RepoPair) importTree(src, dst string, t branchTarget, skipAsk bool) error {
+	destTree := path.Join(defaultGitRemote, t.Dest)
+	syncCommit, srcCommit, found, err := tools.GitLastTrailer(dst, destTree, syncTrailer)
+	if err != nil {
+		return fmt.Errorf("Failed to find last sync commit on %s in %s: %s", t.Dest, dst, err)
+	}
+	if !found {
+		fmt.Printf("%s\tno sync commit found on %s, nothing to import\n", r.Dest.Path, t.Dest)
+		return nil
+	}
+
+	commits, err := tools.GitRevList(dst, syncCommit, destTree)
+	if err != nil {
+		return fmt.Errorf("Failed to list commits after %s on %s in %s: %s", syncCommit, t.Dest, dst, err)
+	}
+	if len(commits) == 0 {
+		fmt.Printf("%s\tno commits on %s since sync commit %s\n", r.Dest.Path, t.Dest, syncCommit)
+		return nil
+	}
+
+	replacements, err := r.replacements(t)
+	if err != nil {
+		return err
+	}
+
+	inverted, err := tools.NewReplacer(invertReplacements(replacements))
+	if err != nil {
+		return err
+	}
+
+	// Files renamed by sync are moved back to their source paths
+	unrenamer, err := r.Mirror().renamer()
+	if err != nil {
+		return err
+	}
+
+	// The commits were made on top of the synced source commit, so that's where they're applied
+	branch := fmt.Sprintf("import/%s/%s", t.Dest, time.Now().UTC().Format("20060102-150405"))
+	err = tools.GitCheckoutNew(src, branch, srcCommit)
+	if err != nil {
+		return fmt.Errorf("Failed to create branch %s from %s on %s: %s", branch, srcCommit, r.Source.Path, err)
+	}
+
+	fmt.Println("Created branch", branch, "from", srcCommit, "in", src)
+
+	for _, c := range commits {
+		// Changes to go.mod and similar files are left out, because they're not renamed by Sync either
+		patch, err := tools.GitFormatPatch(dst, c, importExclude...)
+		if err != nil {
+			return fmt.Errorf("Failed to create patch for %s in %s: %s", c, dst, err)
+		}
+
+		if !bytes.Contains(patch, []byte("\ndiff --git ")) {
+			fmt.Printf("%s\tskipped %s, it only changes files excluded from renaming\n", r.Source.Path, c)
+			continue
+		}
+
+		// Files that sync doesn't make replacements in (binary, generated, excluded, etc) are applied as they are
+		kept, err := r.unreplacedFiles(dst, c, patch)
+		if err != nil {
+			return err
+		}
+
+		// Authors are mapped through the mailmap, like those of synced commits are
+		patch, err = r.mapPatchAuthor(patch)
+		if err != nil {
+			return fmt.Errorf("Failed to map author of %s: %s", c, err)
+		}
+
+		err = tools.GitAm(src, replacePatch(patch, inverted, unrenamer, kept))
+		if err != nil {
+			return fmt.Errorf("Failed to apply %s from %s to %s: %s", c, r.Dest.Path, branch, err)
+		}
+
+		fmt.Printf("%s\timported %s\n", r.Source.Path, c)
+	}
+
+	if !skipAsk {
+		// Ask the user if they want to push
+		fmt.Printf("About to push imported commits for %s to %s\n", r.Source.Path, branch)
+		ok, err := tools.AskUser()
+		if err != nil {
+			return fmt.Errorf("Failure while asking if push is ok: %s", err)
+		}
+
+		if !ok {
+			return fmt.Errorf("User aborted")
+		}
+	}
+
+	err = tools.GitPush(src, defaultGitRemote, branch)
+	if err != nil {
+		return fmt.Errorf("Failed to git-push %s to %s: %s", branch, r.Source.Path, err)
+	}
+
+	fmt.Printf("%s\timported commits pushed to %s %s for review\n", r.Source.Path, defaultGitRemote, branch)
+
+	return nil
+}
+
+// unreplacedFiles returns the files changed by the dest commit in the patch that sync doesn't make replacements in,
+// by their dest path: those that are excluded, too large, binary or generated (unless the policies of the pair rewrite
+// them), the same way the staged dest tree is replaced. Files are looked up in the commit, or its parent for the files
+// it deletes, which leaves dst checked out at the commit.
+func (r *RepoPair) unreplacedFiles(dst, commit string, patch []byte) (map[string]bool, error) {
+	kept := make(map[string]bool)
+	changed, deleted := patchFiles(patch)
+	for _, side := range []struct {
+		tree  string
+		files []string
+	}{{commit + "^", deleted}, {commit, changed}} {
+		if len(side.files) == 0 {
+			continue
+		}
+
+		err := tools.GitCheckout(dst, side.tree)
+		if err != nil {
+			return kept, fmt.Errorf("Failed to checkout to %s on %s: %s", side.tree, r.Dest.Path, err)
+		}
+
+		opts, err := r.replaceOptions(dst)
+		if err != nil {
+			return kept, err
+		}
+
+		attrs, err := tools.LoadAttributes(dst, opts.Exclude...)
+		if err != nil {
+			return kept, fmt.Errorf("Failed to read git attributes of %s in %s: %s", side.tree, dst, err)
+		}
+
+		for _, f := range side.files {
+			rewrites, err := opts.Rewrites(dst, f, attrs)
+			if err != nil {
+				return kept, fmt.Errorf("Failed to check %s of %s in %s: %s", f, side.tree, dst, err)
+			}
+			if !rewrites {
+				kept[f] = true
+			}
+		}
+	}
+
+	return kept, nil
+}
+
+// patchFiles returns the paths of the files that the patch changes (or adds), and of those that it deletes
+func patchFiles(patch []byte) ([]string, []string) {
+	changed := make([]string, 0)
+	deleted := make([]string, 0)
+	current := ""
+	for _, line := range strings.Split(string(patch), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = patchFilePath(line)
+			changed = append(changed, current)
+		case strings.HasPrefix(line, "deleted file mode ") && len(current) > 0:
+			changed = changed[:len(changed)-1]
+			deleted = append(deleted, current)
+			current = ""
+		case strings.HasPrefix(line, "@@"):
+			current = ""
+		}
+	}
+
+	return changed, deleted
+}
+
+// patchFilePath returns the path of the file after the change, from the diff --git line of a patch
+func patchFilePath(line string) string {
+	paths := strings.TrimPrefix(strings.TrimRight(line, "\n"), "diff --git a/")
+
+	// The paths are the same unless the file is renamed, which tells where they're split even if they have spaces
+	if n := (len(paths) - 3) / 2; n > 0 && len(paths) == 2*n+3 && paths[n:n+3] == " b/" && paths[:n] == paths[n+3:] {
+		return paths[n+3:]
+	}
+
+	if cut := strings.LastIndex(paths, " b/"); cut >= 0 {
+		return paths[cut+3:]
+	}
+
+	return paths
+}
+
+// replacePatch applies the rules of the replacer to a patch in mailbox format,
+// except for the headers that identify the original commit, author and date.
+// The paths of the changed files are renamed with the renamer instead, if it isn't nil.
+// The changes to the kept files (by their path in the patch) are left as they are.
+func replacePatch(patch []byte, replacer, renamer *tools.Replacer, kept map[string]bool) []byte {
+	lines := strings.SplitAfter(string(patch), "\n")
+	inHeader := true
+	inFile := false
+	inKept := false
+	keep := false
+	for i, line := range lines {
+		if inHeader {
+			if line == "\n" {
+				inHeader = false
+				continue
+			}
+
+			// Long headers continue on lines starting with whitespace
+			continued := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+			if !continued {
+				keep = strings.HasPrefix(line, "From ") || strings.HasPrefix(line, "From: ") || strings.HasPrefix(line, "Date: ")
+			}
+			if keep {
+				continue
+			}
+		}
+
+		// The header of each changed file runs from its diff line to its first hunk
+		if strings.HasPrefix(line, "diff --git ") {
+			inFile = true
+			inKept = kept[patchFilePath(line)]
+		} else if strings.HasPrefix(line, "@@") {
+			inFile = false
+		}
+
+		if inFile && isPatchPath(line) {
+			if renamer != nil {
+				lines[i] = renamer.ReplaceString(line)
+			}
+			continue
+		}
+
+		if inKept {
+			continue
+		}
+
+		lines[i] = replacer.ReplaceString(line)
+	}
+
+	return []byte(strings.Join(lines, ""))
+}
+
+// isPatchPath returns true if the line of the header of a changed file in a patch names the file
+func isPatchPath(line string) bool {
+	for _, prefix := range []string{"diff --git ", "--- ", "+++ ", "rename from ", "rename to ", "copy from ", "copy to "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// testPatch is a patch of a dest commit, as git format-patch writes it, that changes, renames, deletes and adds files
+const testPatch = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Ann <ann@soteria-dag.example.org>
+Date: Wed, 1 Jan 2020 00:00:00 +0000
+Subject: [PATCH] Update soteria-dag docs, and a very long subject that
+ mentions soteria-dag on its second line
+
+The soteria-dag body.
+---
+ soteria-dag/a.go | 2 +-
+ logo.png          | Bin
+
+diff --git a/soteria-dag/a.go b/soteria-dag/a.go
+index 1111111..2222222 100644
+--- a/soteria-dag/a.go
++++ b/soteria-dag/a.go
+@@ -1 +1 @@
+-// soteria-dag old
++// soteria-dag new
+diff --git a/old name.txt b/new soteria-dag.txt
+similarity index 90%
+rename from old name.txt
+rename to new soteria-dag.txt
+@@ -1 +1 @@
+-soteria-dag
++soteria-dag!
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-soteria-dag
+diff --git a/kept.txt b/kept.txt
+new file mode 100644
+index 0000000..4444444
+--- /dev/null
++++ b/kept.txt
+@@ -0,0 +1 @@
++soteria-dag
+--
+2.40.0
+`
+
+func TestPatchFiles(t *testing.T) {
+	changed, deleted := patchFiles([]byte(testPatch))
+	wantChanged := []string{"soteria-dag/a.go", "new soteria-dag.txt", "kept.txt"}
+	wantDeleted := []string{"gone.txt"}
+	if !reflect.DeepEqual(changed, wantChanged) || !reflect.DeepEqual(deleted, wantDeleted) {
+		t.Errorf("patchFiles returned %q, %q, want %q, %q", changed, deleted, wantChanged, wantDeleted)
+	}
+}
+
+func TestPatchFilePath(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{"diff --git a/a.go b/a.go", "a.go"},
+		{"diff --git a/dir/a.go b/dir/a.go\n", "dir/a.go"},
+		{"diff --git a/old.go b/new.go", "new.go"},
+		// Paths with spaces are split where both sides are the same
+		{"diff --git a/a b/c.txt b/a b/c.txt", "a b/c.txt"},
+		{"diff --git a/with space.txt b/with space.txt", "with space.txt"},
+	} {
+		if got := patchFilePath(tc.line); got != tc.want {
+			t.Errorf("patchFilePath(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestReplacePatch(t *testing.T) {
+	replacer, err := tools.NewReplacer([][]string{{"soteria-dag", "soterium"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamer, err := tools.NewReplacer([][]string{{"soteria-dag/", "soterium/"}, {"soteria-dag.txt", "soterium.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(replacePatch([]byte(testPatch), replacer, renamer, map[string]bool{"kept.txt": true}))
+	want := `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Ann <ann@soteria-dag.example.org>
+Date: Wed, 1 Jan 2020 00:00:00 +0000
+Subject: [PATCH] Update soterium docs, and a very long subject that
+ mentions soterium on its second line
+
+The soterium body.
+---
+ soterium/a.go | 2 +-
+ logo.png          | Bin
+
+diff --git a/soterium/a.go b/soterium/a.go
+index 1111111..2222222 100644
+--- a/soterium/a.go
++++ b/soterium/a.go
+@@ -1 +1 @@
+-// soterium old
++// soterium new
+diff --git a/old name.txt b/new soterium.txt
+similarity index 90%
+rename from old name.txt
+rename to new soterium.txt
+@@ -1 +1 @@
+-soterium
++soterium!
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-soterium
+diff --git a/kept.txt b/kept.txt
+new file mode 100644
+index 0000000..4444444
+--- /dev/null
++++ b/kept.txt
+@@ -0,0 +1 @@
++soteria-dag
+--
+2.40.0
+`
+	if got != want {
+		t.Errorf("replacePatch returned:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without a renamer, the paths are left as they are
+	got = string(replacePatch([]byte(testPatch), replacer, nil, nil))
+	for _, line := range []string{"diff --git a/soteria-dag/a.go b/soteria-dag/a.go\n", "+++ b/kept.txt\n@@ -0,0 +1 @@\n+soterium\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("replacePatch without a renamer returned %q, want it to contain %q", got, line)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// leakScanner returns the scanner of the pair's Leaks, or nil if it has none
+func (r *RepoPair) leakScanner() (*tools.LeakScanner, error) {
+	s, err := tools.NewLeakScanner(r.Leaks)
+	if err != nil {
+		return nil, fmt.Errorf("Can't scan for leaks of %s: %s", r.String(), err)
+	}
+
+	return s, nil
+}
+
+// scanTree fails if the staged files in dst have text matching the pair's Leaks, after printing where
+func (r *RepoPair) scanTree(dst string) error {
+	s, err := r.leakScanner()
+	if err != nil {
+		return err
+	}
+
+	leaks, err := s.ScanTree(dst, diffExclude...)
+	if err != nil {
+		return fmt.Errorf("Failed to scan %s for leaks: %s", dst, err)
+	}
+
+	return r.checkLeaks(fmt.Sprintf("files of %s", r.Dest.Path), leaks)
+}
+
+// scanMessage fails if the message has text matching the pair's Leaks, after printing where. what describes the
+// message (ex: "commit message").
+func (r *RepoPair) scanMessage(what, msg string) error {
+	s, err := r.leakScanner()
+	if err != nil {
+		return err
+	}
+
+	return r.checkLeaks(what, s.ScanText(what, msg))
+}
+
+// checkLeaks prints the leaks found in what, and returns an error if there are any
+func (r *RepoPair) checkLeaks(what string, leaks []tools.Leak) error {
+	for _, l := range leaks {
+		fmt.Printf("%s\tleak in %s\n", r.Dest.Path, l)
+	}
+
+	if len(leaks) > 0 {
+		return fmt.Errorf("Found %d leaks in %s, which match Leaks of %s", len(leaks), what, r.String())
+	}
+
+	return nil
+}
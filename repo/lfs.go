@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// LFSPolicy determines what's done with the Git LFS pointer files of a source tree
+type LFSPolicy int
+
+const (
+	// Fail, so that a policy is chosen for the pair
+	LFSFail LFSPolicy = iota
+	// Sync the pointer files as they are, for dest repos that share the LFS server of the source repo, or whose
+	// objects are copied some other way
+	LFSPointers
+	// Copy the objects that the pointer files refer to from the LFS server of the source repo to the one of the dest repo,
+	// before pushing the commit that refers to them
+	LFSPush
+)
+
+// checkLFS fails if the files of the tree archived to dir include Git LFS pointer files, and the pair has no LFS policy.
+// With a policy, pointer files are synced as they are, since they're never rewritten by replacements.
+func (r *RepoPair) checkLFS(dir, tree string) error {
+	if r.LFS != LFSFail {
+		return nil
+	}
+
+	objects, err := tools.FindLFSObjects(dir, diffExclude...)
+	if err != nil {
+		return fmt.Errorf("Failed to find Git LFS pointers in %s: %s", dir, err)
+	}
+
+	if len(objects) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(objects))
+	for p := range objects {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	return fmt.Errorf("Tree %s of %s has Git LFS files (%s), which need an LFS policy", tree, r.Source.Path, strings.Join(paths, ", "))
+}
+
+// pushLFS copies the objects of the Git LFS pointer files in dst from the LFS server of Source to the one of Dest,
+// if the pair's policy is to push them
+func (r *RepoPair) pushLFS(dst string) error {
+	if r.LFS != LFSPush {
+		return nil
+	}
+
+	found, err := tools.FindLFSObjects(dst, diffExclude...)
+	if err != nil {
+		return fmt.Errorf("Failed to find Git LFS pointers in %s: %s", dst, err)
+	}
+
+	unique := make(map[string]tools.LFSObject)
+	for _, obj := range found {
+		unique[obj.Oid] = obj
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	objects := make([]tools.LFSObject, 0, len(unique))
+	for _, obj := range unique {
+		objects = append(objects, obj)
+	}
+
+	from, err := r.Source.lfsStore()
+	if err != nil {
+		return err
+	}
+
+	to, err := r.Dest.lfsStore()
+	if err != nil {
+		return err
+	}
+
+	copied, err := tools.CopyLFSObjects(from, to, objects)
+	if err != nil {
+		return fmt.Errorf("Failed to copy Git LFS objects from %s to %s: %s", from.URL, to.URL, err)
+	}
+
+	fmt.Printf("%s\tcopied %d of %d Git LFS objects to %s\n", r.Dest.Path, copied, len(objects), to.URL)
+	return nil
+}
+
+// lfsStore returns the Git LFS server of the repo, with the credentials of its auth settings
+func (g *GitRepo) lfsStore() (tools.LFSStore, error) {
+	u, err := g.LFSURL()
+	if err != nil {
+		return tools.LFSStore{}, err
+	}
+
+	auth, err := g.gitAuth()
+	if err != nil {
+		return tools.LFSStore{}, err
+	}
+
+	return tools.LFSStore{URL: u, Auth: auth}, nil
+}
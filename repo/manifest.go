@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// Revisions that are full commit hashes
+var commitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// updateManifests rewrites the glide (glide.yaml and glide.lock) and dep (Gopkg.toml and Gopkg.lock) manifests at the
+// root of dst, for repos that haven't moved to go modules: the package and the paths of dependencies are mapped through
+// the module paths of the pair and its dependencies, their repos are mapped to the dest repos, and revisions pinned to
+// source commits that were synced during the run are changed to the dest commits. It returns the names of the manifests
+// that changed.
+func (r *RepoPair) updateManifests(dst string) ([]string, error) {
+	m := tools.ManifestMapping{
+		Package: func(p string) string {
+			renamed, _ := r.mapModule(p, nil)
+			return renamed
+		},
+		Repo:     r.mapRepoURL,
+		Revision: r.mapRevision,
+	}
+
+	manifests := []struct {
+		name    string
+		rewrite func(string, tools.ManifestMapping) (int, error)
+	}{
+		{"glide.yaml", tools.RewriteGlide},
+		{"glide.lock", tools.RewriteGlide},
+		{"Gopkg.toml", tools.RewriteDep},
+		{"Gopkg.lock", tools.RewriteDep},
+	}
+
+	changedManifests := make([]string, 0)
+	for _, manifest := range manifests {
+		file := filepath.Join(dst, manifest.name)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		changed, err := manifest.rewrite(file, m)
+		if err != nil {
+			return changedManifests, fmt.Errorf("Failed to rewrite %s: %s", file, err)
+		}
+
+		if changed > 0 {
+			fmt.Printf("%s\trewrote %d lines of %s\n", r.Dest.Path, changed, manifest.name)
+			changedManifests = append(changedManifests, manifest.name)
+		}
+	}
+
+	return changedManifests, nil
+}
+
+// mapRepoURL returns the url of the dest repo of the pair or one of its dependencies, for a url of their source repo
+// (ex: git@github.com:soterium/soterd.git). Other urls are returned as they are.
+func (r *RepoPair) mapRepoURL(url string) string {
+	for _, p := range append([]*RepoPair{r}, r.allDependencies()...) {
+		// The repo path ends the url, so that repos whose path starts with it (ex: soterium/soterdash) don't match
+		repoPath := regexp.MustCompile(`[:/](` + regexp.QuoteMeta(p.Source.RepoPath()) + `)(\.git)?/?$`)
+		m := repoPath.FindStringSubmatchIndex(url)
+		if strings.Contains(url, p.Source.Host()) && m != nil {
+			url = url[:m[2]] + p.Dest.RepoPath() + url[m[3]:]
+			return strings.Replace(url, p.Source.Host(), p.Dest.Host(), 1)
+		}
+	}
+
+	return url
+}
+
+// mapRevision returns the dest commit that the source commit rev of the dependency at path p was synced to during the
+// run. Other revisions (ex: tags and branches) are returned as they are, with a warning for commits of dependencies
+// that weren't synced, since they don't exist in the dest repo.
+func (r *RepoPair) mapRevision(p, rev string) string {
+	dep := r.dependencyOf(p)
+	if dep == nil {
+		return rev
+	}
+
+	commit, synced := syncedCommits[dep.Source.Path][rev]
+	if synced {
+		return commit
+	}
+
+	if commitHash.MatchString(rev) {
+		fmt.Printf("%s\t%s is pinned to %s, which wasn't synced to %s in this run, so it's kept\n", r.Dest.Path, p, rev, dep.Dest.Path)
+	}
+
+	return rev
+}
@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestMapRepoURL(t *testing.T) {
+	soterd := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd"},
+		Dest:   GitRepo{Path: "gitlab.com/soteria-dag/soterd"},
+	}
+	r := &RepoPair{
+		Source:       GitRepo{Path: "github.com/soterium/soterwallet"},
+		Dest:         GitRepo{Path: "github.com/soteria-dag/soterwallet"},
+		Dependencies: []*RepoPair{soterd},
+	}
+
+	for _, tc := range []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/soterium/soterwallet", "https://github.com/soteria-dag/soterwallet"},
+		{"https://github.com/soterium/soterwallet.git", "https://github.com/soteria-dag/soterwallet.git"},
+		{"git@github.com:soterium/soterd.git", "git@gitlab.com:soteria-dag/soterd.git"},
+		{"ssh://git@github.com/soterium/soterd/", "ssh://git@gitlab.com/soteria-dag/soterd/"},
+		// Repos whose path starts with the path of a pair's repo aren't mapped
+		{"https://github.com/soterium/soterdash", "https://github.com/soterium/soterdash"},
+		{"https://github.com/soterium/soterd-fork.git", "https://github.com/soterium/soterd-fork.git"},
+		{"https://example.com/soterium/soterd", "https://example.com/soterium/soterd"},
+	} {
+		if got := r.mapRepoURL(tc.url); got != tc.want {
+			t.Errorf("mapRepoURL(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestMapRevision(t *testing.T) {
+	const synced, unsynced = "1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"
+	soterd := &RepoPair{
+		Source: GitRepo{Path: "github.com/soterium/soterd"},
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soterd"},
+	}
+	r := &RepoPair{
+		Source:       GitRepo{Path: "github.com/soterium/soterwallet"},
+		Dest:         GitRepo{Path: "github.com/soteria-dag/soterwallet"},
+		Dependencies: []*RepoPair{soterd},
+	}
+
+	prev := syncedCommits
+	defer func() { syncedCommits = prev }()
+	syncedCommits = map[string]map[string]string{"github.com/soterium/soterd": {synced: "3333333333333333333333333333333333333333"}}
+
+	for _, tc := range []struct {
+		path string
+		rev  string
+		want string
+	}{
+		{"github.com/soterium/soterd", synced, "3333333333333333333333333333333333333333"},
+		{"github.com/soterium/soterd/dag", synced, "3333333333333333333333333333333333333333"},
+		{"github.com/soterium/soterd", unsynced, unsynced},
+		{"github.com/soterium/soterd", "v0.1.0", "v0.1.0"},
+		// Only revisions of dependencies are mapped
+		{"example.com/other", synced, synced},
+	} {
+		if got := r.mapRevision(tc.path, tc.rev); got != tc.want {
+			t.Errorf("mapRevision(%q, %q) = %q, want %q", tc.path, tc.rev, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package repo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// CommitInfo is what commit message templates can refer to (ex: {{.Pair.Source.Path}} or {{range .Log}})
+type CommitInfo struct {
+	// The pair being synced
+	Pair *RepoPair
+	// The source git tree being synced
+	SourceTree string
+	// The dest git tree being synced to
+	DestTree string
+	// Source commit of the previous sync to the dest tree, or empty if there wasn't one
+	From string
+	// Source commit being synced
+	To string
+	// Abbreviated ids and subjects of the synced source commits, newest first, with the replacements of the pair applied
+	Log []string
+	// Summary of the files changed in the dest tree, as git diff --stat prints it
+	DiffStat string
+	// Authors of the synced source commits, as "name <email>" mapped through the mailmap of the pair, sorted
+	Authors []string
+	// Message given to Sync (the -m flag)
+	Message string
+}
+
+// commitMessage returns the message of the sync commit of the target, without its trailer: the CommitMessage template
+// of the pair rendered with the changes staged in dst, or msg (which can be a template itself) if the pair has none,
+// with Co-authored-by trailers if the pair adds them, and the message rules of the pair applied. base is the dest commit
+// before the sync, and srcCommit the source commit being synced.
+func (r *RepoPair) commitMessage(src, dst string, t branchTarget, base, srcCommit, msg string, replacer *tools.Replacer) (string, error) {
+	text := msg
+	if len(r.CommitMessage) > 0 {
+		text = r.CommitMessage
+	}
+
+	// Plain messages don't need the synced commits, which templates and authors do
+	isTemplate := strings.Contains(text, "{{")
+	var info *CommitInfo
+	if isTemplate || r.CoAuthors || r.StrictMailmap {
+		var err error
+		info, err = r.commitInfo(src, dst, t, base, srcCommit, msg, replacer)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if isTemplate {
+		tmpl, err := template.New(r.Dest.Path).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("Can't parse commit message template of %s: %s", r.String(), err)
+		}
+
+		// Only templates need the diffstat, which comes from the git command with either backend.
+		// The changes aren't committed yet, so the working tree is compared.
+		info.DiffStat, err = tools.GitDiffStat(dst, base, "")
+		if err != nil {
+			return "", fmt.Errorf("Failed to summarize changes in %s: %s", dst, err)
+		}
+
+		var out strings.Builder
+		err = tmpl.Execute(&out, info)
+		if err != nil {
+			return "", fmt.Errorf("Can't render commit message template of %s: %s", r.String(), err)
+		}
+		text = strings.TrimSpace(out.String())
+	}
+
+	// Co-authors are trailers like any other, which the message rules may leave out
+	if r.CoAuthors {
+		coAuthors := make([]string, 0, len(info.Authors))
+		for _, a := range info.Authors {
+			coAuthors = append(coAuthors, "Co-authored-by: "+a)
+		}
+		text = appendTrailers(text, coAuthors...)
+	}
+
+	scrubbed, err := r.MessageRules.Apply(text)
+	if err != nil {
+		return "", fmt.Errorf("Failed to apply message rules of %s: %s", r.String(), err)
+	}
+	if len(strings.TrimSpace(scrubbed)) == 0 {
+		return "", fmt.Errorf("Commit message of %s is empty, after its message rules were applied", r.String())
+	}
+
+	return scrubbed, nil
+}
+
+// appendTrailers returns the message with the trailers (ex: "Key: value") added to its last paragraph if it holds
+// trailers already, or in a new paragraph otherwise
+func appendTrailers(msg string, trailers ...string) string {
+	if len(trailers) == 0 {
+		return msg
+	}
+
+	if _, existing := splitTrailers(msg); existing == nil {
+		msg += "\n"
+	}
+
+	return msg + "\n" + strings.Join(trailers, "\n")
+}
+
+// commitInfo returns what commit message templates of the target can refer to, for the changes staged in dst, except
+// for the diffstat. The authors of the synced commits are mapped through the mailmap of the pair. msg is the message
+// given to Sync.
+func (r *RepoPair) commitInfo(src, dst string, t branchTarget, base, srcCommit, msg string, replacer *tools.Replacer) (*CommitInfo, error) {
+	from, log, err := r.syncedLog(src, dst, t, base, srcCommit, replacer)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := tools.GitAuthors(src, from, srcCommit)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list authors of synced commits of %s in %s: %s", t.Source, src, err)
+	}
+
+	authors, err = r.mapIdentities(authors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommitInfo{
+		Pair:       r,
+		SourceTree: t.Source,
+		DestTree:   t.Dest,
+		From:       from,
+		To:         srcCommit,
+		Log:        log,
+		Authors:    authors,
+		Message:    msg,
+	}, nil
+}
+
+// syncedLog returns the source commit of the last sync to the dest tree before base, if there was one, and the
+// abbreviated ids and subjects of the source commits synced since then up to srcCommit, with the replacements applied
+func (r *RepoPair) syncedLog(src, dst string, t branchTarget, base, srcCommit string, replacer *tools.Replacer) (string, []string, error) {
+	_, lastSrc, _, err := tools.GitLastTrailer(dst, base, syncTrailer)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to find last sync commit on %s in %s: %s", t.Dest, dst, err)
+	}
+
+	commits, err := tools.GitLogOneline(src, lastSrc, srcCommit)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to list synced commits of %s in %s: %s", t.Source, src, err)
+	}
+
+	for i, c := range commits {
+		commits[i] = replacer.ReplaceString(c)
+	}
+
+	return lastSrc, commits, nil
+}
+
+// MessageRules rewrite the messages of sync commits and tags before they're created, to scrub what's carried over from
+// the source repo (ex: internal ticket ids, private urls, reviewer names or Signed-off-by lines with corporate emails).
+// They're applied in the order of the fields.
+type MessageRules struct {
+	// Regular expressions of lines that are removed (ex: `^Reviewed-by: `, `https://jira\.example\.com/`)
+	DropLines []string
+	// Sets of {regular expression, replacement} that are replaced in the message, in order (ex: {`PRIV-\d+`, ""}).
+	// Replacements can refer to submatches ($1), and (?m) makes ^ and $ match at lines.
+	Rewrite [][]string
+	// Keys of the trailers that are kept (ex: "Co-authored-by"), ignoring case. If nil, all trailers are kept, and if
+	// it's empty, none are.
+	Trailers []string
+	// Number of characters the subject (first line) is cut to, if it's above 0
+	MaxSubject int
+	// Number of bytes the message (without its trailers) is cut to at a line, if it's above 0
+	MaxLength int
+}
+
+// Trailer lines of messages (ex: Signed-off-by: Name <email>), whose key is matched first
+var trailerLine = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*):\s`)
+
+// IsEmpty returns true if there are no rules
+func (m *MessageRules) IsEmpty() bool {
+	return len(m.DropLines) == 0 && len(m.Rewrite) == 0 && m.Trailers == nil && m.MaxSubject <= 0 && m.MaxLength <= 0
+}
+
+// Apply returns the message with the rules applied. Blank lines left at its start or end, or repeated by dropped lines,
+// are removed.
+func (m *MessageRules) Apply(msg string) (string, error) {
+	if m.IsEmpty() {
+		return msg, nil
+	}
+
+	drops := make([]*regexp.Regexp, 0, len(m.DropLines))
+	for _, d := range m.DropLines {
+		re, err := regexp.Compile(d)
+		if err != nil {
+			return "", fmt.Errorf("Invalid message rule %q: %s", d, err)
+		}
+		drops = append(drops, re)
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(msg, "\n") {
+		dropped := false
+		for _, re := range drops {
+			if re.MatchString(line) {
+				dropped = true
+				break
+			}
+		}
+
+		if !dropped {
+			lines = append(lines, line)
+		}
+	}
+	msg = strings.Join(lines, "\n")
+
+	for _, rw := range m.Rewrite {
+		if len(rw) != 2 {
+			return "", fmt.Errorf("Can't apply message rule (%s): Need to specify a regular expression and replacement", rw)
+		}
+
+		re, err := regexp.Compile(rw[0])
+		if err != nil {
+			return "", fmt.Errorf("Invalid message rule %q: %s", rw[0], err)
+		}
+		msg = re.ReplaceAllString(msg, rw[1])
+	}
+
+	body, trailers := splitTrailers(compactLines(msg))
+	if m.Trailers != nil {
+		kept := make([]string, 0, len(trailers))
+		for _, t := range trailers {
+			key := trailerLine.FindStringSubmatch(t)[1]
+			for _, allowed := range m.Trailers {
+				if strings.EqualFold(key, allowed) {
+					kept = append(kept, t)
+					break
+				}
+			}
+		}
+		trailers = kept
+	}
+
+	lines = strings.Split(body, "\n")
+	if subject := []rune(lines[0]); m.MaxSubject > 0 && len(subject) > m.MaxSubject {
+		lines[0] = strings.TrimSpace(string(subject[:m.MaxSubject]))
+	}
+
+	if m.MaxLength > 0 {
+		length := 0
+		for i, line := range lines {
+			length += len(line) + 1
+			if length-1 > m.MaxLength {
+				if i == 0 {
+					lines[0] = strings.ToValidUTF8(line[:m.MaxLength], "")
+					i = 1
+				}
+				lines = lines[:i]
+				break
+			}
+		}
+	}
+	body = compactLines(strings.Join(lines, "\n"))
+
+	if len(trailers) == 0 {
+		return body, nil
+	}
+
+	return compactLines(body + "\n\n" + strings.Join(trailers, "\n")), nil
+}
+
+// splitTrailers returns the message without its trailers (the lines of its last paragraph, if they're all trailers
+// and it isn't the subject), and the trailers
+func splitTrailers(msg string) (string, []string) {
+	cut := strings.LastIndex(msg, "\n\n")
+	if cut < 0 {
+		return msg, nil
+	}
+
+	trailers := strings.Split(msg[cut+2:], "\n")
+	for _, t := range trailers {
+		if !trailerLine.MatchString(t) {
+			return msg, nil
+		}
+	}
+
+	return msg[:cut], trailers
+}
+
+// compactLines returns the text without blank lines at its start or end, or more than one blank line in a row, and
+// without trailing whitespace on its lines
+func compactLines(text string) string {
+	lines := make([]string, 0)
+	blank := true
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if len(line) == 0 && blank {
+			continue
+		}
+
+		lines = append(lines, line)
+		blank = len(line) == 0
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
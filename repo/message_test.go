@@ -0,0 +1,303 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// testGit returns a function running git with the arguments in dir, and returning its output. The commits it makes
+// are by the author, which is a "name <email>" identity.
+func testGit(t *testing.T, dir string) func(author string, args ...string) string {
+	if _, exists := tools.Which("git"); !exists {
+		t.Skip("The git command is needed to create test repos")
+	}
+
+	return func(author string, args ...string) string {
+		addr, err := mail.ParseAddress(author)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+addr.Name, "GIT_AUTHOR_EMAIL="+addr.Address,
+			"GIT_COMMITTER_NAME=Committer", "GIT_COMMITTER_EMAIL=committer@example.org",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err, output)
+		}
+
+		return strings.TrimSpace(string(output))
+	}
+}
+
+func TestCommitMessageTemplate(t *testing.T) {
+	const ann, bob = "Ann <ann@example.org>", "Bob <bob@example.org>"
+	src, dst := t.TempDir(), t.TempDir()
+	srcGit, dstGit := testGit(t, src), testGit(t, dst)
+	write := func(dir, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srcGit(ann, "init", "--quiet")
+	write(src, "a\n")
+	srcGit(ann, "add", "-A")
+	srcGit(ann, "commit", "--quiet", "-m", "Add soterium feature")
+	first := srcGit(ann, "rev-parse", "HEAD")
+	write(src, "a\nb\n")
+	srcGit(bob, "commit", "--quiet", "-a", "-m", "Fix soterium bug")
+	second := srcGit(bob, "rev-parse", "HEAD")
+	abbrev := srcGit(bob, "log", "-1", "--format=%h")
+
+	// The dest repo was synced to the first commit, and has the changes of the second one staged
+	dstGit(ann, "init", "--quiet")
+	write(dst, "a\n")
+	dstGit(ann, "add", "-A")
+	dstGit(ann, "commit", "--quiet", "-m", "Sync\n\n"+syncTrailer+": "+first)
+	base := dstGit(ann, "rev-parse", "HEAD")
+	write(dst, "a\nb\n")
+
+	r := &RepoPair{
+		Dest:          GitRepo{Path: "github.com/soteria-dag/soterd"},
+		CommitMessage: "Sync {{.DestTree}} of {{.Pair.Dest.Path}}\n\n{{range .Log}}- {{.}}\n{{end}}\n{{.Message}}\n\n{{.DiffStat}}\n\n{{range .Authors}}{{.}}\n{{end}}",
+	}
+	replacer, err := tools.NewReplacer([][]string{{"soterium", "soteria-dag"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.commitMessage(src, dst, branchTarget{Source: "master", Dest: "main"}, base, second, "Scheduled sync", replacer)
+	if err != nil {
+		t.Fatalf("commitMessage returned %v", err)
+	}
+
+	want := "Sync main of github.com/soteria-dag/soterd\n\n- " + abbrev + " Fix soteria-dag bug\n\nScheduled sync\n\n"
+	if !strings.HasPrefix(msg, want) || !strings.Contains(msg, "a.txt | 1 +") || !strings.HasSuffix(msg, "\n\n"+bob) {
+		t.Errorf("commitMessage returned %q, want it to start with %q, list the changed files and end with the author", msg, want)
+	}
+
+	// Templates that can't be rendered fail the message
+	r.CommitMessage = "{{.Missing}}"
+	_, err = r.commitMessage(src, dst, branchTarget{Source: "master", Dest: "main"}, base, second, "", replacer)
+	if err == nil {
+		t.Errorf("commitMessage didn't fail for a template referring to a missing field")
+	}
+}
+
+func TestCommitMessagePlain(t *testing.T) {
+	// Plain messages don't need the repos
+	r := &RepoPair{}
+	msg, err := r.commitMessage("", "", branchTarget{}, "", "", "Sync upstream", nil)
+	if err != nil || msg != "Sync upstream" {
+		t.Errorf("commitMessage returned %q, %v", msg, err)
+	}
+
+	// The pair's message wins over the given one
+	r.CommitMessage = "Sync from the private repo"
+	msg, err = r.commitMessage("", "", branchTarget{}, "", "", "Sync upstream", nil)
+	if err != nil || msg != r.CommitMessage {
+		t.Errorf("commitMessage returned %q, %v, want %q", msg, err, r.CommitMessage)
+	}
+
+	// The message rules scrub plain messages too
+	r = &RepoPair{MessageRules: MessageRules{DropLines: []string{`PRIV-`}}}
+	msg, err = r.commitMessage("", "", branchTarget{}, "", "", "Sync upstream\n\nPRIV-12", nil)
+	if err != nil || msg != "Sync upstream" {
+		t.Errorf("commitMessage returned %q, %v", msg, err)
+	}
+
+	// Messages left empty by the rules fail
+	_, err = r.commitMessage("", "", branchTarget{}, "", "", "PRIV-12", nil)
+	if err == nil {
+		t.Errorf("commitMessage didn't fail for a message left empty by the rules")
+	}
+}
+
+func TestCoAuthorTrailersFollowRules(t *testing.T) {
+	msg := appendTrailers("Sync upstream", "Co-authored-by: Ann <ann@example.org>", "Co-authored-by: Bob <bob@example.org>")
+	for _, tc := range []struct {
+		name  string
+		rules MessageRules
+		want  string
+	}{
+		{
+			name:  "no rules",
+			rules: MessageRules{},
+			want:  "Sync upstream\n\nCo-authored-by: Ann <ann@example.org>\nCo-authored-by: Bob <bob@example.org>",
+		},
+		{
+			name:  "no trailers kept",
+			rules: MessageRules{Trailers: []string{}},
+			want:  "Sync upstream",
+		},
+		{
+			name:  "other trailers kept",
+			rules: MessageRules{Trailers: []string{"Signed-off-by"}},
+			want:  "Sync upstream",
+		},
+		{
+			name:  "co-authors kept",
+			rules: MessageRules{Trailers: []string{"co-authored-by"}, DropLines: []string{`^Co-authored-by: Bob `}},
+			want:  "Sync upstream\n\nCo-authored-by: Ann <ann@example.org>",
+		},
+	} {
+		got, err := tc.rules.Apply(msg)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: Apply returned %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestMessageRulesApply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules MessageRules
+		msg   string
+		want  string
+	}{
+		{
+			name:  "no rules",
+			rules: MessageRules{},
+			msg:   "Subject\n\n\n\nBody  \n",
+			want:  "Subject\n\n\n\nBody  \n",
+		},
+		{
+			name:  "drop lines",
+			rules: MessageRules{DropLines: []string{`^Reviewed-by: `, `jira\.example\.com`}},
+			msg:   "Fix it\n\nSee https://jira.example.com/PRIV-1\n\nMore\n\nReviewed-by: Ann <ann@example.org>",
+			want:  "Fix it\n\nMore",
+		},
+		{
+			name:  "rewrite",
+			rules: MessageRules{Rewrite: [][]string{{`PRIV-(\d+)`, "#$1"}, {`(?m)^internal: `, ""}}},
+			msg:   "internal: Fix PRIV-12\n\ninternal: details",
+			want:  "Fix #12\n\ndetails",
+		},
+		{
+			name:  "trailers kept by key, ignoring case",
+			rules: MessageRules{Trailers: []string{"signed-off-by"}},
+			msg:   "Fix it\n\nSigned-off-by: Ann <ann@example.org>\nChange-Id: I123\nSIGNED-OFF-BY: Bob <bob@example.org>",
+			want:  "Fix it\n\nSigned-off-by: Ann <ann@example.org>\nSIGNED-OFF-BY: Bob <bob@example.org>",
+		},
+		{
+			name:  "last paragraph with other lines isn't trailers",
+			rules: MessageRules{Trailers: []string{}},
+			msg:   "Fix it\n\nSee: the issue\nfor details",
+			want:  "Fix it\n\nSee: the issue\nfor details",
+		},
+		{
+			// A message of a single trailer line is its subject, which is kept
+			name:  "trailers only",
+			rules: MessageRules{Trailers: []string{}},
+			msg:   "Signed-off-by: Ann <ann@example.org>",
+			want:  "Signed-off-by: Ann <ann@example.org>",
+		},
+		{
+			name:  "subject cut by characters",
+			rules: MessageRules{MaxSubject: 7},
+			msg:   "Réparer le problème\n\nBody",
+			want:  "Réparer\n\nBody",
+		},
+		{
+			name:  "subject cut at a space",
+			rules: MessageRules{MaxSubject: 4},
+			msg:   "Fix the bug",
+			want:  "Fix",
+		},
+		{
+			name:  "length cut at a line",
+			rules: MessageRules{MaxLength: 12},
+			msg:   "Fix it\n\nLong body\nmore\n\nSigned-off-by: Ann <ann@example.org>",
+			want:  "Fix it\n\nSigned-off-by: Ann <ann@example.org>",
+		},
+		{
+			// The subject is cut in bytes, without leaving part of a multibyte character
+			name:  "length cut in the subject mid-rune",
+			rules: MessageRules{MaxLength: 2},
+			msg:   "héllo\n\nBody",
+			want:  "h",
+		},
+		{
+			name:  "length not reached",
+			rules: MessageRules{MaxLength: 100},
+			msg:   "Fix it\n\nBody",
+			want:  "Fix it\n\nBody",
+		},
+		{
+			name:  "blank lines compacted",
+			rules: MessageRules{DropLines: []string{`^drop`}},
+			msg:   "\ndrop\nSubject  \ndrop\n\n\ndrop\n\nBody\n\n",
+			want:  "Subject\n\nBody",
+		},
+	} {
+		got, err := tc.rules.Apply(tc.msg)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: Apply returned %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestMessageRulesInvalid(t *testing.T) {
+	for _, rules := range []MessageRules{
+		{DropLines: []string{`(`}},
+		{Rewrite: [][]string{{`(`, ""}}},
+		{Rewrite: [][]string{{`PRIV-\d+`}}},
+	} {
+		_, err := rules.Apply("Fix it")
+		if err == nil {
+			t.Errorf("Apply with %+v didn't fail", rules)
+		}
+	}
+}
+
+func TestSplitTrailers(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		body     string
+		trailers []string
+	}{
+		{msg: "Fix it", body: "Fix it"},
+		{msg: "Fixes: #12", body: "Fixes: #12"},
+		{msg: "Fix it\n\nBody", body: "Fix it\n\nBody"},
+		{msg: "Fix it\n\nA: 1\nB-c: 2", body: "Fix it", trailers: []string{"A: 1", "B-c: 2"}},
+		{msg: "Fix it\n\nBody\n\nA: 1", body: "Fix it\n\nBody", trailers: []string{"A: 1"}},
+		{msg: "Fix it\n\nA: 1\nnot a trailer", body: "Fix it\n\nA: 1\nnot a trailer"},
+		{msg: "Fix it\n\nA:1", body: "Fix it\n\nA:1"},
+		{msg: "Fix it\n\n-A: 1", body: "Fix it\n\n-A: 1"},
+	} {
+		body, trailers := splitTrailers(tc.msg)
+		if body != tc.body || !reflect.DeepEqual(trailers, tc.trailers) {
+			t.Errorf("splitTrailers(%q) returned %q, %q, want %q, %q", tc.msg, body, trailers, tc.body, tc.trailers)
+		}
+	}
+}
+
+func TestAppendTrailers(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		trailers []string
+		want     string
+	}{
+		{msg: "Fix it", want: "Fix it"},
+		{msg: "Fix it", trailers: []string{"A: 1"}, want: "Fix it\n\nA: 1"},
+		{msg: "Fix it\n\nBody", trailers: []string{"A: 1", "B: 2"}, want: "Fix it\n\nBody\n\nA: 1\nB: 2"},
+		{msg: "Fix it\n\nA: 1", trailers: []string{"B: 2"}, want: "Fix it\n\nA: 1\nB: 2"},
+	} {
+		if got := appendTrailers(tc.msg, tc.trailers...); got != tc.want {
+			t.Errorf("appendTrailers(%q, %q) returned %q, want %q", tc.msg, tc.trailers, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// roundTripIssue describes a file that isn't restored to its original content
+// when the replacements of a pair are applied, followed by their inverse.
+type roundTripIssue struct {
+	// Path of the file, relative to the checked tree
+	File string
+	// Replacements whose new string was already in the file before they were applied.
+	// Reversing them also changes text that they didn't replace.
+	Lossy [][]string
+}
+
+// Mirror returns the pair that syncs in the opposite direction, from Dest to Source.
+//
+// Git trees, branch maps, tag maps and dependencies are swapped, and the custom replacements are inverted,
+// so that the mirror pair doesn't need to be declared (and kept up to date) separately. Transformers aren't mirrored,
+// since what they do can't be undone in general (ex: stripped regions), so the mirror pair runs the default ones.
+// Hooks, commit message templates, message rules, mailmaps, signing and source signature policies aren't mirrored
+// either, since they're specific to the direction of the sync. The mirror pair uses the message given to Sync.
+func (r *RepoPair) Mirror() *RepoPair {
+	m := &RepoPair{
+		Source:         r.Dest,
+		SourceGitTree:  r.DestGitTree,
+		Dest:           r.Source,
+		DestGitTree:    r.SourceGitTree,
+		Replace:        invertReplacements(r.Replace),
+		Rename:         invertReplacements(r.Rename),
+		WarnRoundTrip:  r.WarnRoundTrip,
+		TagSemver:      r.TagSemver,
+		ReplaceMaxSize: r.ReplaceMaxSize,
+		BinaryFiles:    r.BinaryFiles,
+		GeneratedFiles: r.GeneratedFiles,
+		Submodules:     r.Submodules,
+		LFS:            r.LFS,
+	}
+
+	for _, dep := range r.Dependencies {
+		m.Dependencies = append(m.Dependencies, dep.Mirror())
+	}
+
+	for _, b := range r.Branches {
+		m.Branches = append(m.Branches, BranchMap{Source: b.Dest, Dest: b.Source})
+	}
+
+	for _, t := range r.Tags {
+		m.Tags = append(m.Tags, TagMap{Source: t.Dest, Dest: t.Source})
+	}
+
+	return m
+}
+
+// LossyReplacements returns descriptions of the replacements of the pair that can't be reversed by its mirror pair,
+// regardless of the content being synced.
+func (r *RepoPair) LossyReplacements() ([]string, error) {
+	lossy := make([]string, 0)
+	replacements, err := r.replacements(branchTarget{Source: r.SourceGitTree, Dest: r.DestGitTree})
+	if err != nil {
+		return lossy, err
+	}
+
+	// The old strings that each new string replaces
+	olds := make(map[string][]string)
+	for _, replaceCase := range replacements {
+		old, new := replaceCase[0], replaceCase[1]
+		if len(new) == 0 {
+			lossy = append(lossy, fmt.Sprintf("%s => %s: removed text can't be restored", old, new))
+			continue
+		}
+
+		prev, exists := olds[new]
+		if exists && !contains(prev, old) {
+			lossy = append(lossy, fmt.Sprintf("%s => %s: %s is also replaced with %s", old, new, strings.Join(prev, ", "), new))
+		}
+		olds[new] = append(prev, old)
+	}
+
+	return lossy, nil
+}
+
+// checkRoundTrip applies the replacements of the target to the content of each file under path that sync would
+// rewrite (leaving out excluded and oversized files, and binary and generated files unless the pair rewrites them),
+// followed by their inverse, and returns the files that don't end up unchanged. Files on disk aren't modified.
+func (r *RepoPair) checkRoundTrip(path string, t branchTarget) ([]roundTripIssue, error) {
+	issues := make([]roundTripIssue, 0)
+	replacements, err := r.replacements(t)
+	if err != nil {
+		return issues, err
+	}
+
+	forward, err := tools.NewReplacer(replacements)
+	if err != nil {
+		return issues, err
+	}
+
+	inverted, err := tools.NewReplacer(invertReplacements(replacements))
+	if err != nil {
+		return issues, err
+	}
+
+	opts, err := r.replaceOptions(path)
+	if err != nil {
+		return issues, err
+	}
+
+	attrs, err := tools.LoadAttributes(path, opts.Exclude...)
+	if err != nil {
+		return issues, err
+	}
+
+	check := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || (opts.MaxSize > 0 && info.Size() > opts.MaxSize) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(path, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range opts.Exclude {
+			if tools.IsUnder(rel, x) {
+				return nil
+			}
+		}
+
+		in, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+
+		switch tools.ClassifyContent(rel, in, attrs) {
+		case tools.ReasonLFSPointer:
+			return nil
+		case tools.ReasonBinary, tools.ReasonAttributes:
+			if opts.Binary != tools.ContentRewrite {
+				return nil
+			}
+		case tools.ReasonGenerated:
+			if opts.Generated != tools.ContentRewrite {
+				return nil
+			}
+		}
+
+		out, _ := forward.Replace(in)
+		back, _ := inverted.Replace(out)
+		if bytes.Equal(back, in) {
+			return nil
+		}
+
+		// Find the replacements whose new string was already in the file
+		issue := roundTripIssue{File: rel}
+		_, found := inverted.Replace(in)
+		for i, rule := range inverted.Rules() {
+			if found[i] > 0 {
+				issue.Lossy = append(issue.Lossy, []string{rule[1], rule[0]})
+			}
+		}
+
+		issues = append(issues, issue)
+		return nil
+	}
+
+	err = filepath.Walk(path, check)
+	return issues, err
+}
+
+// printRoundTrip prints the issues found by checkRoundTrip
+func (r *RepoPair) printRoundTrip(issues []roundTripIssue) {
+	for _, issue := range issues {
+		if len(issue.Lossy) == 0 {
+			fmt.Printf("%s\t%s doesn't round-trip, due to overlapping replacements\n", r.Source.Path, issue.File)
+			continue
+		}
+
+		for _, replaceCase := range issue.Lossy {
+			fmt.Printf("%s\t%s doesn't round-trip, it already contains %s (from %s => %s)\n",
+				r.Source.Path, issue.File, replaceCase[1], replaceCase[0], replaceCase[1])
+		}
+	}
+}
+
+// CheckRoundTrip checks that syncing the source trees of the pair to their dest trees, and then syncing them back with
+// the mirror pair, leaves the content of every file unchanged. Files that wouldn't round-trip, and the replacements
+// responsible, are printed. An error is returned if there are any.
+func (r *RepoPair) CheckRoundTrip(keepStaging bool) error {
+	lossy, err := r.LossyReplacements()
+	if err != nil {
+		return err
+	}
+	for _, l := range lossy {
+		fmt.Printf("%s\tlossy replacement %s\n", r.String(), l)
+	}
+
+	staging, err := ioutil.TempDir("", "sync_priv_pub-")
+	if err != nil {
+		return fmt.Errorf("Failed to create staging staging: %s", err)
+	}
+
+	// If we encounter an error, we'll leave the staging area behind
+	cleanup := true
+	defer func() {
+		if cleanup && !keepStaging {
+			_ = os.RemoveAll(staging)
+		}
+	}()
+
+	src, _, _, err := r.clone(staging)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	srcBranches, err := tools.GitBranches(src, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return fmt.Errorf("Failed to list remote branches for %s: %s", src, err)
+	}
+
+	targets, err := r.branchTargets(srcBranches)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	count := 0
+	for _, t := range targets {
+		err = tools.GitCheckout(src, t.Source)
+		if err != nil {
+			cleanup = false
+			return fmt.Errorf("Failed to checkout to %s on %s: %s", t.Source, r.Source.Path, err)
+		}
+
+		// Only check the files that are published
+		exported, _, err := r.exportTree(src, t)
+		if err != nil {
+			cleanup = false
+			return err
+		}
+
+		issues, err := r.checkRoundTrip(exported, t)
+		_ = os.RemoveAll(exported)
+		if err != nil {
+			cleanup = false
+			return fmt.Errorf("Failed to check round-trip of %s tree %s: %s", r.Source.Path, t.Source, err)
+		}
+
+		r.printRoundTrip(issues)
+		count += len(issues)
+
+		fmt.Printf("%s\tchecked round-trip of tree %s, %d files don't round-trip\n", r.Source.Path, t.Source, len(issues))
+	}
+
+	if len(lossy) > 0 || count > 0 {
+		return fmt.Errorf("%d lossy replacements, %d files don't round-trip", len(lossy), count)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree writes the files to a new temporary directory, by relative path, and returns its path
+func writeTree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for rel, data := range files {
+		n := filepath.Join(root, filepath.FromSlash(rel))
+		err := os.MkdirAll(filepath.Dir(n), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(n, []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// testMirrorPair returns a pair with every setting that Mirror swaps or inverts
+func testMirrorPair() *RepoPair {
+	return &RepoPair{
+		Source:        GitRepo{Path: "github.com/soterium/soterd"},
+		SourceGitTree: "exp0",
+		Dest:          GitRepo{Path: "github.com/soteria-dag/soterd"},
+		DestGitTree:   "master",
+		Dependencies: []*RepoPair{{
+			Source: GitRepo{Path: "github.com/soterium/soterdash"},
+			Dest:   GitRepo{Path: "github.com/soteria-dag/soterdash"},
+		}},
+		Branches:      []BranchMap{{Source: "release/*", Dest: "v*"}},
+		Tags:          []TagMap{{Source: "soterd-v*", Dest: "v*"}},
+		Replace:       [][]string{{"soterium", "soteria-dag"}, {"Soterium", "Soteria"}},
+		Rename:        [][]string{{"internal/", "pkg/"}},
+		TagSemver:     SemverRelease,
+		WarnRoundTrip: true,
+		Hooks:         []Hook{{Stage: HookPreCommit, Command: []string{"make"}}},
+		MessageRules:  MessageRules{DropLines: []string{`PRIV-`}},
+	}
+}
+
+func TestInvertReplacements(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		replacements [][]string
+		want         [][]string
+	}{
+		{"none", [][]string{}, [][]string{}},
+		{"one", [][]string{{"a", "b"}}, [][]string{{"b", "a"}}},
+		{"reverse order", [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}}, [][]string{{"f", "e"}, {"d", "c"}, {"b", "a"}}},
+		// The last set sharing a new string comes first, so it's the one the inverse applies
+		{"shared new string", [][]string{{"a", "x"}, {"b", "x"}}, [][]string{{"x", "b"}, {"x", "a"}}},
+		{"removed text", [][]string{{"a", "b"}, {"PRIVATE", ""}}, [][]string{{"b", "a"}}},
+	} {
+		got := invertReplacements(tc.replacements)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: invertReplacements(%q) returned %q, want %q", tc.name, tc.replacements, got, tc.want)
+		}
+	}
+}
+
+func TestLossyReplacements(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		replace [][]string
+		want    []string
+	}{
+		{"reversible", [][]string{{"soterium", "soteria-dag"}, {"Soterium", "Soteria"}}, []string{}},
+		// Listing the same set twice is harmless
+		{"repeated", [][]string{{"soterium", "soteria-dag"}, {"soterium", "soteria-dag"}}, []string{}},
+		{"removed", [][]string{{"PRIVATE", ""}}, []string{"PRIVATE => : removed text can't be restored"}},
+		{
+			name:    "colliding custom sets",
+			replace: [][]string{{"soterd-priv", "soterd"}, {"soterd-internal", "soterd"}},
+			want:    []string{"soterd-internal => soterd: soterd-priv is also replaced with soterd"},
+		},
+		{
+			// The repo path replacement also maps soterium/soterd to soteria-dag/soterd
+			name:    "colliding with a path",
+			replace: [][]string{{"colakong/soterd", "soteria-dag/soterd"}},
+			want:    []string{"colakong/soterd => soteria-dag/soterd: soterium/soterd is also replaced with soteria-dag/soterd"},
+		},
+	} {
+		r := &RepoPair{
+			Source:        GitRepo{Path: "github.com/soterium/soterd"},
+			SourceGitTree: "master",
+			Dest:          GitRepo{Path: "github.com/soteria-dag/soterd"},
+			DestGitTree:   "master",
+			Replace:       tc.replace,
+		}
+
+		got, err := r.LossyReplacements()
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: LossyReplacements returned %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	r := testMirrorPair()
+	m := r.Mirror()
+
+	if m.Source != r.Dest || m.Dest != r.Source || m.SourceGitTree != r.DestGitTree || m.DestGitTree != r.SourceGitTree {
+		t.Errorf("Mirror didn't swap the repos and git trees: %s %s -> %s %s", m.Source.Path, m.SourceGitTree, m.Dest.Path, m.DestGitTree)
+	}
+	if len(m.Dependencies) != 1 || m.Dependencies[0].Source != r.Dependencies[0].Dest || m.Dependencies[0].Dest != r.Dependencies[0].Source {
+		t.Errorf("Mirror didn't swap the dependencies: %+v", m.Dependencies)
+	}
+	if !reflect.DeepEqual(m.Branches, []BranchMap{{Source: "v*", Dest: "release/*"}}) {
+		t.Errorf("Mirror swapped the branch maps to %+v", m.Branches)
+	}
+	if !reflect.DeepEqual(m.Tags, []TagMap{{Source: "v*", Dest: "soterd-v*"}}) {
+		t.Errorf("Mirror swapped the tag maps to %+v", m.Tags)
+	}
+	if !reflect.DeepEqual(m.Replace, [][]string{{"Soteria", "Soterium"}, {"soteria-dag", "soterium"}}) {
+		t.Errorf("Mirror inverted the replacements to %q", m.Replace)
+	}
+	if !reflect.DeepEqual(m.Rename, [][]string{{"pkg/", "internal/"}}) {
+		t.Errorf("Mirror inverted the renames to %q", m.Rename)
+	}
+	if m.TagSemver != r.TagSemver || m.WarnRoundTrip != r.WarnRoundTrip {
+		t.Errorf("Mirror didn't keep the tag semver policy and round-trip warnings")
+	}
+
+	// Settings that are specific to the direction of the sync aren't mirrored
+	if len(m.Hooks) > 0 || !m.MessageRules.IsEmpty() {
+		t.Errorf("Mirror kept the hooks %+v and message rules %+v", m.Hooks, m.MessageRules)
+	}
+
+	// Mirroring the mirror gives back the pair
+	back := m.Mirror()
+	if back.Source != r.Source || back.Dest != r.Dest || back.SourceGitTree != r.SourceGitTree ||
+		!reflect.DeepEqual(back.Replace, r.Replace) || !reflect.DeepEqual(back.Rename, r.Rename) ||
+		!reflect.DeepEqual(back.Branches, r.Branches) || !reflect.DeepEqual(back.Tags, r.Tags) {
+		t.Errorf("Mirror of the mirror returned %+v, want %+v", back, r)
+	}
+}
+
+func TestMirrorRoundTrip(t *testing.T) {
+	r := testMirrorPair()
+	m := r.Mirror()
+	forward, err := r.replacer(branchTarget{Source: "exp0", Dest: "master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backward, err := m.replacer(branchTarget{Source: "master", Dest: "exp0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Syncing with the pair and then with its mirror maps the repos, trees, dependencies and custom strings back
+	in := "import \"github.com/soterium/soterd/dag\"\n" +
+		"import \"github.com/soterium/soterdash/ui\"\n" +
+		"// See github.com/soterium/soterd/exp0 for the Soterium node, by the soterium team\n"
+	out := forward.ReplaceString(in)
+	if out == in {
+		t.Fatalf("The replacements of the pair didn't change %q", in)
+	}
+	if back := backward.ReplaceString(out); back != in {
+		t.Errorf("The mirror pair mapped %q back to %q, want %q", out, back, in)
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	r := testMirrorPair()
+	tree := writeTree(t, map[string]string{
+		"ok.go":      "package soterium\n",
+		"lossy.go":   "// Forked from soteria-dag, now soterium\n",
+		"binary.bin": "soteria-dag\x00",
+		"go.mod":     "module github.com/soteria-dag/soterd\n",
+	})
+
+	// Binary files and go.mod files aren't rewritten, so they round-trip whatever they contain
+	issues, err := r.checkRoundTrip(tree, branchTarget{Source: "exp0", Dest: "master"})
+	want := []roundTripIssue{{File: "lossy.go", Lossy: [][]string{{"soterium", "soteria-dag"}}}}
+	if err != nil || !reflect.DeepEqual(issues, want) {
+		t.Errorf("checkRoundTrip returned %+v, %v, want %+v", issues, err, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/soterium/sync_priv_pub/tools"
 )
@@ -14,20 +15,36 @@ const (
 	// Mode to use for directories created during Sync
 	tempPathMode = 0755
 
+	// Size of the largest file that replacements are made in, unless the pair sets its own
+	defaultReplaceMaxSize = 10 << 20
+
 	defaultGitRemote = "origin"
+
+	// Trailer added to sync commit messages, recording the source commit that was synced.
+	// Import uses it to find the last sync point in the dest repo.
+	syncTrailer = "Sync-Source-Commit"
 )
 
 var (
-	// Exclude files/directories under these paths from recursive diff comparisons between Source and Dest repos
+	// Exclude files/directories matching these glob patterns from tree comparisons between Source and Dest repos
 	diffExclude = []string{".git"}
 
 	// Exclude files under or matching these paths from renaming operations.
-	// The go.mod and go.sum files will be handled with specific commands, if present.
-	renameExclude = []string{".git", "go.mod", "go.sum", "glide.yaml", "glide.lock"}
+	// The go.mod, go.sum and go.work files will be handled with specific commands, if present, and the glide and dep
+	// manifests with specific rewrites.
+	renameExclude = []string{".git", "go.mod", "go.sum", "go.work", "go.work.sum", "glide.yaml", "glide.lock", "Gopkg.toml", "Gopkg.lock"}
+
+	// Exclude files matching these git pathspecs from imports: renameExclude, and the go.mod, go.sum and go.work files
+	// of nested modules and workspaces, which are handled the same way
+	importExclude = append([]string{"*/go.mod", "*/go.sum", "*/go.work", "*/go.work.sum"}, renameExclude...)
 
 	// Track which pairs have been synced already, so that sync process isn't repeated during the same run,
 	// when multiple pairs share the same dependencies.
 	done = make(map[string]bool)
+
+	// Dest commits that source commits were synced to during the run, by source repo path, so that the revisions that
+	// manifests pin dependencies to can be updated
+	syncedCommits = make(map[string]map[string]string)
 )
 
 type RepoPair struct {
@@ -44,6 +61,61 @@ type RepoPair struct {
 	Dependencies  []*RepoPair
 	// Sets of strings {old, new} that should be replaced in files outside of renameExclude during sync
 	Replace [][]string
+	// Sets of strings {old, new} that should be replaced in the paths of files during sync, renaming them
+	// (ex: {"soterium-cli", "soteria-cli"} moves cmd/soterium-cli/main.go to cmd/soteria-cli/main.go)
+	Rename [][]string
+	// Warn about staged files that the mirror pair wouldn't restore, on each sync. This reads the whole tree and
+	// replaces it both ways again, so it's off by default; the -roundtrip flag checks it without syncing.
+	WarnRoundTrip bool
+	// Maps of source branches to dest branches that should be synced in the same run, sharing the same clones.
+	// If empty, SourceGitTree is synced to DestGitTree.
+	Branches []BranchMap
+	// Maps of source tags to dest tags. Tags on the synced source tree that match are created on the synced dest commit.
+	Tags []TagMap
+	// Which matching source tags are synced, based on semantic versioning
+	TagSemver SemverPolicy
+	// Files larger than this many bytes don't have replacements made in them. Defaults to 10 MiB, or no limit if negative.
+	ReplaceMaxSize int64
+	// What's done with binary files (by content, or the binary or -diff attribute in .gitattributes) that have
+	// replacements to make. Defaults to skipping them, because changing their length usually corrupts them.
+	BinaryFiles tools.ContentPolicy
+	// What's done with generated files ("Code generated ... DO NOT EDIT") that have replacements to make.
+	// Defaults to skipping them, so that they can be regenerated instead (ex: .pb.go descriptors would be corrupted).
+	GeneratedFiles tools.ContentPolicy
+	// What's done with the submodules of source trees. Defaults to failing, since git archive leaves them empty.
+	Submodules SubmodulePolicy
+	// What's done with Git LFS pointer files in source trees. Defaults to failing, since the dest repo's LFS server
+	// wouldn't have the objects they refer to.
+	LFS LFSPolicy
+	// Transformers that change the files of the staged dest tree, in order. If nil, DefaultTransformers are used.
+	// Custom transformers can be listed along with the built-in ones (ex: StripRegions and LicenseHeaders).
+	Transformers []Transformer
+	// External commands run at stages of the sync (ex: go generate after the transformers), in order
+	Hooks []Hook
+	// text/template of the message of sync commits, which can refer to the fields of CommitInfo (ex: the synced
+	// commits). If empty, the message given to Sync is used, which can be a template too.
+	CommitMessage string
+	// Rules that scrub the messages of sync commits and tags (ex: dropping internal ticket ids and trailers)
+	MessageRules MessageRules
+	// Regular expressions of text that must not reach Dest (ex: `corp\.example\.com`, `PRIV-\d+`). The sync fails if
+	// the staged files, the messages of sync commits and tags, or pull requests still match any of them.
+	Leaks []string
+	// Maps the identities of source commit authors to public ones (or to a bot identity), in the format of git's
+	// .mailmap files (ex: "Jane Doe <jane@example.org> <jane.doe@corp.example.com>")
+	Mailmap string
+	// Refuse to sync (or import) commits whose authors aren't mapped by Mailmap
+	StrictMailmap bool
+	// Add Co-authored-by trailers to sync commits for the authors of the synced commits, mapped by Mailmap
+	CoAuthors bool
+	// How sync commits and tags are signed, if they are
+	Signing *Signing
+	// Which commits of source trees must be signed by TrustedSigners before they're synced. Off by default.
+	SourceSignatures SourceSignaturePolicy
+	// With SourceSignaturesAll, only verify the tip of source trees that have no previous sync to their dest tree,
+	// instead of failing (ex: for the first sync of a tree)
+	FirstSyncTipOnly bool
+	// Keys that source commits must be signed with, when SourceSignatures verifies them
+	TrustedSigners TrustedSigners
 }
 
 // Return a string representing the RepoPair
@@ -51,9 +123,34 @@ func (r *RepoPair) String() string {
 	return fmt.Sprintf("%s -> %s", r.Source.String(), r.Dest.String())
 }
 
+// NeedsGitCommand returns true if syncing (or with importDest, importing) the pair or its dependencies needs the git
+// command, even with the go-git backend: for reviews and commit message templates (diff stats), imports (patches),
+// and signing or verifying signatures (which git does with gpg or ssh-keygen). commitMsg is the message given to Sync.
+func (r *RepoPair) NeedsGitCommand(review, importDest bool, commitMsg string) bool {
+	if review || importDest || r.Signing != nil || r.SourceSignatures != SourceSignaturesOff {
+		return true
+	}
+
+	msg := commitMsg
+	if len(r.CommitMessage) > 0 {
+		msg = r.CommitMessage
+	}
+	if strings.Contains(msg, "{{") {
+		return true
+	}
+
+	for _, dep := range r.Dependencies {
+		if dep.NeedsGitCommand(review, importDest, commitMsg) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Sync syncs changes from the Source repo to the Dest repo,
 // while also replacing references of the source in the destination.
-func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAddr, userName string) error {
+func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps, review bool, commitMsg, emailAddr, userName string) (err error) {
 	_, exists := done[r.String()]
 	if exists {
 		// Don't process the same repo more than once in the same run
@@ -65,30 +162,110 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 		// Process dependencies first
 		for _, dep := range r.Dependencies {
 			fmt.Println("Processing dependency", dep.String())
-			err := dep.Sync(keepStaging, skipAsk, skipDeps, commitMsg, emailAddr, userName)
+			err := dep.Sync(keepStaging, skipAsk, skipDeps, review, commitMsg, emailAddr, userName)
 			if err != nil {
 				return fmt.Errorf("Failed to sync dependency %s: %s", dep, err)
 			}
 		}
 	}
 
+	err = r.checkHooks()
+	if err != nil {
+		return err
+	}
+
 	// Create staging area. This will be used as our GOPATH dir, so we'll create a src dir inside of it too.
 	staging, err := ioutil.TempDir("", "sync_priv_pub-")
 	if err != nil {
 		return fmt.Errorf("Failed to create staging staging: %s", err)
 	}
 
+	var src, dst string
+	var goEnv []string
+	defer func() {
+		if err != nil {
+			r.runFailureHooks(err, staging, src, dst, goEnv)
+		}
+	}()
+
 	// If we encounter an error, we'll leave the staging area behind
 	cleanup := true
 	defer func() {
 		// This repo was attempted to be synced
 		done[r.String()] = true
 
-		if cleanup && keepStaging {
+		if cleanup && !keepStaging {
 			_ = os.RemoveAll(staging)
 		}
 	}()
 
+	src, dst, goEnv, err = r.clone(staging)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	srcBranches, err := tools.GitBranches(src, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return fmt.Errorf("Failed to list remote branches for %s: %s", src, err)
+	}
+
+	dstBranches, err := tools.GitBranches(dst, defaultGitRemote)
+	if err != nil {
+		cleanup = false
+		return fmt.Errorf("Failed to list remote branches for %s: %s", dst, err)
+	}
+
+	targets, err := r.branchTargets(srcBranches)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	if len(targets) == 0 {
+		cleanup = false
+		return fmt.Errorf("No branches in %s match the branch maps of %s", r.Source.Path, r.String())
+	}
+
+	err = r.configureSigning(dst)
+	if err != nil {
+		cleanup = false
+		return err
+	}
+
+	// Each mapped branch is transformed and pushed independently
+	for _, t := range targets {
+		fmt.Printf("Syncing %s tree %s to %s tree %s\n", r.Source.Path, t.Source, r.Dest.Path, t.Dest)
+
+		newBranch := len(r.Branches) > 0 && !contains(dstBranches, t.Dest)
+		err = r.syncTree(src, dst, t, newBranch, goEnv, skipAsk, review, commitMsg, emailAddr, userName)
+		if err != nil {
+			cleanup = false
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clone clones the Source and Dest repos under the staging area, fetches all of their remote branches, and runs the
+// post-clone hooks. It returns the paths of the Source and Dest clones, and the environment to use for go commands and
+// hooks.
+func (r *RepoPair) clone(staging string) (src, dst string, goEnv []string, err error) {
+	// Check that both repos can be reached before cloning either of them
+	err = r.Source.Check()
+	if err != nil {
+		return src, dst, goEnv, fmt.Errorf("Can't connect to %s: %s", r.Source.Path, err)
+	}
+
+	err = r.Dest.Check()
+	if err != nil {
+		return src, dst, goEnv, fmt.Errorf("Can't connect to %s: %s", r.Dest.Path, err)
+	}
+
+	fmt.Println("Connected to", r.Source.Path, "and", r.Dest.Path)
+
 	// Set the staging area's permissions, so that "go get" can create a
 	// pkg dir inside of it when adding new dependencies.
 	err = os.Chmod(staging, tempPathMode)
@@ -99,30 +276,29 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 	srcDir := filepath.Join(staging, "src")
 	err = os.Mkdir(srcDir, tempPathMode)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to create %s: %s", srcDir, err)
+		return src, dst, goEnv, fmt.Errorf("Failed to create %s: %s", srcDir, err)
 	}
 
+	src = filepath.Join(srcDir, r.Source.Path)
+	dst = filepath.Join(srcDir, r.Dest.Path)
+
 	// Source and Dest repos will be cloned under <staging area>/src, so we'll tell go commands to search under
-	// here for modules.
-	goEnv := append(os.Environ(), fmt.Sprintf("GOPATH=%s", staging))
+	// here for modules. Hooks also get where things are.
+	goEnv = append(os.Environ(), fmt.Sprintf("GOPATH=%s", staging))
+	goEnv = append(goEnv, r.hookEnv(staging, src, dst)...)
 
 	// Clone the Source repo to the staging area
-	src := filepath.Join(srcDir, r.Source.Path)
-	err = r.Source.Clone(src, false)
+	err = r.Source.Clone(src)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to clone %s: %s", r.Source, err)
+		return src, dst, goEnv, fmt.Errorf("Failed to clone %s: %s", r.Source.Path, err)
 	}
 
 	fmt.Println("Cloned source", r.Source.Path, "to", src)
 
 	// Clone the Dest repo to the staging area
-	dst := filepath.Join(srcDir, r.Dest.Path)
-	err = r.Dest.Clone(dst, false)
+	err = r.Dest.Clone(dst)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to clone %s: %s", r.Dest, err)
+		return src, dst, goEnv, fmt.Errorf("Failed to clone %s: %s", r.Dest.Path, err)
 	}
 
 	fmt.Println("Cloned dest", r.Dest.Path, "to", dst)
@@ -130,162 +306,215 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 	// Fetch all the remote branches, so that we can checkout to them, if syncing between non-default branches
 	err = tools.GitFetchAll(src)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to fetch all remote branches for %s: %s", src, err)
+		return src, dst, goEnv, fmt.Errorf("Failed to fetch all remote branches for %s: %s", src, err)
 	}
 
 	err = tools.GitFetchAll(dst)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to fetch all remote branches for %s: %s", dst, err)
+		return src, dst, goEnv, fmt.Errorf("Failed to fetch all remote branches for %s: %s", dst, err)
 	}
 
+	return src, dst, goEnv, r.runHooks(HookPostClone, dst, goEnv)
+}
+
+// stageTree checks out the dest tree of the target in dst, and replaces its files with those of the source tree of
+// the target in src, transformed by the transformers of the pair (replacements, go modules, ...). The changes aren't
+// committed. It returns the replacer of the pair's replacements, for the target.
+func (r *RepoPair) stageTree(src, dst string, t branchTarget, newBranch bool, goEnv []string) (*tools.Replacer, error) {
 	// Switch to Source tree, so that Archive works regardless of what the default branch is set to.
-	err = tools.GitCheckout(src, r.SourceGitTree)
+	err := tools.GitCheckout(src, t.Source)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to checkout to %s on %s: %s", r.SourceGitTree, r.Source.Path, err)
+		return nil, fmt.Errorf("Failed to checkout to %s on %s: %s", t.Source, r.Source.Path, err)
 	}
 
-	fmt.Println("Checked out to", r.SourceGitTree, "in", src)
+	fmt.Println("Checked out to", t.Source, "in", src)
 
 	// Switch to the Dest tree, so that when we Archive/Commit to Dest, the content is being committed
 	// to the correct branch regardless of what the default branch is set to.
-	err = tools.GitCheckout(dst, r.DestGitTree)
+	if newBranch {
+		start := path.Join(defaultGitRemote, "HEAD")
+		if len(r.DestGitTree) > 0 {
+			start = path.Join(defaultGitRemote, r.DestGitTree)
+		}
+
+		err = tools.GitCheckoutNew(dst, t.Dest, start)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create branch %s from %s on %s: %s", t.Dest, start, r.Dest.Path, err)
+		}
+
+		fmt.Println("Created branch", t.Dest, "from", start, "in", dst)
+	} else {
+		err = tools.GitCheckout(dst, t.Dest)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to checkout to %s on %s: %s", t.Dest, r.Dest.Path, err)
+		}
+
+		fmt.Println("Checked out to", t.Dest, "in", dst)
+	}
+
+	// Nothing of Source is archived until its signatures are verified, when the pair requires them
+	err = r.verifySourceSignatures(src, dst, t)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to checkout to %s on %s: %s", r.DestGitTree, r.Dest.Path, err)
+		return nil, err
 	}
 
-	fmt.Println("Checked out to", r.DestGitTree, "in", dst)
+	// Archive files from Source on their own, since Dest is compared to what's published rather than to the Source
+	// checkout: the archive leaves out export-ignore paths, expands the placeholders of export-subst files, and has the
+	// submodules of Source handled by the pair's policy
+	exported, gitlinks, err := r.exportTree(src, t)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(exported)
 
-	// Archive files from Source to Dest
-	err = tools.GitArchive(src, r.SourceGitTree, dst)
+	renamer, err := r.renamer()
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to archive from %s to %s at %s: %s", src, r.SourceGitTree, dst, err)
+		return nil, err
 	}
 
-	fmt.Println("Archived files from", src, "tree", r.SourceGitTree, "to", dst)
+	// Record the mirrored submodules in Dest, before files can take the place of the ones that aren't mirrored anymore
+	if len(gitlinks) > 0 && renamer != nil {
+		err = tools.RenameSubmodules(exported, renamer.ReplaceString)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to rename submodules in .gitmodules: %s", err)
+		}
+	}
 
-	// Remove files in Dest that don't exist in Source
-	pruned, err := tools.GitPrune(dst, src)
+	err = r.recordSubmodules(dst, gitlinks, renamer)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to prune from %s compared to %s: %s", dst, src, err)
-	}
-	for _, p := range pruned {
-		fmt.Printf("%s\tpruned %s\n", r.Dest.Path, p)
+		return nil, err
 	}
 
-	// Confirm that files in Source and Dest are now identical, skipping the .git directory
-	same, err := tools.DiffR(dst, src, diffExclude...)
-	if !same {
-		cleanup = false
-		return fmt.Errorf("%s != %s\n%s", src, dst, err)
+	// Copy the archived files to Dest
+	err = tools.CopyTree(exported, dst)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to copy files archived from %s to %s: %s", src, dst, err)
 	}
 
-	fmt.Printf("Staged %s identical to %s tree %s\n", r.Dest.Path, r.Source.Path, r.SourceGitTree)
+	fmt.Println("Archived files from", src, "tree", t.Source, "to", dst)
 
-	// Replace references to source repo git tree with dest repo & git tree in dest repo, except for files under .git
-	gitTreeOld := path.Join(r.Source.Path, r.SourceGitTree)
-	gitTreeNew := path.Join(r.Dest.Path, r.DestGitTree)
-	count, err := tools.ReplaceR(dst, gitTreeOld, gitTreeNew, renameExclude...)
+	// Move files to their renamed paths in Dest
+	err = r.renamePaths(exported, dst, renamer)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to replace %s with %s in %s: %s", gitTreeOld, gitTreeNew, dst, err)
+		return nil, fmt.Errorf("Failed to rename paths in %s: %s", dst, err)
 	}
 
-	fmt.Printf("%s\tmade %d replacements for %s => %s\n", r.Dest.Path, count, gitTreeOld, gitTreeNew)
+	// Remove files in Dest that don't exist in Source (under their renamed paths)
+	pruned, err := tools.GitPrune(dst, exported, renameFunc(renamer))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to prune from %s compared to %s: %s", dst, src, err)
+	}
+	for _, p := range pruned {
+		fmt.Printf("%s\tpruned %s\n", r.Dest.Path, p)
+	}
 
-	// Replace references to source repo path with dest repo path in dest repo, except for all files under .git and go.mod files
-	count, err = tools.ReplaceR(dst, r.Source.RepoPath(), r.Dest.RepoPath(), renameExclude...)
+	// Confirm that files in Source and Dest are now identical, skipping the .git directory
+	diff, err := tools.CompareTreesRenamed(exported, dst, renameFunc(renamer), diffExclude...)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to replace %s with %s in %s: %s", r.Source.RepoPath(), r.Dest.RepoPath(), dst, err)
+		return nil, fmt.Errorf("Failed to compare %s to %s: %s", dst, src, err)
+	}
+	if len(diff) > 0 {
+		return nil, fmt.Errorf("%s != %s\n%s", src, dst, diff.String())
 	}
 
-	fmt.Printf("%s\tmade %d replacements for %s => %s\n", r.Dest.Path, count, r.Source.RepoPath(), r.Dest.RepoPath())
+	fmt.Printf("Staged %s identical to %s tree %s\n", r.Dest.Path, r.Source.Path, t.Source)
 
-	// Replace references to dependency source repos with dependency dest repos, except for .git and go.mod files
-	for _, dep := range r.Dependencies {
-		count, err = tools.ReplaceR(dst, dep.Source.RepoPath(), dep.Dest.RepoPath(), renameExclude...)
+	// Warn about content that the mirror pair wouldn't be able to restore, if the pair asks for it
+	if r.WarnRoundTrip {
+		issues, err := r.checkRoundTrip(dst, t)
 		if err != nil {
-			cleanup = false
-			return fmt.Errorf("Failed to replace %s with %s in %s: %s", dep.Source.RepoPath(), dep.Dest.RepoPath(), dst, err)
+			return nil, fmt.Errorf("Failed to check round-trip of %s: %s", dst, err)
 		}
-
-		fmt.Printf("%s\tmade %d replacements for %s => %s\n", r.Dest.Path, count, dep.Source.RepoPath(), dep.Dest.RepoPath())
+		r.printRoundTrip(issues)
 	}
 
-	// Replace custom strings
-	for _, replaceCase := range r.Replace {
-		if len(replaceCase) != 2 {
-			return fmt.Errorf("Can't apply replacement (%s): Need to specify an old and new string", replaceCase)
-		}
+	// Replacements of references to source repos with dest repos, and custom strings, which tag messages and reviews
+	// get too
+	replacer, err := r.replacer(t)
+	if err != nil {
+		return nil, err
+	}
 
-		old, new := replaceCase[0], replaceCase[1]
-		count, err = tools.ReplaceR(dst, old, new, renameExclude...)
-		if err != nil {
-			cleanup = false
-			return fmt.Errorf("Failed to replace %s with %s in %s: %s", old, new, dst, err)
-		}
+	tree := &StagedTree{
+		Path:       dst,
+		Pair:       r,
+		SourceTree: t.Source,
+		DestTree:   t.Dest,
+		Renamer:    renamer,
+		GoEnv:      goEnv,
+	}
 
-		fmt.Printf("%s\tmade %d replacements for %s => %s\n", r.Dest.Path, count, old, new)
+	err = r.transform(tree)
+	if err != nil {
+		return nil, err
 	}
 
-	// Determine if go module file exists in Dest repo
-	goMod := filepath.Join(dst, "go.mod")
-	goModExists := false
-	if s, err := os.Stat(goMod); err == nil && !s.IsDir() {
-		goModExists = true
+	err = r.runHooks(HookPostTransform, dst, goEnv, treeEnv(t)...)
+	if err != nil {
+		return nil, err
 	}
 
-	if goModExists {
-		// Update the go module name for the dest repo
-		err := tools.GoSetMod(goMod, r.Dest.Path, goEnv)
-		if err != nil {
-			cleanup = false
-			return fmt.Errorf("Failed to set go module name to %s in %s: %s", r.Dest.Path, goMod, err)
-		}
+	return replacer, nil
+}
 
-		fmt.Printf("%s\tset module name\n", r.Dest.Path)
+// exportTree archives the source tree of the target from src to a new temporary directory, and returns its path, along
+// with the commit of each submodule that's mirrored, by path.
+//
+// Unlike the checkout, it holds only what's published: git archive leaves out paths with the export-ignore attribute,
+// and expands the $Format:...$ placeholders of files with the export-subst attribute. Submodules are vendored or left
+// out by the pair's policy, and it fails for Git LFS pointer files without a policy for them.
+func (r *RepoPair) exportTree(src string, t branchTarget) (string, map[string]string, error) {
+	exported, err := ioutil.TempDir("", "sync_priv_pub-export-")
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to create export directory: %s", err)
 	}
 
-	if goModExists && len(r.Dependencies) > 0 {
-		// Update go module dependencies for dest repo
+	gitlinks, err := r.archiveTree(src, t, exported)
+	if err != nil {
+		_ = os.RemoveAll(exported)
+		return "", nil, err
+	}
 
-		// First, drop all old dependency names. We drop all of the old dependencies first, because "go get" attempts to
-		// resolve ~all~ modules before performing its operation.
-		for _, dep := range r.Dependencies {
-			err := tools.GoDropMod(goMod, dep.Source.Path, goEnv)
-			if err != nil {
-				cleanup = false
-				return fmt.Errorf("Failed to drop old go module dependency %s in %s: %s", dep.Source.Path, goMod, err)
-			}
+	return exported, gitlinks, nil
+}
 
-			fmt.Printf("%s\tdropped old dependency %s\n", r.Dest.Path, dep.Source.Path)
-		}
+// archiveTree archives the source tree of the target from src to dir, for exportTree
+func (r *RepoPair) archiveTree(src string, t branchTarget, dir string) (map[string]string, error) {
+	err := tools.GitArchive(src, t.Source, dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to archive from %s to %s at %s: %s", src, t.Source, dir, err)
+	}
 
-		// Next, add the new dependency names
-		for _, dep := range r.Dependencies {
-			err := tools.GoGetMod(dst, dep.Dest.Path, goEnv)
-			if err != nil {
-				cleanup = false
-				return fmt.Errorf("Failed to get go module dependency %s: %s", dep.Dest.Path, err)
-			}
+	url, err := r.Source.CloneURL(r.Source.useHTTPS())
+	if err != nil {
+		return nil, err
+	}
 
-			fmt.Printf("%s\tadded new dependency %s\n", r.Dest.Path, dep.Dest.Path)
-		}
+	gitlinks, err := r.exportSubmodules(src, t.Source, dir, url)
+	if err != nil {
+		return nil, err
+	}
 
-		// Finally, we remove stale references to old dependencies and their related modules
-		err := tools.GoTidyMod(dst, goEnv)
-		if err != nil {
-			cleanup = false
-			return fmt.Errorf("Failed to tidy go module dependencies: %s", err)
-		}
+	return gitlinks, r.checkLFS(dir, t.Source)
+}
+
+// syncTree syncs the source tree of the target to the dest tree of the target, in the cloned src and dst repos.
+// If newBranch is true, the dest tree is created as a new branch. If review is true, changes are pushed to a review
+// branch and a pull request to the dest tree is opened, instead of pushing to the dest tree.
+func (r *RepoPair) syncTree(src, dst string, t branchTarget, newBranch bool, goEnv []string, skipAsk, review bool, commitMsg, emailAddr, userName string) error {
+	replacer, err := r.stageTree(src, dst, t, newBranch, goEnv)
+	if err != nil {
+		return err
+	}
+
+	srcCommit, _, err := tools.GitRevParse(src, t.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to determine commit of %s in %s: %s", t.Source, src, err)
+	}
 
-		fmt.Printf("%s\ttidied go module dependencies\n", r.Dest.Path)
+	err = r.runHooks(HookPreCommit, dst, goEnv, append(treeEnv(t), "SYNC_SOURCE_COMMIT="+srcCommit)...)
+	if err != nil {
+		return err
 	}
 
 	if !skipAsk {
@@ -293,12 +522,10 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 		fmt.Printf("About to commit changes for %s\n", r.Dest.Path)
 		ok, err := tools.AskUser()
 		if err != nil {
-			cleanup = false
 			return fmt.Errorf("Failure while asking if commit is ok: %s", err)
 		}
 
 		if !ok {
-			cleanup = false
 			return fmt.Errorf("User aborted")
 		}
 	}
@@ -306,14 +533,18 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 	// Add files in Dest repo that weren't tracked before
 	_, err = tools.GitAddNew(dst)
 	if err != nil {
-		cleanup = false
 		return fmt.Errorf("Failed to git-add new files to %s: %s", dst, err)
 	}
 
+	// Sync commits are attributed to the user's identity as the mailmap maps it
+	userName, emailAddr, err = r.mapCommitter(userName, emailAddr)
+	if err != nil {
+		return err
+	}
+
 	if len(emailAddr) > 0 {
 		err = tools.GitEmail(dst, emailAddr)
 		if err != nil {
-			cleanup = false
 			return fmt.Errorf("Failed to set git config email address in %s to %s: %s", dst, emailAddr, err)
 		}
 	}
@@ -321,43 +552,114 @@ func (r *RepoPair) Sync(keepStaging, skipAsk, skipDeps bool, commitMsg, emailAdd
 	if len(userName) > 0 {
 		err = tools.GitUserName(dst, userName)
 		if err != nil {
-			cleanup = false
 			return fmt.Errorf("Failed to set git config user name in %s to %s: %s", dst, userName, err)
 		}
 	}
 
-	// Commit changes
-	err = tools.GitCommit(dst, commitMsg)
+	// Commit changes, recording the synced source commit
+	base, _, err := tools.GitRevParse(dst, "HEAD")
+	if err != nil {
+		return fmt.Errorf("Failed to determine HEAD commit in %s: %s", dst, err)
+	}
+
+	msg, err := r.commitMessage(src, dst, t, base, srcCommit, commitMsg, replacer)
+	if err != nil {
+		return err
+	}
+
+	// The files and message are scanned as they're committed, once the transformers and all hooks changed them
+	err = r.scanTree(dst)
+	if err != nil {
+		return err
+	}
+
+	err = r.scanMessage("commit message", msg)
+	if err != nil {
+		return err
+	}
+
+	err = tools.GitCommit(dst, appendTrailers(msg, fmt.Sprintf("%s: %s", syncTrailer, srcCommit)))
 	if err != nil {
-		cleanup = false
 		return fmt.Errorf("Failed to commit git changes to %s: %s", dst, err)
 	}
 
 	fmt.Printf("%s\tchanges committed\n", r.Dest.Path)
 
+	destCommit, _, err := tools.GitRevParse(dst, "HEAD")
+	if err != nil {
+		return fmt.Errorf("Failed to determine commit of %s in %s: %s", t.Dest, dst, err)
+	}
+	if destCommit != base {
+		err = r.verifySignature(dst, "commit", destCommit)
+		if err != nil {
+			return err
+		}
+	}
+	if syncedCommits[r.Source.Path] == nil {
+		syncedCommits[r.Source.Path] = make(map[string]string)
+	}
+	syncedCommits[r.Source.Path][srcCommit] = destCommit
+
+	// Copy Git LFS objects before pushing the commit that refers to them
+	err = r.pushLFS(dst)
+	if err != nil {
+		return err
+	}
+
+	if review {
+		// Tags aren't created, because the commit isn't on the dest tree until the pull request is merged
+		return r.pushReview(src, dst, t, newBranch, base, srcCommit, skipAsk, msg, replacer, goEnv)
+	}
+
+	// Create tags on the commit, for the tags on the source tree
+	tags, err := r.syncTags(src, dst, t, srcCommit, replacer)
+	if err != nil {
+		return err
+	}
+
 	if !skipAsk {
 		// Ask the user if they want to push
-		fmt.Printf("About to push changes for %s to %s\n", r.Dest.Path, r.DestGitTree)
+		fmt.Printf("About to push changes for %s to %s\n", r.Dest.Path, t.Dest)
+		if len(tags) > 0 {
+			fmt.Printf("Tags to push: %s\n", strings.Join(tags, ", "))
+		}
 		ok, err := tools.AskUser()
 		if err != nil {
-			cleanup = false
 			return fmt.Errorf("Failure while asking if commit is ok: %s", err)
 		}
 
 		if !ok {
-			cleanup = false
 			return fmt.Errorf("User aborted")
 		}
 	}
 
 	// Push changes
-	err = tools.GitPush(dst, defaultGitRemote, r.DestGitTree)
+	err = tools.GitPush(dst, defaultGitRemote, t.Dest)
 	if err != nil {
-		cleanup = false
-		return fmt.Errorf("Failed to git-push changes to %s %s: %s", r.Dest.Path, r.DestGitTree, err)
+		return fmt.Errorf("Failed to git-push changes to %s %s: %s", r.Dest.Path, t.Dest, err)
 	}
 
-	fmt.Printf("%s\tchanges pushed to %s %s\n", r.Dest.Path, defaultGitRemote, r.DestGitTree)
+	fmt.Printf("%s\tchanges pushed to %s %s\n", r.Dest.Path, defaultGitRemote, t.Dest)
 
-	return nil
+	for _, tag := range tags {
+		err = tools.GitPush(dst, defaultGitRemote, fmt.Sprintf("refs/tags/%s", tag))
+		if err != nil {
+			return fmt.Errorf("Failed to git-push tag %s to %s: %s", tag, r.Dest.Path, err)
+		}
+
+		fmt.Printf("%s\ttag %s pushed to %s\n", r.Dest.Path, tag, defaultGitRemote)
+	}
+
+	return r.runHooks(HookPostPush, dst, goEnv, append(treeEnv(t), "SYNC_SOURCE_COMMIT="+srcCommit, "SYNC_DEST_COMMIT="+destCommit, "SYNC_DEST_BRANCH="+t.Dest)...)
+}
+
+// contains returns true if the list contains the item
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
 }
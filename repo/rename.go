@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// renamer returns the Replacer for the path renames of the pair, or nil if it has none
+func (r *RepoPair) renamer() (*tools.Replacer, error) {
+	if len(r.Rename) == 0 {
+		return nil, nil
+	}
+
+	renamer, err := tools.NewReplacer(r.Rename)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid path rename of %s: %s", r.String(), err)
+	}
+
+	return renamer, nil
+}
+
+// renameFunc returns a function that renames relative paths with the renamer, or nil if the renamer is nil
+func renameFunc(renamer *tools.Replacer) func(string) string {
+	if renamer == nil {
+		return nil
+	}
+
+	return renamer.ReplaceString
+}
+
+// renamePaths moves the files archived from src to dst to the paths that the renamer maps them to, with git mv for
+// files that are tracked in dst. It fails if two files would end up at the same path.
+func (r *RepoPair) renamePaths(src, dst string, renamer *tools.Replacer) error {
+	if renamer == nil {
+		return nil
+	}
+
+	files := make(map[string]bool)
+	lister := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range diffExclude {
+			if tools.IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !info.IsDir() {
+			files[rel] = true
+		}
+		return nil
+	}
+
+	err := filepath.Walk(src, lister)
+	if err != nil {
+		return err
+	}
+
+	// Renamed paths and the files they come from
+	renamed := make(map[string]string)
+	moves := make([]string, 0)
+	for f := range files {
+		to := renamer.ReplaceString(f)
+		prev, exists := renamed[to]
+		if exists {
+			return fmt.Errorf("Can't rename both %s and %s to %s", prev, f, to)
+		}
+		renamed[to] = f
+
+		if to != f {
+			if files[to] {
+				// The file at to would be replaced before it's moved itself
+				return fmt.Errorf("Can't rename %s to %s, which is also a file in %s", f, to, r.Source.Path)
+			}
+
+			moves = append(moves, f)
+		}
+	}
+	sort.Strings(moves)
+
+	for _, from := range moves {
+		to := renamer.ReplaceString(from)
+		err = tools.GitMv(dst, from, to)
+		if err != nil {
+			return fmt.Errorf("Failed to move %s to %s: %s", from, to, err)
+		}
+
+		// Remove the directories that were emptied by the move, so that they aren't pruned
+		for dir := path.Dir(from); dir != "."; dir = path.Dir(dir) {
+			if os.Remove(filepath.Join(dst, filepath.FromSlash(dir))) != nil {
+				break
+			}
+		}
+
+		fmt.Printf("%s\trenamed %s to %s\n", r.Dest.Path, from, to)
+	}
+
+	return nil
+}
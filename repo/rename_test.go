@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+func TestRenamePaths(t *testing.T) {
+	const ann = "Ann <ann@example.org>"
+	exported := writeTree(t, map[string]string{
+		"cmd/soterium-cli/main.go":  "package main\n",
+		"cmd/soterium-cli/flags.go": "package main\n\nvar verbose bool\n",
+		"README.md":                 "soterium\n",
+	})
+
+	// The dest tree has the files of an earlier sync, under their names in source
+	dst := writeTree(t, map[string]string{
+		"cmd/soterium-cli/main.go": "package main\n",
+		"cmd/soterium-cli/old.go":  "package main\n\nvar old bool\n",
+		"README.md":                "soterium\n",
+	})
+	dstGit := testGit(t, dst)
+	dstGit(ann, "init", "--quiet")
+	dstGit(ann, "add", ".")
+	dstGit(ann, "commit", "--quiet", "-m", "Earlier sync")
+
+	r := &RepoPair{
+		Dest:   GitRepo{Path: "github.com/soteria-dag/soteria-cli"},
+		Rename: [][]string{{"soterium-cli", "soteria-cli"}},
+	}
+	renamer, err := r.renamer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tools.CopyTree(exported, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.renamePaths(exported, dst, renamer)
+	if err != nil {
+		t.Fatalf("renamePaths failed: %s", err)
+	}
+
+	// main.go is tracked, so it's moved with git mv, and flags.go is new, so it's moved without git
+	tracked := strings.Fields(dstGit(ann, "ls-files"))
+	want := []string{"README.md", "cmd/soteria-cli/main.go", "cmd/soterium-cli/old.go"}
+	if !reflect.DeepEqual(tracked, want) {
+		t.Errorf("Tracked files after renamePaths are %q, want %q", tracked, want)
+	}
+	untracked := dstGit(ann, "ls-files", "--others")
+	if untracked != "cmd/soteria-cli/flags.go" {
+		t.Errorf("Untracked files after renamePaths are %q, want %q", untracked, "cmd/soteria-cli/flags.go")
+	}
+
+	// Pruning and comparing expect the files of source under their renamed paths, so what's left under the source
+	// path is pruned
+	pruned, err := tools.GitPrune(dst, exported, renameFunc(renamer))
+	if err != nil || !reflect.DeepEqual(pruned, []string{"cmd/soterium-cli"}) {
+		t.Errorf("GitPrune of the renamed tree returned %q, %v, want %q", pruned, err, "cmd/soterium-cli")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "cmd", "soterium-cli")); !os.IsNotExist(err) {
+		t.Errorf("Directory cmd/soterium-cli is left after renaming and pruning: %v", err)
+	}
+
+	diff, err := tools.CompareTreesRenamed(exported, dst, renameFunc(renamer), diffExclude...)
+	if err != nil || len(diff) > 0 {
+		t.Errorf("Renamed tree differs from source: %v\n%s", err, diff.String())
+	}
+
+	diff, err = tools.CompareTreesRenamed(exported, dst, renameFunc(nil), diffExclude...)
+	if err != nil || len(diff) == 0 {
+		t.Errorf("Renamed tree is the same as source without renaming: %v", err)
+	}
+}
+
+func TestRenamePathsCollision(t *testing.T) {
+	// A file is renamed to the path of another file of source
+	exported := writeTree(t, map[string]string{
+		"cmd/soterium/main.go": "package main\n",
+		"cmd/soteria/main.go":  "package main\n",
+	})
+	r := &RepoPair{Rename: [][]string{{"soterium", "soteria"}}}
+	renamer, err := r.renamer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.renamePaths(exported, t.TempDir(), renamer)
+	if err == nil {
+		t.Errorf("renamePaths didn't fail for two files renamed to the same path")
+	}
+
+	// Nothing is moved without renames
+	renamer, err = (&RepoPair{}).renamer()
+	if err != nil || renamer != nil || renameFunc(renamer) != nil {
+		t.Errorf("renamer without renames returned %v, %v", renamer, err)
+	}
+	err = r.renamePaths(exported, t.TempDir(), renamer)
+	if err != nil {
+		t.Errorf("renamePaths without renames returned %v", err)
+	}
+}
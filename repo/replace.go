@@ -0,0 +1,196 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// replacements returns the sets of strings {old, new} that are replaced when syncing the target: the source git tree,
+// the source repo path, the dependency source repo paths, and finally the custom strings of the pair.
+//
+// They're applied in a single pass (see tools.Replacer), so where old strings overlap, the longest one is replaced,
+// and if the same old string is listed more than once, the first one wins.
+func (r *RepoPair) replacements(t branchTarget) ([][]string, error) {
+	replacements, err := r.pathReplacements(t)
+	if err != nil {
+		return replacements, err
+	}
+
+	custom, err := r.customReplacements()
+	if err != nil {
+		return replacements, err
+	}
+
+	return append(replacements, custom...), nil
+}
+
+// pathReplacements returns the sets of strings {old, new} that map references to the source repos to the dest repos
+// when syncing the target: the source git tree, the source module and repo paths, and those of the dependencies.
+func (r *RepoPair) pathReplacements(t branchTarget) ([][]string, error) {
+	replacements := make([][]string, 0)
+
+	err := r.checkMajor()
+	if err != nil {
+		return replacements, err
+	}
+	for _, dep := range r.Dependencies {
+		err = dep.checkMajor()
+		if err != nil {
+			return replacements, err
+		}
+	}
+
+	// References to source repo git tree become dest repo & git tree
+	gitTreeOld := path.Join(r.Source.Path, t.Source)
+	gitTreeNew := path.Join(r.Dest.Path, t.Dest)
+	replacements = append(replacements, []string{gitTreeOld, gitTreeNew})
+
+	// References to source module path become dest module path, when they differ from the repo paths or the major
+	// version changes. This handles go import statements of vanity module paths and major version suffixes.
+	replacements = appendModuleReplacement(replacements, r)
+
+	// References to source repo path become dest repo path. This handles go import statements.
+	replacements = append(replacements, []string{r.Source.RepoPath(), r.Dest.RepoPath()})
+
+	// References to dependency source repos become dependency dest repos
+	for _, dep := range r.Dependencies {
+		replacements = appendModuleReplacement(replacements, dep)
+		replacements = append(replacements, []string{dep.Source.RepoPath(), dep.Dest.RepoPath()})
+	}
+
+	return replacements, nil
+}
+
+// customReplacements returns the custom sets of strings {old, new} of the pair, checking that they can be applied
+func (r *RepoPair) customReplacements() ([][]string, error) {
+	replacements := make([][]string, 0, len(r.Replace))
+	for _, replaceCase := range r.Replace {
+		if len(replaceCase) != 2 {
+			return replacements, fmt.Errorf("Can't apply replacement (%s): Need to specify an old and new string", replaceCase)
+		}
+		if len(replaceCase[0]) == 0 {
+			return replacements, fmt.Errorf("Can't apply replacement (%s): The old string is empty", replaceCase)
+		}
+
+		replacements = append(replacements, replaceCase)
+	}
+
+	return replacements, nil
+}
+
+// appendModuleReplacement appends a replacement of the source module path of the pair with its dest module path,
+// if either of them is set separately from the repo path, or the pair changes the major version of the module.
+func appendModuleReplacement(replacements [][]string, r *RepoPair) [][]string {
+	if len(r.Source.Module) == 0 && len(r.Dest.Module) == 0 && r.Source.Major == r.Dest.Major {
+		// Module paths are covered by the repo path replacement, which keeps their major version suffix
+		return replacements
+	}
+
+	old, new := r.modulePaths()
+	return append(replacements, []string{old, new})
+}
+
+// modulePaths returns the source and dest module paths of the pair, with their major version suffixes if the pair
+// changes the major version. Otherwise, the paths have no suffix, and paths under them keep theirs.
+func (r *RepoPair) modulePaths() (string, string) {
+	if r.Source.Major == 0 || r.Dest.Major == 0 {
+		return r.Source.ModulePath(), r.Dest.ModulePath()
+	}
+
+	return r.Source.MajorModulePath(), r.Dest.MajorModulePath()
+}
+
+// checkMajor checks that the major version of the module is set on both repos of the pair, or on neither
+func (r *RepoPair) checkMajor() error {
+	if (r.Source.Major == 0) != (r.Dest.Major == 0) {
+		return fmt.Errorf("Can't change the major version of the module of %s: Major needs to be set on both repos", r.String())
+	}
+	if r.Source.Major < 0 || r.Dest.Major < 0 {
+		return fmt.Errorf("Invalid major version of the module of %s: %d => %d", r.String(), r.Source.Major, r.Dest.Major)
+	}
+
+	return nil
+}
+
+// replacer returns the Replacer for the replacements of the target
+func (r *RepoPair) replacer(t branchTarget) (*tools.Replacer, error) {
+	replacements, err := r.replacements(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return tools.NewReplacer(replacements)
+}
+
+// invertReplacements returns the replacements that undo the given replacements, by swapping each {old, new} set,
+// in reverse order so that the last of the sets sharing a new string is the one that wins.
+// Sets with an empty new string are left out, because what they removed can't be found again.
+func invertReplacements(replacements [][]string) [][]string {
+	inverted := make([][]string, 0, len(replacements))
+	for i := len(replacements) - 1; i >= 0; i-- {
+		if len(replacements[i][1]) == 0 {
+			continue
+		}
+
+		inverted = append(inverted, []string{replacements[i][1], replacements[i][0]})
+	}
+
+	return inverted
+}
+
+// replaceOptions returns the options for making replacements in the files of the pair, in the tree at path
+func (r *RepoPair) replaceOptions(path string) (tools.ReplaceOptions, error) {
+	nested, err := moduleFiles(path)
+	if err != nil {
+		return tools.ReplaceOptions{}, err
+	}
+
+	opts := tools.ReplaceOptions{
+		Exclude:   append(append([]string{}, renameExclude...), nested...),
+		MaxSize:   r.ReplaceMaxSize,
+		Binary:    r.BinaryFiles,
+		Generated: r.GeneratedFiles,
+	}
+
+	switch {
+	case r.ReplaceMaxSize < 0:
+		opts.MaxSize = 0
+	case r.ReplaceMaxSize == 0:
+		opts.MaxSize = defaultReplaceMaxSize
+	}
+
+	return opts, nil
+}
+
+// printReplaceReport prints the number of matches of each rule of the replacer, in total and in each changed file,
+// and the files that were skipped and why.
+func (r *RepoPair) printReplaceReport(replacer *tools.Replacer, report *tools.ReplaceReport) {
+	rules := replacer.Rules()
+	for i, rule := range rules {
+		matches, files := report.Total(i)
+		fmt.Printf("%s\tmade %d replacements in %d files for %s => %s\n", r.Dest.Path, matches, files, rule[0], rule[1])
+	}
+
+	for _, f := range report.Changed() {
+		counts := make([]string, 0)
+		for i, count := range report.Files[f] {
+			if count > 0 {
+				counts = append(counts, fmt.Sprintf("%s => %s (%d)", rules[i][0], rules[i][1], count))
+			}
+		}
+
+		fmt.Printf("%s\treplaced in %s: %s\n", r.Dest.Path, f, strings.Join(counts, ", "))
+	}
+
+	for _, f := range report.Skipped {
+		if f.Reason == tools.ReasonOversize {
+			fmt.Printf("%s\tskipped replacements in %s (%s)\n", r.Dest.Path, f.Path, f.Reason)
+			continue
+		}
+
+		fmt.Printf("%s\tskipped %d replacements in %s (%s)\n", r.Dest.Path, f.Matches, f.Path, f.Reason)
+	}
+}
@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/soterium/sync_priv_pub/forge"
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// reviewPrefix returns the prefix of the branches that changes to the dest tree of the target are pushed to for review
+// (ex: sync/soterium/soterd/master/). It names the pair by its source repo, which tells apart the pairs syncing to the
+// same dest repo, followed by the dest tree, since a pair can sync several.
+func (r *RepoPair) reviewPrefix(t branchTarget) string {
+	return path.Join("sync", r.Source.RepoPath(), t.Dest) + "/"
+}
+
+// reviewBranch returns the name of the branch that changes to the dest tree of the target are pushed to for review by
+// the sync at now (ex: sync/soterium/soterd/master/20191114-093000). Each sync pushes to a new branch, so that commits
+// pushed to the branch of an earlier pull request aren't overwritten.
+func (r *RepoPair) reviewBranch(t branchTarget, now time.Time) string {
+	return r.reviewPrefix(t) + now.UTC().Format("20060102-150405")
+}
+
+// pushReview pushes the commit in dst to a new review branch, and opens a pull request from it to the dest tree of
+// the target. The pull requests that earlier syncs of the target left open are closed, with a comment pointing to the
+// new one. base is the dest commit before the sync commit, and srcCommit is the source commit that was synced.
+// Replacements are applied to the source commit subjects listed in the pull request. The post-push hooks are run with
+// goEnv once it's opened.
+func (r *RepoPair) pushReview(src, dst string, t branchTarget, newBranch bool, base, srcCommit string, skipAsk bool, commitMsg string, replacer *tools.Replacer, goEnv []string) error {
+	head, _, err := tools.GitRevParse(dst, "HEAD")
+	if err != nil {
+		return fmt.Errorf("Failed to determine HEAD commit in %s: %s", dst, err)
+	}
+	if head == base {
+		fmt.Printf("%s\tno changes to %s, not opening a pull request\n", r.Dest.Path, t.Dest)
+		return nil
+	}
+
+	branch := r.reviewBranch(t, time.Now())
+	body, err := r.reviewBody(src, dst, t, base, srcCommit, replacer)
+	if err != nil {
+		return err
+	}
+
+	client, err := r.Dest.ForgeClient()
+	if err != nil {
+		return fmt.Errorf("Can't open pull requests on %s: %s", r.Dest.Path, err)
+	}
+
+	if !skipAsk {
+		// Ask the user if they want to push
+		fmt.Printf("About to push changes for %s to %s, and open a pull request to %s\n", r.Dest.Path, branch, t.Dest)
+		ok, err := tools.AskUser()
+		if err != nil {
+			return fmt.Errorf("Failure while asking if commit is ok: %s", err)
+		}
+
+		if !ok {
+			return fmt.Errorf("User aborted")
+		}
+	}
+
+	if newBranch {
+		// The pull request needs a base branch to merge into, so the new dest tree is pushed as it was created
+		err = tools.GitPush(dst, defaultGitRemote, fmt.Sprintf("%s:refs/heads/%s", base, t.Dest))
+		if err != nil {
+			return fmt.Errorf("Failed to git-push new branch %s to %s: %s", t.Dest, r.Dest.Path, err)
+		}
+
+		fmt.Printf("%s\tnew branch pushed to %s %s\n", r.Dest.Path, defaultGitRemote, t.Dest)
+	}
+
+	err = tools.GitPush(dst, defaultGitRemote, fmt.Sprintf("HEAD:refs/heads/%s", branch))
+	if err != nil {
+		return fmt.Errorf("Failed to git-push changes to %s %s: %s", r.Dest.Path, branch, err)
+	}
+
+	fmt.Printf("%s\tchanges pushed to %s %s\n", r.Dest.Path, defaultGitRemote, branch)
+
+	pr := forge.PullRequest{
+		Head:  branch,
+		Base:  t.Dest,
+		Title: strings.SplitN(commitMsg, "\n", 2)[0],
+		Body:  body,
+	}
+	url, err := client.OpenPullRequest(r.Dest.RepoPath(), pr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\topened pull request %s\n", r.Dest.Path, url)
+
+	// The changes of earlier syncs that weren't merged are in this one, but their branches are left as they are, with
+	// any commits that reviewers pushed to them
+	closed, err := client.ClosePullRequests(r.Dest.RepoPath(), pr, r.reviewPrefix(t), fmt.Sprintf("Superseded by %s", url))
+	for _, c := range closed {
+		fmt.Printf("%s\tclosed superseded pull request %s\n", r.Dest.Path, c)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.runHooks(HookPostPush, dst, goEnv, append(treeEnv(t), "SYNC_SOURCE_COMMIT="+srcCommit, "SYNC_DEST_COMMIT="+head, "SYNC_DEST_BRANCH="+branch, "SYNC_PULL_REQUEST="+url)...)
+}
+
+// reviewBody returns the body of the pull request for a sync commit,
+// listing the synced source commits and the files changed in the dest tree. The subjects of the commits are scrubbed
+// by the message rules of the pair, and the body fails if it matches the pair's Leaks.
+func (r *RepoPair) reviewBody(src, dst string, t branchTarget, base, srcCommit string, replacer *tools.Replacer) (string, error) {
+	// The source commit of the previous sync, if there was one, is where the synced commits start
+	_, commits, err := r.syncedLog(src, dst, t, base, srcCommit, replacer)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := tools.GitDiffStat(dst, base, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Failed to summarize changes in %s: %s", dst, err)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Automated sync to `%s`.\n\n", t.Dest)
+	body.WriteString("### Synced commits\n\n")
+	for _, c := range commits {
+		// Subjects dropped by the rules leave the abbreviated id of the commit
+		id, subject, _ := strings.Cut(c, " ")
+		subject, err = r.MessageRules.Apply(subject)
+		if err != nil {
+			return "", fmt.Errorf("Failed to apply message rules of %s to commit %s: %s", r.String(), id, err)
+		}
+
+		fmt.Fprintf(&body, "- %s\n", strings.TrimSpace(id+" "+subject))
+	}
+	body.WriteString("\n### Changed files\n\n```\n")
+	body.WriteString(stat)
+	body.WriteString("```\n")
+
+	err = r.scanMessage("pull request", body.String())
+	if err != nil {
+		return "", err
+	}
+
+	return body.String(), nil
+}
@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReviewBranch(t *testing.T) {
+	now := time.Date(2019, 11, 14, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+	dest := GitRepo{Path: "github.com/soteria-dag/soterd"}
+	for _, tc := range []struct {
+		pair RepoPair
+		t    branchTarget
+		want string
+	}{
+		{
+			pair: RepoPair{Source: GitRepo{Path: "github.com/soterium/soterd"}, Dest: dest},
+			t:    branchTarget{Source: "master", Dest: "master"},
+			want: "sync/soterium/soterd/master/20191114-093000",
+		},
+		{
+			// Pairs syncing to the same dest tree push to different branches
+			pair: RepoPair{Source: GitRepo{Path: "github.com/soterium/soterd-extras"}, Dest: dest},
+			t:    branchTarget{Source: "master", Dest: "master"},
+			want: "sync/soterium/soterd-extras/master/20191114-093000",
+		},
+		{
+			pair: RepoPair{Source: GitRepo{Path: "gitlab.example.com/group/soterium/soterd"}, Dest: dest},
+			t:    branchTarget{Source: "release/1", Dest: "release/1.x"},
+			want: "sync/group/soterium/soterd/release/1.x/20191114-093000",
+		},
+	} {
+		got := tc.pair.reviewBranch(tc.t, now)
+		if got != tc.want {
+			t.Errorf("%s: reviewBranch returned %q, want %q", tc.pair.String(), got, tc.want)
+		}
+
+		prefix := tc.pair.reviewPrefix(tc.t)
+		if !strings.HasPrefix(got, prefix) || strings.Count(got[len(prefix):], "/") > 0 {
+			t.Errorf("%s: reviewPrefix returned %q for branch %q", tc.pair.String(), prefix, got)
+		}
+	}
+}
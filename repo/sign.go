@@ -0,0 +1,237 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// Formats of the signatures of sync commits and tags
+const (
+	SignGPG = "gpg"
+	SignSSH = "ssh"
+)
+
+// Signing configures how sync commits and tags are signed, for dest repos that require signatures.
+//
+// Like Auth, the settings are kept in the local git config of the dest clone (commit.gpgSign, tag.gpgSign,
+// user.signingKey, gpg.format ...), so signing needs the git command, with either backend. GoGitBackend makes the
+// signed commits and tags with it.
+type Signing struct {
+	// Format of the signatures, SignGPG or SignSSH. Defaults to SignGPG.
+	Format string
+	// The GPG key id, or the path of the SSH key (or of its public key, when the private key is held by ssh-agent)
+	Key string
+	// The signing program, when it isn't gpg (for SignGPG) or ssh-keygen (for SignSSH)
+	Program string
+	// Path of the allowed signers file that SSH signatures are verified with (see ssh-keygen(1)), which git needs to
+	// verify them
+	AllowedSigners string
+	// Fail if a sync commit or tag isn't signed, or its signature can't be verified. Otherwise, it's a warning.
+	Required bool
+}
+
+// SourceSignaturePolicy determines which commits of a source tree must be signed by a trusted key before it's synced
+type SourceSignaturePolicy int
+
+const (
+	// Don't verify the signatures of source commits
+	SourceSignaturesOff SourceSignaturePolicy = iota
+	// Verify the signature of the commit at the tip of the source tree
+	SourceSignaturesTip
+	// Verify the signatures of every source commit synced since the previous sync to the dest tree (including merge
+	// commits), and of the tip. It fails if the dest tree has no previous sync, unless the pair sets FirstSyncTipOnly.
+	SourceSignaturesAll
+)
+
+// TrustedSigners are who source commits must be signed by, when the pair verifies their signatures.
+// If neither is set, signatures are verified with the keyring and git config of the user running the sync.
+type TrustedSigners struct {
+	// Directory of the GnuPG keyring holding the trusted keys, for GPG signatures (its GNUPGHOME)
+	Keyring string
+	// Path of the allowed signers file listing the trusted keys, for SSH signatures (see ssh-keygen(1))
+	AllowedSigners string
+}
+
+// env returns the environment that git verifies signatures by the trusted signers in, or nil if they aren't set.
+// Settings passed to git in GIT_CONFIG_* variables of this process are kept, and the allowed signers file is added
+// after them.
+func (s *TrustedSigners) env() ([]string, error) {
+	if len(s.Keyring) == 0 && len(s.AllowedSigners) == 0 {
+		return nil, nil
+	}
+
+	env := make([]string, 0)
+	count := 0
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "GIT_CONFIG_COUNT=") {
+			env = append(env, e)
+			continue
+		}
+
+		value := strings.TrimPrefix(e, "GIT_CONFIG_COUNT=")
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid GIT_CONFIG_COUNT %q in the environment", value)
+		}
+		count = n
+	}
+
+	if len(s.Keyring) > 0 {
+		env = append(env, "GNUPGHOME="+s.Keyring)
+	}
+	if len(s.AllowedSigners) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=gpg.ssh.allowedSignersFile", count),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count, s.AllowedSigners))
+		count++
+	}
+	if count > 0 {
+		env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count))
+	}
+
+	return env, nil
+}
+
+// verifySourceSignatures checks that the commits of the source tree of the target checked out in src are signed by
+// the trusted signers of the pair, as its SourceSignatures policy requires, before anything of them is archived.
+// The previous sync to the dest tree checked out in dst is where the synced commits start.
+func (r *RepoPair) verifySourceSignatures(src, dst string, t branchTarget) error {
+	if r.SourceSignatures == SourceSignaturesOff {
+		return nil
+	}
+
+	tip, _, err := tools.GitRevParse(src, t.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to determine commit of %s in %s: %s", t.Source, src, err)
+	}
+
+	commits := []string{tip}
+	if r.SourceSignatures == SourceSignaturesAll {
+		_, lastSrc, _, err := tools.GitLastTrailer(dst, "HEAD", syncTrailer)
+		if err != nil {
+			return fmt.Errorf("Failed to find last sync commit on %s in %s: %s", t.Dest, dst, err)
+		}
+
+		switch {
+		case len(lastSrc) == 0 && r.FirstSyncTipOnly:
+			fmt.Printf("%s\tno previous sync of %s to %s, only verifying the signature of its tip\n", r.Dest.Path,
+				t.Source, t.Dest)
+		case len(lastSrc) == 0:
+			return fmt.Errorf("Can't verify the signatures of all commits of %s tree %s: %s tree %s has no previous sync to start from (set FirstSyncTipOnly to only verify the tip)",
+				r.Source.Path, t.Source, r.Dest.Path, t.Dest)
+		default:
+			// The previous sync must be in the history, so that commits since then can't be left out
+			_, found, err := tools.GitRevParse(src, lastSrc)
+			if err != nil {
+				return fmt.Errorf("Failed to find commit %s in %s: %s", lastSrc, src, err)
+			}
+			if !found {
+				return fmt.Errorf("Can't verify the signatures of commits of %s tree %s since the previous sync: its commit %s isn't in %s",
+					r.Source.Path, t.Source, lastSrc, r.Source.Path)
+			}
+
+			commits, err = tools.GitRevListAll(src, lastSrc, tip)
+			if err != nil {
+				return fmt.Errorf("Failed to list synced commits of %s in %s: %s", t.Source, src, err)
+			}
+		}
+	}
+
+	env, err := r.TrustedSigners.env()
+	if err != nil {
+		return err
+	}
+	for _, c := range commits {
+		err = tools.GitVerifyCommit(src, c, env...)
+		if err != nil {
+			return fmt.Errorf("Commit %s of %s tree %s isn't signed by a trusted key: %s", c, r.Source.Path, t.Source, err)
+		}
+	}
+
+	fmt.Printf("%s\tverified signatures of %d commits of %s\n", r.Dest.Path, len(commits), t.Source)
+
+	return nil
+}
+
+// configureSigning sets the local git config of the dest clone at dst, so that the commits and tags of the sync are
+// signed with the signing settings of the pair
+func (r *RepoPair) configureSigning(dst string) error {
+	s := r.Signing
+	if s == nil {
+		return nil
+	}
+
+	settings := [][]string{{"commit.gpgSign", "true"}, {"tag.gpgSign", "true"}}
+	switch s.Format {
+	case "", SignGPG:
+		settings = append(settings, []string{"gpg.format", "openpgp"})
+		if len(s.Program) > 0 {
+			settings = append(settings, []string{"gpg.program", s.Program})
+		}
+	case SignSSH:
+		settings = append(settings, []string{"gpg.format", "ssh"})
+		if len(s.Program) > 0 {
+			settings = append(settings, []string{"gpg.ssh.program", s.Program})
+		}
+		if len(s.AllowedSigners) > 0 {
+			settings = append(settings, []string{"gpg.ssh.allowedSignersFile", s.AllowedSigners})
+		}
+	default:
+		return fmt.Errorf("Unsupported signature format %q for %s", s.Format, r.String())
+	}
+
+	if len(s.Key) > 0 {
+		settings = append(settings, []string{"user.signingKey", s.Key})
+	}
+
+	for _, setting := range settings {
+		err := tools.GitLocalConfig(dst, setting[0], setting[1])
+		if err != nil {
+			return fmt.Errorf("Failed to set git config %s in %s: %s", setting[0], dst, err)
+		}
+	}
+
+	fmt.Printf("%s\tsigning commits and tags with %s key %s\n", r.Dest.Path, s.format(), s.Key)
+
+	return nil
+}
+
+// verifySignature checks the signature of the sync commit or tag (the kind of object) named by name in dst, before
+// it's pushed. If it isn't valid, it's an error when the pair requires signatures, and a warning otherwise.
+func (r *RepoPair) verifySignature(dst, kind, name string) error {
+	if r.Signing == nil {
+		return nil
+	}
+
+	verify := tools.GitVerifyCommit
+	if kind == "tag" {
+		verify = tools.GitVerifyTag
+	}
+
+	err := verify(dst, name)
+	switch {
+	case err == nil:
+		fmt.Printf("%s\tverified signature of %s %s\n", r.Dest.Path, kind, name)
+	case r.Signing.Required:
+		return fmt.Errorf("Signature of %s %s in %s isn't valid, and signing is required: %s", kind, name, dst, err)
+	default:
+		fmt.Printf("%s\tsignature of %s %s isn't valid: %s\n", r.Dest.Path, kind, name, err)
+	}
+
+	return nil
+}
+
+// format returns the format of the signatures
+func (s *Signing) format() string {
+	if len(s.Format) == 0 {
+		return SignGPG
+	}
+
+	return s.Format
+}
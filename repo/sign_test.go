@@ -0,0 +1,204 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// testSigningKey returns the path of a new SSH key, and of an allowed signers file trusting it
+func testSigningKey(t *testing.T) (string, string) {
+	if _, exists := tools.Which("ssh-keygen"); !exists {
+		t.Skip("The ssh-keygen command is needed to sign commits")
+	}
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "id_ed25519")
+	output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "ann", "-f", key).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen failed: %s\n%s", err, output)
+	}
+	public, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := filepath.Join(dir, "allowed_signers")
+	err = ioutil.WriteFile(allowed, []byte(`ann@example.org namespaces="git" `+string(public)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, allowed
+}
+
+func TestTrustedSignersEnv(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "")
+	env, err := (&TrustedSigners{}).env()
+	if err != nil || env != nil {
+		t.Errorf("env without trusted signers returned %q, %v, want nil", env, err)
+	}
+
+	for _, tc := range []struct {
+		count string
+		want  []string
+	}{
+		{"", []string{"GIT_CONFIG_KEY_0=gpg.ssh.allowedSignersFile", "GIT_CONFIG_VALUE_0=/keys/allowed", "GIT_CONFIG_COUNT=1"}},
+		{"2", []string{"GIT_CONFIG_KEY_2=gpg.ssh.allowedSignersFile", "GIT_CONFIG_VALUE_2=/keys/allowed", "GIT_CONFIG_COUNT=3"}},
+	} {
+		// Settings the caller passes to git are kept
+		t.Setenv("GIT_CONFIG_COUNT", tc.count)
+		t.Setenv("GIT_CONFIG_KEY_0", "core.abbrev")
+		t.Setenv("GIT_CONFIG_VALUE_0", "12")
+
+		env, err := (&TrustedSigners{AllowedSigners: "/keys/allowed"}).env()
+		if err != nil {
+			t.Errorf("env with GIT_CONFIG_COUNT %q failed: %s", tc.count, err)
+			continue
+		}
+		config := make([]string, 0)
+		for _, e := range env {
+			if strings.HasPrefix(e, "GIT_CONFIG_") && !strings.HasSuffix(e, "_0=core.abbrev") &&
+				!strings.HasSuffix(e, "_0=12") {
+				config = append(config, e)
+			}
+		}
+		if strings.Join(config, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("env with GIT_CONFIG_COUNT %q has the git config %q, want %q", tc.count, config, tc.want)
+		}
+	}
+
+	t.Setenv("GIT_CONFIG_COUNT", "two")
+	_, err = (&TrustedSigners{AllowedSigners: "/keys/allowed"}).env()
+	if err == nil {
+		t.Errorf("env with an invalid GIT_CONFIG_COUNT didn't fail")
+	}
+}
+
+func TestVerifySourceSignatures(t *testing.T) {
+	const ann = "Ann <ann@example.org>"
+	key, allowed := testSigningKey(t)
+	otherKey, _ := testSigningKey(t)
+	src, dst := t.TempDir(), t.TempDir()
+	srcGit, dstGit := testGit(t, src), testGit(t, dst)
+
+	// The second commit is unsigned, and the last one is signed by a key that isn't trusted
+	srcGit(ann, "init", "--quiet")
+	srcGit(ann, "config", "gpg.format", "ssh")
+	commits := make([]string, 0)
+	for i, signingKey := range []string{key, "", key, otherKey} {
+		sign := "--no-gpg-sign"
+		if len(signingKey) > 0 {
+			sign = "--gpg-sign=" + signingKey
+		}
+		srcGit(ann, "commit", "--quiet", "--allow-empty", sign, "-m", fmt.Sprintf("Commit %d", i))
+		commits = append(commits, srcGit(ann, "rev-parse", "HEAD"))
+	}
+	srcGit(ann, "branch", "signed", commits[2])
+	srcGit(ann, "branch", "untrusted", commits[3])
+	dstGit(ann, "init", "--quiet")
+
+	// A dest clone whose last sync is of the source commit, or one without a sync if it's empty
+	dest := func(synced string) string {
+		if len(synced) == 0 {
+			first := t.TempDir()
+			firstGit := testGit(t, first)
+			firstGit(ann, "init", "--quiet")
+			firstGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Initial commit")
+			return first
+		}
+		dstGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Sync\n\n"+syncTrailer+": "+synced)
+		return dst
+	}
+
+	trusted := TrustedSigners{AllowedSigners: allowed}
+	for _, tc := range []struct {
+		name     string
+		policy   SourceSignaturePolicy
+		tipOnly  bool
+		source   string
+		synced   string
+		verified bool
+	}{
+		{name: "off", policy: SourceSignaturesOff, source: "untrusted", synced: commits[0], verified: true},
+		{name: "signed tip", policy: SourceSignaturesTip, source: "signed", synced: commits[0], verified: true},
+		{name: "untrusted tip", policy: SourceSignaturesTip, source: "untrusted", synced: commits[2]},
+		{name: "unsigned since the last sync", policy: SourceSignaturesAll, source: "signed", synced: commits[0]},
+		{name: "signed since the last sync", policy: SourceSignaturesAll, source: "signed", synced: commits[1], verified: true},
+		{name: "untrusted since the last sync", policy: SourceSignaturesAll, source: "untrusted", synced: commits[2]},
+		{name: "first sync", policy: SourceSignaturesAll, source: "signed"},
+		{name: "first sync of the tip", policy: SourceSignaturesAll, tipOnly: true, source: "signed", verified: true},
+		{name: "missing last sync", policy: SourceSignaturesAll, source: "signed", synced: strings.Repeat("1", 40)},
+	} {
+		r := &RepoPair{SourceSignatures: tc.policy, FirstSyncTipOnly: tc.tipOnly, TrustedSigners: trusted}
+		err := r.verifySourceSignatures(src, dest(tc.synced), branchTarget{Source: tc.source, Dest: "master"})
+		if (err == nil) != tc.verified {
+			t.Errorf("%s: verifySourceSignatures returned %v, want verified %t", tc.name, err, tc.verified)
+		}
+	}
+}
+
+func TestConfigureSigning(t *testing.T) {
+	const ann = "Ann <ann@example.org>"
+	key, allowed := testSigningKey(t)
+	dst := t.TempDir()
+	dstGit := testGit(t, dst)
+	dstGit(ann, "init", "--quiet")
+
+	r := &RepoPair{Signing: &Signing{Format: "x509"}}
+	if err := r.configureSigning(dst); err == nil {
+		t.Errorf("configureSigning didn't fail for an unsupported format")
+	}
+
+	r.Signing = &Signing{Format: SignSSH, Key: key, AllowedSigners: allowed}
+	err := r.configureSigning(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for setting, want := range map[string]string{
+		"commit.gpgSign": "true", "tag.gpgSign": "true", "gpg.format": "ssh", "user.signingKey": key,
+		"gpg.ssh.allowedSignersFile": allowed,
+	} {
+		if got := dstGit(ann, "config", "--local", setting); got != want {
+			t.Errorf("configureSigning set %s to %q, want %q", setting, got, want)
+		}
+	}
+
+	// Sync commits and tags are signed by the config, and their signatures are verified
+	dstGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Signed sync")
+	dstGit(ann, "tag", "-m", "Signed release", "v1.0.0")
+	dstGit(ann, "commit", "--quiet", "--allow-empty", "--no-gpg-sign", "-m", "Unsigned sync")
+	dstGit(ann, "tag", "--no-sign", "-a", "-m", "Unsigned release", "v1.0.1")
+
+	for _, tc := range []struct {
+		kind     string
+		name     string
+		required bool
+		verified bool
+	}{
+		{kind: "commit", name: "HEAD~1", required: true, verified: true},
+		{kind: "tag", name: "v1.0.0", required: true, verified: true},
+		{kind: "commit", name: "HEAD", required: true},
+		{kind: "tag", name: "v1.0.1", required: true},
+		{kind: "commit", name: "HEAD", verified: true},
+		{kind: "tag", name: "v1.0.1", verified: true},
+	} {
+		r.Signing.Required = tc.required
+		err := r.verifySignature(dst, tc.kind, tc.name)
+		if (err == nil) != tc.verified {
+			t.Errorf("verifySignature of %s %s with required %t returned %v, want verified %t", tc.kind, tc.name,
+				tc.required, err, tc.verified)
+		}
+	}
+
+	// Nothing is signed or verified without signing settings
+	r.Signing = nil
+	if err := r.verifySignature(dst, "commit", "HEAD"); err != nil {
+		t.Errorf("verifySignature without signing returned %v", err)
+	}
+}
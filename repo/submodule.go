@@ -0,0 +1,221 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// SubmodulePolicy determines what's done with the submodules of a source tree, which git archive leaves empty
+type SubmodulePolicy int
+
+const (
+	// Fail, so that a policy is chosen for the pair
+	SubmoduleFail SubmodulePolicy = iota
+	// Keep the submodules in the dest tree, at the same commits. The urls in .gitmodules are replaced like any other
+	// content, so Replace can point them at the dest repos, as long as they have the same commits.
+	SubmoduleMirror
+	// Copy the files of the submodules (and of their own submodules) into the dest tree, and remove them from .gitmodules
+	SubmoduleVendor
+	// Leave the submodules out of the dest tree, and remove them from .gitmodules
+	SubmoduleSkip
+)
+
+// exportSubmodules applies the submodule policy of the pair to the submodules of the tree in the repo at src, whose
+// files were archived to dir. url is the url that src was cloned from, which relative submodule urls are resolved
+// against. It returns the commit of each submodule that's mirrored, by path.
+func (r *RepoPair) exportSubmodules(src, tree, dir, url string) (map[string]string, error) {
+	links, err := tools.GitGitlinks(src, tree)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list submodules of %s in %s: %s", tree, src, err)
+	}
+
+	paths := make([]string, 0, len(links))
+	for p := range links {
+		// Submodules that are export-ignored aren't archived
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p)))
+		if err == nil {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	switch r.Submodules {
+	case SubmoduleMirror:
+		mirrored := make(map[string]string)
+		for _, p := range paths {
+			mirrored[p] = links[p]
+		}
+
+		return mirrored, nil
+	case SubmoduleVendor:
+		err = r.vendorSubmodules(dir, url, paths, links)
+	case SubmoduleSkip:
+		for _, p := range paths {
+			err = os.Remove(filepath.Join(dir, filepath.FromSlash(p)))
+			if err != nil {
+				return nil, err
+			}
+
+			fmt.Printf("%s\tleft out submodule %s\n", r.Dest.Path, p)
+		}
+	default:
+		return nil, fmt.Errorf("Tree %s of %s has submodules (%s), which need a Submodules policy", tree, r.Source.Path, strings.Join(paths, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tools.RemoveSubmodules(dir, paths)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to remove submodules from .gitmodules in %s: %s", dir, err)
+	}
+
+	return nil, nil
+}
+
+// vendorSubmodules clones the submodules at the paths, and archives their files at the commits of links into dir.
+// Their own submodules are vendored too.
+func (r *RepoPair) vendorSubmodules(dir, url string, paths []string, links map[string]string) error {
+	urls, err := tools.ReadSubmodules(dir)
+	if err != nil {
+		return err
+	}
+
+	auth, err := r.Source.gitAuth()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		subURL, exists := urls[p]
+		if !exists || len(subURL) == 0 {
+			return fmt.Errorf("Submodule %s of %s has no url in .gitmodules", p, r.Source.Path)
+		}
+		subURL = tools.ResolveSubmoduleURL(url, subURL)
+
+		err = r.vendorSubmodule(dir, subURL, p, links[p], auth)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\tvendored submodule %s at %s\n", r.Dest.Path, p, links[p])
+	}
+
+	return nil
+}
+
+// vendorSubmodule clones the submodule at path p from url, and archives its files at the commit into dir. Its own
+// submodules are vendored too. The clone is removed once it's archived.
+func (r *RepoPair) vendorSubmodule(dir, url, p, commit string, auth *tools.GitAuth) error {
+	clone, err := ioutil.TempDir("", "sync_priv_pub-submodule-")
+	if err != nil {
+		return fmt.Errorf("Failed to create directory to clone submodule %s: %s", p, err)
+	}
+	defer os.RemoveAll(clone)
+
+	err = tools.GitClone(url, clone, auth)
+	if err != nil {
+		return fmt.Errorf("Failed to clone submodule %s from %s: %s", p, url, err)
+	}
+
+	subDir := filepath.Join(dir, filepath.FromSlash(p))
+	err = tools.GitArchive(clone, commit, subDir)
+	if err != nil {
+		return fmt.Errorf("Failed to archive submodule %s at %s: %s", p, commit, err)
+	}
+
+	nested, err := tools.GitGitlinks(clone, commit)
+	if err != nil {
+		return fmt.Errorf("Failed to list submodules of %s in %s: %s", commit, clone, err)
+	}
+
+	if len(nested) == 0 {
+		return nil
+	}
+
+	nestedPaths := make([]string, 0, len(nested))
+	for n := range nested {
+		nestedPaths = append(nestedPaths, n)
+	}
+	sort.Strings(nestedPaths)
+
+	err = r.vendorSubmodules(subDir, url, nestedPaths, nested)
+	if err != nil {
+		return err
+	}
+
+	err = tools.RemoveSubmodules(subDir, nestedPaths)
+	if err != nil {
+		return fmt.Errorf("Failed to remove submodules from .gitmodules in %s: %s", subDir, err)
+	}
+
+	return nil
+}
+
+// recordSubmodules records the mirrored submodules in the index of dst, at the paths that the renamer maps them to.
+// Submodules of dst that aren't mirrored anymore are removed first, so that vendored files can take their place.
+func (r *RepoPair) recordSubmodules(dst string, links map[string]string, renamer *tools.Replacer) error {
+	renamed := make(map[string]string)
+	for p, commit := range links {
+		if renamer != nil {
+			p = renamer.ReplaceString(p)
+		}
+		renamed[p] = commit
+	}
+
+	current, err := tools.GitGitlinks(dst, "HEAD")
+	if err != nil {
+		return fmt.Errorf("Failed to list submodules of %s: %s", dst, err)
+	}
+
+	for p := range current {
+		_, mirrored := renamed[p]
+		if !mirrored {
+			err = tools.GitRm(dst, p)
+			if err != nil {
+				return fmt.Errorf("Failed to remove submodule %s: %s", p, err)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(renamed))
+	for p := range renamed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		commit, isSubmodule := current[p]
+		if commit == renamed[p] {
+			continue
+		}
+
+		_, err = os.Lstat(filepath.Join(dst, filepath.FromSlash(p)))
+		if err == nil && !isSubmodule {
+			// Remove the files that were synced at the path before, since the checkout has nothing untracked yet
+			err = tools.GitRm(dst, p)
+			if err != nil {
+				return fmt.Errorf("Failed to remove %s to record submodule: %s", p, err)
+			}
+		}
+
+		err = tools.GitAddGitlink(dst, p, renamed[p])
+		if err != nil {
+			return fmt.Errorf("Failed to record submodule %s at %s: %s", p, renamed[p], err)
+		}
+
+		fmt.Printf("%s\tmirrored submodule %s at %s\n", r.Dest.Path, p, renamed[p])
+	}
+
+	return nil
+}
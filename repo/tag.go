@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/soterium/sync_priv_pub/tools"
+	"golang.org/x/mod/semver"
+)
+
+// SemverPolicy determines which source tags are synced, based on if the dest tags they map to are semantic versions
+type SemverPolicy int
+
+const (
+	// Sync matching tags whether or not they are semantic versions
+	SemverAny SemverPolicy = iota
+	// Only sync matching tags whose dest tag is a semantic version (ex: v0.3.0, v0.3.0-rc.1)
+	SemverOnly
+	// Only sync matching tags whose dest tag is a semantic version without a pre-release or build suffix (ex: v0.3.0)
+	SemverRelease
+)
+
+// TagMap maps source git tags to dest git tags.
+//
+// Source and Dest are glob patterns, which are matched the same way as in BranchMap.
+// (ex: {"v*", "v*"} keeps the tag name, and {"soterd-v*", "v*"} drops a prefix)
+type TagMap struct {
+	// Glob pattern of tags in the source git repo
+	Source string
+	// Pattern of the tag name to use in the dest git repo
+	Dest string
+
+	// Source and Dest, compiled on first use
+	glob *globMap
+}
+
+// String returns a string representing the TagMap
+func (m *TagMap) String() string {
+	return fmt.Sprintf("%s -> %s", m.Source, m.Dest)
+}
+
+// Match returns the dest tag name for the given source tag, and a boolean of if the tag matched the map
+func (m *TagMap) Match(tag string) (string, bool, error) {
+	if m.glob == nil {
+		glob, err := newGlobMap(m.Source, m.Dest)
+		if err != nil {
+			return "", false, err
+		}
+		m.glob = glob
+	}
+
+	name, ok := m.glob.match(tag)
+	return name, ok, nil
+}
+
+// allows returns true if the dest tag is allowed to be synced by the policy.
+// Shorthands that semver accepts (ex: v1.2) aren't semantic versions here, since go modules don't accept them as tags.
+func (p SemverPolicy) allows(tag string) bool {
+	full := semver.IsValid(tag) && semver.Canonical(tag)+semver.Build(tag) == tag
+	switch p {
+	case SemverOnly:
+		return full
+	case SemverRelease:
+		return full && len(semver.Prerelease(tag)) == 0 && len(semver.Build(tag)) == 0
+	default:
+		return true
+	}
+}
+
+// syncTags creates annotated tags in dst for the tags in src that point at commits reachable from the source tree of
+// the target, and returns the names of the created tags. Each tag is put on the dest commit that synced the source
+// commit it points at: HEAD for srcCommit, which was just synced, and earlier sync commits, found by their
+// Sync-Source-Commit trailer, for older tags. Tags on source commits that were only synced along with a later commit
+// don't have a dest commit, and are skipped.
+// Tag messages have the same replacements applied to them as files do.
+func (r *RepoPair) syncTags(src, dst string, t branchTarget, srcCommit string, replacer *tools.Replacer) ([]string, error) {
+	created := make([]string, 0)
+	if len(r.Tags) == 0 {
+		return created, nil
+	}
+
+	srcTags, err := tools.GitTagsReachable(src, srcCommit)
+	if err != nil {
+		return created, fmt.Errorf("Failed to list tags reachable from %s in %s: %s", t.Source, src, err)
+	}
+
+	synced, err := tools.GitTrailers(dst, "HEAD", syncTrailer)
+	if err != nil {
+		return created, fmt.Errorf("Failed to list sync commits on %s in %s: %s", t.Dest, dst, err)
+	}
+
+	// HEAD has the content of srcCommit even when the sync had nothing to commit
+	head, _, err := tools.GitRevParse(dst, "HEAD")
+	if err != nil {
+		return created, fmt.Errorf("Failed to determine HEAD commit in %s: %s", dst, err)
+	}
+	synced[srcCommit] = head
+
+	names := make([]string, 0, len(srcTags))
+	for name := range srcTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, srcTag := range names {
+		dstTag, matched, err := r.matchTag(srcTag)
+		if err != nil {
+			return created, err
+		}
+		if !matched {
+			continue
+		}
+
+		target, found := synced[srcTags[srcTag]]
+		if !found {
+			continue
+		}
+
+		if !r.TagSemver.allows(dstTag) {
+			fmt.Printf("%s\tskipped tag %s (from %s), not allowed by semver policy\n", r.Dest.Path, dstTag, srcTag)
+			continue
+		}
+
+		// The dest tag may already exist from an earlier run. Tags aren't moved once they're published.
+		commit, exists, err := tools.GitRevParse(dst, fmt.Sprintf("refs/tags/%s", dstTag))
+		if err != nil {
+			return created, fmt.Errorf("Failed to look up tag %s in %s: %s", dstTag, dst, err)
+		}
+		if exists {
+			if commit != target {
+				return created, fmt.Errorf("Tag %s already exists in %s at %s, not %s", dstTag, r.Dest.Path, commit, target)
+			}
+
+			// Tags of earlier syncs are found again on every run, so only the ones of this sync are mentioned
+			if target == head {
+				fmt.Printf("%s\ttag %s already exists\n", r.Dest.Path, dstTag)
+			}
+			continue
+		}
+
+		msg, err := tools.GitTagMessage(src, srcTag)
+		if err != nil {
+			return created, fmt.Errorf("Failed to read message of tag %s in %s: %s", srcTag, src, err)
+		}
+		if len(msg) == 0 {
+			// Lightweight tags don't have a message, but the tags we create are annotated
+			msg = srcTag
+		}
+		msg, err = r.MessageRules.Apply(replacer.ReplaceString(msg))
+		if err != nil {
+			return created, fmt.Errorf("Failed to apply message rules of %s to tag %s: %s", r.String(), srcTag, err)
+		}
+		if len(msg) == 0 {
+			msg = dstTag
+		}
+
+		err = r.scanMessage(fmt.Sprintf("message of tag %s", dstTag), msg)
+		if err != nil {
+			return created, err
+		}
+
+		err = tools.GitTag(dst, dstTag, target, msg)
+		if err != nil {
+			return created, fmt.Errorf("Failed to create tag %s in %s: %s", dstTag, dst, err)
+		}
+
+		fmt.Printf("%s\ttagged %s as %s (from %s)\n", r.Dest.Path, target, dstTag, srcTag)
+		created = append(created, dstTag)
+
+		err = r.verifySignature(dst, "tag", dstTag)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// matchTag returns the dest tag name from the first tag map that matches the source tag,
+// and a boolean of if any tag map matched.
+func (r *RepoPair) matchTag(tag string) (string, bool, error) {
+	for i := range r.Tags {
+		m := &r.Tags[i]
+		dest, ok, err := m.Match(tag)
+		if err != nil {
+			return "", false, fmt.Errorf("Can't apply tag map (%s): %s", m.String(), err)
+		}
+		if ok {
+			return dest, true, nil
+		}
+	}
+
+	return "", false, nil
+}
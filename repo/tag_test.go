@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+func TestSemverPolicyAllows(t *testing.T) {
+	for _, tc := range []struct {
+		tag     string
+		only    bool
+		release bool
+	}{
+		{tag: "v1.2.3", only: true, release: true},
+		{tag: "v0.3.0-rc.1", only: true, release: false},
+		{tag: "v2.0.0+build.5", only: true, release: false},
+		{tag: "v1.2", only: false, release: false},
+		{tag: "v1", only: false, release: false},
+		{tag: "1.2.3", only: false, release: false},
+		{tag: "soterd-v1.2.3", only: false, release: false},
+		{tag: "latest", only: false, release: false},
+	} {
+		if got := SemverAny.allows(tc.tag); !got {
+			t.Errorf("SemverAny.allows(%q) = false", tc.tag)
+		}
+		if got := SemverOnly.allows(tc.tag); got != tc.only {
+			t.Errorf("SemverOnly.allows(%q) = %t, want %t", tc.tag, got, tc.only)
+		}
+		if got := SemverRelease.allows(tc.tag); got != tc.release {
+			t.Errorf("SemverRelease.allows(%q) = %t, want %t", tc.tag, got, tc.release)
+		}
+	}
+}
+
+func TestMatchTagThenPolicy(t *testing.T) {
+	// The policy applies to the dest tag, so a prefix dropped by the map doesn't keep a tag from syncing
+	r := &RepoPair{Tags: []TagMap{{Source: "soterd-v*", Dest: "v*"}}, TagSemver: SemverRelease}
+	for _, tc := range []struct {
+		src     string
+		dst     string
+		matched bool
+		allowed bool
+	}{
+		{src: "soterd-v1.2.3", dst: "v1.2.3", matched: true, allowed: true},
+		{src: "soterd-v1.2.3-rc.1", dst: "v1.2.3-rc.1", matched: true, allowed: false},
+		{src: "v1.2.3", matched: false},
+	} {
+		dst, matched, err := r.matchTag(tc.src)
+		if err != nil || matched != tc.matched || dst != tc.dst {
+			t.Errorf("matchTag(%q) = %q, %t, %v, want %q, %t", tc.src, dst, matched, err, tc.dst, tc.matched)
+			continue
+		}
+		if matched && r.TagSemver.allows(dst) != tc.allowed {
+			t.Errorf("TagSemver.allows(%q) = %t, want %t", dst, !tc.allowed, tc.allowed)
+		}
+	}
+}
+
+func TestSyncTags(t *testing.T) {
+	const ann = "Ann <ann@example.org>"
+	src, dst := t.TempDir(), t.TempDir()
+	srcGit, dstGit := testGit(t, src), testGit(t, dst)
+
+	// v0.1.0 is on a commit an earlier sync synced, v0.2.0 on one that was synced along with the tip, and v0.3.0 on
+	// the tip
+	srcGit(ann, "init", "--quiet")
+	srcCommits := make([]string, 0)
+	for _, tag := range []string{"v0.1.0", "v0.2.0", "v0.3.0"} {
+		srcGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Release soterium "+tag)
+		srcGit(ann, "tag", "-a", "-m", "Release soterium "+tag, tag)
+		srcCommits = append(srcCommits, srcGit(ann, "rev-parse", "HEAD"))
+	}
+
+	dstGit(ann, "init", "--quiet")
+	dstGit(ann, "config", "user.name", "Committer")
+	dstGit(ann, "config", "user.email", "committer@example.org")
+	dstGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Sync\n\n"+syncTrailer+": "+srcCommits[0])
+	first := dstGit(ann, "rev-parse", "HEAD")
+	dstGit(ann, "commit", "--quiet", "--allow-empty", "-m", "Sync\n\n"+syncTrailer+": "+srcCommits[2])
+	head := dstGit(ann, "rev-parse", "HEAD")
+
+	replacer, err := tools.NewReplacer([][]string{{"soterium", "soteria-dag"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RepoPair{Dest: GitRepo{Path: "github.com/soteria-dag/soterd"}, Tags: []TagMap{{Source: "v*", Dest: "v*"}}}
+	target := branchTarget{Source: "master", Dest: "master"}
+
+	created, err := r.syncTags(src, dst, target, srcCommits[2], replacer)
+	if err != nil || !reflect.DeepEqual(created, []string{"v0.1.0", "v0.3.0"}) {
+		t.Fatalf("syncTags returned %q, %v", created, err)
+	}
+	for tag, want := range map[string]string{"v0.1.0": first, "v0.3.0": head} {
+		if got := dstGit(ann, "rev-parse", tag+"^{commit}"); got != want {
+			t.Errorf("Tag %s is on %s, want %s", tag, got, want)
+		}
+	}
+	if msg := dstGit(ann, "tag", "-l", "--format=%(contents)", "v0.1.0"); msg != "Release soteria-dag v0.1.0" {
+		t.Errorf("Tag v0.1.0 has message %q", msg)
+	}
+
+	// Tags created by earlier runs are left as they are
+	created, err = r.syncTags(src, dst, target, srcCommits[2], replacer)
+	if err != nil || len(created) > 0 {
+		t.Errorf("syncTags of synced tags returned %q, %v", created, err)
+	}
+
+	// Tags aren't moved once they're published
+	dstGit(ann, "tag", "-d", "v0.1.0")
+	dstGit(ann, "tag", "v0.1.0", head)
+	_, err = r.syncTags(src, dst, target, srcCommits[2], replacer)
+	if err == nil {
+		t.Errorf("syncTags didn't fail for a dest tag on another commit")
+	}
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// Transformer changes the files of a staged dest tree, once it's identical to the source tree (with the pair's Rename
+// applied). The transformers of a pair run in order, each on the output of the ones before it, and their changes are
+// committed together.
+//
+// Custom transformers are implemented in Go, and listed in the pair's Transformers along with the built-in ones.
+type Transformer interface {
+	// Name returns what the transformer is called in what's printed during sync
+	Name() string
+	// Transform changes the files of the staged tree, and reports which ones changed
+	Transform(tree *StagedTree) (*TransformReport, error)
+}
+
+// StagedTree is a dest tree staged for a sync, with what transformers need to know about it
+type StagedTree struct {
+	// Path of the dest clone, whose files are staged
+	Path string
+	// The pair being synced
+	Pair *RepoPair
+	// The source git tree being synced (ex: the branch of a branch map)
+	SourceTree string
+	// The dest git tree being synced to
+	DestTree string
+	// Renames paths like the pair's Rename strings, or nil if it has none
+	Renamer *tools.Replacer
+	// Environment of go commands, which use the staging area as GOPATH
+	GoEnv []string
+}
+
+// TransformReport describes what a Transformer changed
+type TransformReport struct {
+	// Files that changed, by path relative to the tree, sorted
+	Changed []string
+	// Files that weren't changed because of the pair's content policies or size limit, sorted by path
+	Skipped []tools.SkippedFile
+}
+
+// replaceTransformer is a Transformer that makes string replacements. Adjacent ones are run in a single pass, so that
+// their rules don't see the output of each other, and where their old strings overlap, the longest one is replaced.
+type replaceTransformer interface {
+	Transformer
+	// rules returns the sets of strings {old, new} that are replaced in the tree
+	rules(tree *StagedTree) ([][]string, error)
+}
+
+// DefaultTransformers returns the transformers that pairs without Transformers of their own run, in order: the
+// replacements of references to source repos and the custom Replace strings (in a single pass), then the updates of go
+// modules and of glide and dep manifests.
+func DefaultTransformers() []Transformer {
+	return []Transformer{PathRewrite{}, CustomReplace{}, GoModules{}, Manifests{}}
+}
+
+// target returns the branch target of the tree
+func (tree *StagedTree) target() branchTarget {
+	return branchTarget{Source: tree.SourceTree, Dest: tree.DestTree}
+}
+
+// transform runs the transformers of the pair on the staged tree, or the default ones if the pair has none
+func (r *RepoPair) transform(tree *StagedTree) error {
+	transformers := r.Transformers
+	if transformers == nil {
+		transformers = DefaultTransformers()
+	}
+
+	for i := 0; i < len(transformers); i++ {
+		// Adjacent replacing transformers are merged into a single pass
+		replacing := make([]replaceTransformer, 0)
+		for ; i < len(transformers); i++ {
+			rt, isReplacing := transformers[i].(replaceTransformer)
+			if !isReplacing {
+				break
+			}
+			replacing = append(replacing, rt)
+		}
+
+		if len(replacing) > 0 {
+			report, err := r.replaceTransform(tree, replacing...)
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, 0, len(replacing))
+			for _, rt := range replacing {
+				names = append(names, rt.Name())
+			}
+			// The skipped files were printed with the replacements
+			fmt.Printf("%s\t%s changed %d files\n", r.Dest.Path, strings.Join(names, " + "), len(report.Changed))
+			if i == len(transformers) {
+				break
+			}
+		}
+
+		t := transformers[i]
+		report, err := t.Transform(tree)
+		if err != nil {
+			return fmt.Errorf("Failed to transform %s with %s: %s", tree.Path, t.Name(), err)
+		}
+
+		changed := 0
+		if report != nil {
+			changed = len(report.Changed)
+			for _, f := range report.Skipped {
+				fmt.Printf("%s\t%s skipped %s (%s)\n", r.Dest.Path, t.Name(), f.Path, f.Reason)
+			}
+		}
+		fmt.Printf("%s\t%s changed %d files\n", r.Dest.Path, t.Name(), changed)
+	}
+
+	return nil
+}
+
+// replaceTransform makes the replacements of the transformers in the tree, in a single pass, and prints what was replaced
+func (r *RepoPair) replaceTransform(tree *StagedTree, transformers ...replaceTransformer) (*TransformReport, error) {
+	rules := make([][]string, 0)
+	for _, t := range transformers {
+		tRules, err := t.rules(tree)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to transform %s with %s: %s", tree.Path, t.Name(), err)
+		}
+		rules = append(rules, tRules...)
+	}
+
+	replacer, err := tools.NewReplacer(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files under .git, go.mod files and manifests are left alone, since they're handled by other transformers
+	opts, err := r.replaceOptions(tree.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := tools.ReplaceR(tree.Path, replacer, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to replace strings in %s: %s", tree.Path, err)
+	}
+	r.printReplaceReport(replacer, report)
+
+	return &TransformReport{Changed: report.Changed(), Skipped: report.Skipped}, nil
+}
+
+// readFiles returns the content of the files at the relative paths under root, by path. It's nil for files that don't
+// exist.
+func readFiles(root string, rels []string) map[string][]byte {
+	files := make(map[string][]byte)
+	for _, rel := range rels {
+		data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			data = nil
+		}
+		files[rel] = data
+	}
+
+	return files
+}
+
+// changedFiles returns the files (by path relative to root) whose content differs from what readFiles returned before,
+// including files that were created or removed, sorted
+func changedFiles(root string, before map[string][]byte) []string {
+	changed := make([]string, 0)
+	for rel, data := range before {
+		after, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			after = nil
+		}
+
+		if !bytes.Equal(data, after) || (data == nil) != (after == nil) {
+			changed = append(changed, rel)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
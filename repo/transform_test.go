@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noteTransformer is a custom Transformer that appends a note to a file, after recording what the file had
+type noteTransformer struct {
+	file string
+	note string
+	seen *string
+}
+
+// Name returns the name of the transformer
+func (t noteTransformer) Name() string {
+	return "note"
+}
+
+// Transform appends the note to the file
+func (t noteTransformer) Transform(tree *StagedTree) (*TransformReport, error) {
+	n := filepath.Join(tree.Path, filepath.FromSlash(t.file))
+	data, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	*t.seen = string(data)
+
+	err = ioutil.WriteFile(n, append(data, t.note...), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransformReport{Changed: []string{t.file}}, nil
+}
+
+// testTransformPair returns a pair with path and custom replacements, where the custom ones would change the output of
+// the path ones if they didn't run in the same pass
+func testTransformPair(transformers ...Transformer) *RepoPair {
+	return &RepoPair{
+		Source:       GitRepo{Path: "github.com/soterium/soterd"},
+		Dest:         GitRepo{Path: "github.com/soteria-dag/soterd"},
+		Replace:      [][]string{{"soteria-dag", "soteria-project"}, {"Soterium", "Soteria"}},
+		Transformers: transformers,
+	}
+}
+
+func TestTransformPipeline(t *testing.T) {
+	const header = "// Copyright (c) The Soteria DAG developers"
+	dst := writeTree(t, map[string]string{
+		"main.go": "// Copyright (c) The soterd authors\n\npackage main\n\n" +
+			"import _ \"github.com/soterium/soterd/dag\"\n\n" +
+			"// +private\nvar key = \"internal\"\n// -private\n",
+		"gen.go":    "// Code generated by stringer. DO NOT EDIT.\n\npackage main\n\n// +private\nvar x int\n// -private\n",
+		"README.md": "Soterium\n// +private\nInternal notes\n// -private\n",
+	})
+
+	var seen string
+	r := testTransformPair(
+		PathRewrite{},
+		CustomReplace{},
+		noteTransformer{file: "README.md", note: "See github.com/soterium/soterd\n", seen: &seen},
+		PathRewrite{},
+		StripRegions{Begin: "// +private", End: "// -private", Files: []string{"*.go"}},
+		LicenseHeaders{Header: header, Files: []string{"*.go"}, Replace: []string{"// Copyright (c) The soterd authors"}},
+	)
+	tree := &StagedTree{Path: dst, Pair: r, SourceTree: "master", DestTree: "master"}
+	err := r.transform(tree)
+	if err != nil {
+		t.Fatalf("transform failed: %s", err)
+	}
+
+	// The custom transformer sees the output of the replacements before it, and its output is replaced by the ones
+	// after it
+	if seen != "Soteria\n// +private\nInternal notes\n// -private\n" {
+		t.Errorf("Custom transformer saw %q", seen)
+	}
+
+	for rel, want := range map[string]string{
+		// Path and custom replacements don't replace each other's output
+		"main.go": header + "\n\npackage main\n\nimport _ \"github.com/soteria-dag/soterd/dag\"\n\n",
+		// Regions are only removed from the files of StripRegions
+		"README.md": "Soteria\n// +private\nInternal notes\n// -private\nSee github.com/soteria-dag/soterd\n",
+		// Generated files are skipped by StripRegions and LicenseHeaders, like they are by replacements
+		"gen.go": "// Code generated by stringer. DO NOT EDIT.\n\npackage main\n\n// +private\nvar x int\n// -private\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dst, rel))
+		if err != nil || string(data) != want {
+			t.Errorf("Transformed %s is %q, %v, want %q", rel, data, err, want)
+		}
+	}
+}
+
+func TestTransformEndsWithReplacements(t *testing.T) {
+	dst := writeTree(t, map[string]string{"README.md": "Soterium\n"})
+
+	// Replacing transformers at the end of the pipeline run on the output of the custom ones before them
+	var seen string
+	r := testTransformPair(noteTransformer{file: "README.md", note: "Soterium\n", seen: &seen}, CustomReplace{})
+	err := r.transform(&StagedTree{Path: dst, Pair: r, SourceTree: "master", DestTree: "master"})
+	if err != nil {
+		t.Fatalf("transform failed: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "README.md"))
+	if err != nil || string(data) != "Soteria\nSoteria\n" || seen != "Soterium\n" {
+		t.Errorf("Transformed README.md is %q, %v, after the custom transformer saw %q", data, err, seen)
+	}
+
+	// A custom transformer that fails stops the pipeline
+	os.Remove(filepath.Join(dst, "README.md"))
+	err = r.transform(&StagedTree{Path: dst, Pair: r, SourceTree: "master", DestTree: "master"})
+	if err == nil || !strings.Contains(err.Error(), "note") {
+		t.Errorf("transform with a failing custom transformer returned %v", err)
+	}
+}
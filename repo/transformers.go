@@ -0,0 +1,169 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soterium/sync_priv_pub/tools"
+)
+
+// PathRewrite replaces references to the source repos of the pair and its dependencies with the dest repos: the
+// source git tree, the source module and repo paths, and those of the dependencies.
+type PathRewrite struct{}
+
+// CustomReplace replaces the custom Replace strings of the pair
+type CustomReplace struct{}
+
+// GoModules renames the go modules of the tree, maps their requirements, replace directives, retractions and go.work
+// files, and replaces the dependencies of the pair (see updateGoModules)
+type GoModules struct{}
+
+// Manifests rewrites the glide and dep manifests at the root of the tree (see updateManifests)
+type Manifests struct{}
+
+// StripRegions removes regions of files that aren't published, from a line containing the Begin marker to a line
+// containing the End marker (ex: "// +private" and "// -private"), along with those lines
+type StripRegions struct {
+	// Marker of the first line of a region
+	Begin string
+	// Marker of the last line of a region
+	End string
+	// Glob patterns of the files (by path or name) that regions are removed from (ex: "*.go"). All files if empty.
+	Files []string
+}
+
+// LicenseHeaders adds a license header to the start of files (after a #! line), followed by a blank line.
+// The header is written as it is, so it needs the comment syntax of the files it's added to.
+type LicenseHeaders struct {
+	// The header (ex: "// Copyright (c) The Soteria DAG developers")
+	Header string
+	// Glob patterns of the files (by path or name) that the header is added to (ex: "*.go")
+	Files []string
+	// Headers that are replaced by Header when a file starts with one of them (ex: the license header of the source repo)
+	Replace []string
+}
+
+// Name returns the name of the transformer
+func (PathRewrite) Name() string {
+	return "path rewrite"
+}
+
+// Transform replaces references to the source repos in the tree
+func (t PathRewrite) Transform(tree *StagedTree) (*TransformReport, error) {
+	return tree.Pair.replaceTransform(tree, t)
+}
+
+// rules returns the replacements of references to the source repos
+func (PathRewrite) rules(tree *StagedTree) ([][]string, error) {
+	return tree.Pair.pathReplacements(tree.target())
+}
+
+// Name returns the name of the transformer
+func (CustomReplace) Name() string {
+	return "custom replace"
+}
+
+// Transform replaces the custom strings of the pair in the tree
+func (t CustomReplace) Transform(tree *StagedTree) (*TransformReport, error) {
+	return tree.Pair.replaceTransform(tree, t)
+}
+
+// rules returns the custom replacements of the pair
+func (CustomReplace) rules(tree *StagedTree) ([][]string, error) {
+	return tree.Pair.customReplacements()
+}
+
+// Name returns the name of the transformer
+func (GoModules) Name() string {
+	return "go modules"
+}
+
+// Transform updates the go modules and workspaces of the tree
+func (GoModules) Transform(tree *StagedTree) (*TransformReport, error) {
+	// The files that can change are the go.mod, go.sum, go.work and go.work.sum files of the tree
+	files, err := moduleFiles(tree.Path)
+	if err != nil {
+		return nil, err
+	}
+	before := readFiles(tree.Path, append(files, "go.mod", "go.sum", "go.work", "go.work.sum"))
+
+	err = tree.Pair.updateGoModules(tree.Path, tree.Renamer, tree.GoEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransformReport{Changed: changedFiles(tree.Path, before)}, nil
+}
+
+// Name returns the name of the transformer
+func (Manifests) Name() string {
+	return "manifests"
+}
+
+// Transform rewrites the glide and dep manifests of the tree
+func (Manifests) Transform(tree *StagedTree) (*TransformReport, error) {
+	changed, err := tree.Pair.updateManifests(tree.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransformReport{Changed: changed}, nil
+}
+
+// Name returns the name of the transformer
+func (t StripRegions) Name() string {
+	return fmt.Sprintf("strip regions %s ... %s", t.Begin, t.End)
+}
+
+// Transform removes the regions from the files of the tree
+func (t StripRegions) Transform(tree *StagedTree) (*TransformReport, error) {
+	// Files are chosen like those that replacements are made in
+	opts, err := tree.Pair.replaceOptions(tree.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make(map[string]int)
+	changed, skipped, err := tools.RewriteFiles(tree.Path, t.Files, opts, func(rel string, data []byte) ([]byte, error) {
+		stripped, n, err := tools.StripRegions(data, t.Begin, t.End)
+		regions[rel] = n
+
+		return stripped, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to remove regions: %s", err)
+	}
+
+	for _, rel := range changed {
+		fmt.Printf("%s	removed %d regions from %s\n", tree.Pair.Dest.Path, regions[rel], rel)
+	}
+
+	return &TransformReport{Changed: changed, Skipped: skipped}, nil
+}
+
+// Name returns the name of the transformer
+func (t LicenseHeaders) Name() string {
+	return fmt.Sprintf("license headers of %s", strings.Join(t.Files, ", "))
+}
+
+// Transform adds the header to the files of the tree
+func (t LicenseHeaders) Transform(tree *StagedTree) (*TransformReport, error) {
+	if len(t.Header) == 0 || len(t.Files) == 0 {
+		return nil, fmt.Errorf("License headers need a Header and the Files they're added to")
+	}
+
+	// Files are chosen like those that replacements are made in
+	opts, err := tree.Pair.replaceOptions(tree.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, skipped, err := tools.RewriteFiles(tree.Path, t.Files, opts, func(_ string, data []byte) ([]byte, error) {
+		return tools.AddHeader(data, t.Header, t.Replace...), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to add license headers: %s", err)
+	}
+
+	return &TransformReport{Changed: changed, Skipped: skipped}, nil
+}
@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// User name sent with https tokens when none is configured. GitHub accepts any user name with a token.
+	defaultTokenUser = "x-access-token"
+	// Git config setting holding the ssh command of a clone
+	sshCommandConfig = "core.sshCommand"
+)
+
+var (
+	// The git backend used by the Git functions of this package
+	backend GitBackend = &ExecBackend{}
+
+	// Names that can be embedded in the shell command of the token credential helper
+	envName  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	userName = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// GitBackend performs the git operations used to sync repositories.
+//
+// ExecBackend, the default, runs the git command. GoGitBackend is implemented in Go,
+// so that repositories can be synced where git isn't installed.
+type GitBackend interface {
+	// Add stages the named file for commit
+	Add(path, name string) error
+	// AddGitlink records a submodule at name (relative to path) pointing at the commit in the index, and creates its
+	// empty directory, as in a clone where the submodule isn't checked out
+	AddGitlink(path, name, commit string) error
+	// Archive copies the files of the src tree (branch, commit, etc) to dst
+	Archive(src, tree, dst string) error
+	// Authors returns the authors of the commits reachable from to but not from, as "name <email>", sorted and without
+	// duplicates. If from is empty, only the author of the to commit is returned.
+	Authors(path, from, to string) ([]string, error)
+	// Branches returns the names of branches on the remote, as known by the repository at path
+	Branches(path, remote string) ([]string, error)
+	// Checkout switches the checkout to the given tree
+	Checkout(path, tree string) error
+	// CheckoutNew creates a new branch from the start tree, and switches the checkout to it
+	CheckoutNew(path, branch, start string) error
+	// Clone clones the repository at url to dst, with the credentials of auth.
+	// The credentials are also used for later fetches from and pushes to the clone.
+	Clone(url, dst string, auth *GitAuth) error
+	// Commit commits all tracked files to the repository. It isn't an error if there's nothing to commit.
+	Commit(path, msg string) error
+	// FetchAll fetches all remote branches
+	FetchAll(path string) error
+	// Gitlinks returns the commit of each submodule in the tree (branch, commit, etc), by path
+	Gitlinks(path, tree string) (map[string]string, error)
+	// LastTrailer returns the most recent commit reachable from tree with a trailer with the key in its message,
+	// the value of that trailer, and a boolean of if such a commit was found
+	LastTrailer(path, tree, key string) (string, string, bool, error)
+	// LocalConfig sets local git config setting
+	LocalConfig(path, setting, value string) error
+	// LogOneline returns the abbreviated id and subject of the commits reachable from to but not from, newest first.
+	// If from is empty, only the to commit is returned.
+	LogOneline(path, from, to string) ([]string, error)
+	// LsRemote lists the branches of the remote at url with the credentials of auth, to check that it can be connected to
+	LsRemote(url string, auth *GitAuth) error
+	// Mv moves the file from to to (both relative to path), in the working tree, and in the index if it's tracked.
+	// A file at to is replaced.
+	Mv(path, from, to string) error
+	// Push pushes the tree (or refspec) to the remote. Refspecs starting with + are force-pushed.
+	Push(path, remote, tree string) error
+	// Reset discards changes to tracked files and removes untracked files, in the checkout at path
+	Reset(path string) error
+	// RevList returns the commits reachable from to but not from, oldest first, leaving out merge commits unless merges
+	// is true
+	RevList(path, from, to string, merges bool) ([]string, error)
+	// RevParse returns the commit id that rev refers to, and a boolean of if rev exists in the repository at path
+	RevParse(path, rev string) (string, bool, error)
+	// Rm removes the named file or directory from the working tree and the index
+	Rm(path, name string) error
+	// Snapshot copies the files of the src tree (branch, commit, etc) to dst as they're committed, keeping their modes
+	// and symlinks. Unlike Archive, the export-ignore and export-subst attributes aren't applied.
+	Snapshot(src, tree, dst string) error
+	// Tag creates an annotated tag with the message, pointing at the tree
+	Tag(path, name, tree, msg string) error
+	// TagMessage returns the message of an annotated tag, or an empty string for a lightweight tag
+	TagMessage(path, name string) (string, error)
+	// TagsReachable returns the commit that each tag reachable from the tree points at, by tag name
+	TagsReachable(path, tree string) (map[string]string, error)
+	// Trailers returns the most recent commit reachable from tree with a trailer with the key in its message for each
+	// value of the trailer, by value
+	Trailers(path, tree, key string) (map[string]string, error)
+	// Untracked returns a list of untracked files in the git repository at path
+	Untracked(path string) ([]string, error)
+}
+
+// GitAuth holds the credentials used to connect to a remote repository
+type GitAuth struct {
+	// Path of the ssh private key to use, instead of the default identities
+	SSHKey string
+	// Path of the socket of the ssh-agent to use, instead of SSH_AUTH_SOCK
+	SSHAgent string
+	// Name of the environment variable holding the token to use for https
+	TokenEnv string
+	// User name to send with the token. Defaults to x-access-token.
+	TokenUser string
+	// Path of a git credentials file (as written by git-credential-store) to use for https
+	CredentialsFile string
+}
+
+// SetGitBackend sets the git backend used by the Git functions of this package
+func SetGitBackend(b GitBackend) {
+	backend = b
+}
+
+// tokenUser returns the user name to send with the token
+func (a *GitAuth) tokenUser() string {
+	if len(a.TokenUser) == 0 {
+		return defaultTokenUser
+	}
+
+	return a.TokenUser
+}
+
+// authConfig returns the git config settings (key=value) that make the git command use the credentials of auth.
+//
+// These are core.sshCommand and credential.helper. Since GIT_SSH_COMMAND overrides core.sshCommand, the ssh command
+// is also set as GIT_SSH_COMMAND in the environment of the git commands that connect to remotes (see sshEnv).
+// Tokens aren't written to disk; the credential helper reads them from the environment of this process.
+func authConfig(a *GitAuth) ([]string, error) {
+	config := make([]string, 0)
+	if a == nil {
+		return config, nil
+	}
+
+	if ssh := a.sshCommand(); len(ssh) > 0 {
+		config = append(config, fmt.Sprintf("%s=%s", sshCommandConfig, ssh))
+	}
+
+	if len(a.TokenEnv) > 0 || len(a.CredentialsFile) > 0 {
+		// Only use the credentials configured here, rather than those of the user's credential helpers
+		config = append(config, "credential.helper=")
+	}
+
+	if len(a.TokenEnv) > 0 {
+		user := a.tokenUser()
+		if !envName.MatchString(a.TokenEnv) || !userName.MatchString(user) {
+			return config, fmt.Errorf("Invalid token environment variable %q or user %q", a.TokenEnv, user)
+		}
+
+		helper := fmt.Sprintf(`!f() { test "$1" = get && printf 'username=%%s\npassword=%%s\n' '%s' "$%s"; }; f`, user, a.TokenEnv)
+		config = append(config, fmt.Sprintf("credential.helper=%s", helper))
+	}
+
+	if len(a.CredentialsFile) > 0 {
+		config = append(config, fmt.Sprintf("credential.helper=store --file=%s", shellQuote(a.CredentialsFile)))
+	}
+
+	return config, nil
+}
+
+// sshCommand returns the ssh command that connects with the key or agent of auth, or an empty string if it sets neither
+func (a *GitAuth) sshCommand() string {
+	ssh := []string{"ssh"}
+	if len(a.SSHKey) > 0 {
+		ssh = append(ssh, "-i", shellQuote(a.SSHKey), "-o", "IdentitiesOnly=yes")
+	}
+	if len(a.SSHAgent) > 0 {
+		ssh = append(ssh, "-o", shellQuote(fmt.Sprintf("IdentityAgent=%s", a.SSHAgent)))
+	}
+	if len(ssh) == 1 {
+		return ""
+	}
+
+	return strings.Join(ssh, " ")
+}
+
+// shellQuote quotes s for use as a single word in a shell command
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// uniqueSorted returns the list sorted, without duplicates
+func uniqueSorted(list []string) []string {
+	unique := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+	sort.Strings(unique)
+
+	return unique
+}
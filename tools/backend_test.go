@@ -0,0 +1,555 @@
+package tools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// testBackends are the backends that are expected to behave the same way
+var testBackends = map[string]GitBackend{
+	"exec":  &ExecBackend{},
+	"gogit": NewGoGitBackend(),
+}
+
+// testRepo creates a repo with the git command for the backends to read, with a merge, tags, trailers and several
+// authors, and returns its path and the ids of its commits by name. Commit times increase in the order of the commits,
+// so that the order of logs is well defined.
+func testRepo(t *testing.T) (string, map[string]string) {
+	if _, exists := Which("git"); !exists {
+		t.Skip("The git command is needed to create test repos")
+	}
+
+	dir := t.TempDir()
+	commits := make(map[string]string)
+	step := 0
+	git := func(author string, args ...string) string {
+		step++
+		name, email, err := ParseIdentity(author)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		date := fmt.Sprintf("2020-01-01T00:%02d:00Z", step)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+name, "GIT_AUTHOR_EMAIL="+email, "GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_NAME=Committer", "GIT_COMMITTER_EMAIL=committer@example.org", "GIT_COMMITTER_DATE="+date,
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err, output)
+		}
+
+		return strings.TrimSpace(string(output))
+	}
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(name, author, msg string) {
+		git(author, "add", "-A")
+		git(author, "commit", "--quiet", "-m", msg)
+		commits[name] = git(author, "rev-parse", "HEAD")
+	}
+
+	ann := "Ann <ann@example.org>"
+	bob := "Bob <bob@example.org>"
+	git(ann, "init", "--quiet", "--initial-branch=master")
+
+	write("a.txt", "a\n")
+	commit("first", ann, "First commit")
+
+	write("a.txt", "a\nb\n")
+	commit("synced", bob, "Sync from upstream\n\nSome details.\n\nSync-Source-Commit: 0123456789abcdef\nSigned-off-by: Bob <bob@example.org>")
+	git(ann, "tag", "-a", "-m", "Release v1\n\nNotes", "v1")
+	git(ann, "tag", "light")
+
+	git(ann, "checkout", "--quiet", "-b", "topic")
+	write("topic.txt", "topic\n")
+	commit("topic", bob, "Add topic\nwith a subject on two lines")
+
+	git(ann, "checkout", "--quiet", "master")
+	write("c.txt", "c\n")
+	commit("main", ann, "Mention a sync\n\nSync-Source-Commit: in the body\n\nNot a trailer.")
+
+	git(ann, "merge", "--quiet", "--no-ff", "-m", "Merge topic", "topic")
+	commits["merge"] = git(ann, "rev-parse", "HEAD")
+
+	write("d.txt", "d\n")
+	commit("last", ann, "Last commit")
+
+	return dir, commits
+}
+
+func TestBackendLogs(t *testing.T) {
+	dir, commits := testRepo(t)
+	for _, tc := range []struct {
+		name string
+		call func(b GitBackend) (interface{}, error)
+		want interface{}
+	}{
+		{
+			name: "Authors",
+			call: func(b GitBackend) (interface{}, error) { return b.Authors(dir, commits["first"], "master") },
+			want: []string{"Ann <ann@example.org>", "Bob <bob@example.org>"},
+		},
+		{
+			name: "Authors of one commit",
+			call: func(b GitBackend) (interface{}, error) { return b.Authors(dir, "", commits["synced"]) },
+			want: []string{"Bob <bob@example.org>"},
+		},
+		{
+			name: "RevList",
+			call: func(b GitBackend) (interface{}, error) { return b.RevList(dir, commits["synced"], "master", false) },
+			want: []string{commits["topic"], commits["main"], commits["last"]},
+		},
+		{
+			name: "RevList with merges",
+			call: func(b GitBackend) (interface{}, error) { return b.RevList(dir, commits["synced"], "master", true) },
+			want: []string{commits["topic"], commits["main"], commits["merge"], commits["last"]},
+		},
+		{
+			name: "LogOneline",
+			call: func(b GitBackend) (interface{}, error) { return b.LogOneline(dir, commits["main"], "master") },
+			want: []string{
+				commits["last"][:abbrevLen] + " Last commit",
+				commits["merge"][:abbrevLen] + " Merge topic",
+				commits["topic"][:abbrevLen] + " Add topic with a subject on two lines",
+			},
+		},
+		{
+			name: "LogOneline of one commit",
+			call: func(b GitBackend) (interface{}, error) { return b.LogOneline(dir, "", "v1") },
+			want: []string{commits["synced"][:abbrevLen] + " Sync from upstream"},
+		},
+	} {
+		for name, b := range testBackends {
+			got, err := tc.call(b)
+			if err != nil {
+				t.Errorf("%s with the %s backend failed: %s", tc.name, name, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s with the %s backend returned %q, want %q", tc.name, name, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestBackendLastTrailer(t *testing.T) {
+	dir, commits := testRepo(t)
+	for name, b := range testBackends {
+		// The newest commit mentioning the key doesn't have it as a trailer, like git log --grep finds
+		commit, value, found, err := b.LastTrailer(dir, "master", "Sync-Source-Commit")
+		if err != nil || found {
+			t.Errorf("LastTrailer with the %s backend returned %s, %s, %t, %v", name, commit, value, found, err)
+		}
+
+		commit, value, found, err = b.LastTrailer(dir, commits["synced"], "Sync-Source-Commit")
+		if err != nil || !found || commit != commits["synced"] || value != "0123456789abcdef" {
+			t.Errorf("LastTrailer with the %s backend returned %s, %s, %t, %v", name, commit, value, found, err)
+		}
+
+		_, _, found, err = b.LastTrailer(dir, "master", "Missing-Key")
+		if err != nil || found {
+			t.Errorf("LastTrailer with the %s backend found a missing key: %v", name, err)
+		}
+	}
+}
+
+func TestBackendTrailers(t *testing.T) {
+	dir, commits := testRepo(t)
+	for name, b := range testBackends {
+		// Commits that only mention the key in their body aren't listed
+		want := map[string]string{"0123456789abcdef": commits["synced"]}
+		got, err := b.Trailers(dir, "master", "Sync-Source-Commit")
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Trailers with the %s backend returned %q, %v, want %q", name, got, err, want)
+		}
+
+		got, err = b.Trailers(dir, commits["first"], "Sync-Source-Commit")
+		if err != nil || len(got) > 0 {
+			t.Errorf("Trailers of a commit before the trailer with the %s backend returned %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestBackendRefs(t *testing.T) {
+	dir, commits := testRepo(t)
+	for name, b := range testBackends {
+		commit, exists, err := b.RevParse(dir, "v1")
+		if err != nil || !exists || commit != commits["synced"] {
+			t.Errorf("RevParse of an annotated tag with the %s backend returned %s, %t, %v", name, commit, exists, err)
+		}
+
+		_, exists, err = b.RevParse(dir, "missing")
+		if err != nil || exists {
+			t.Errorf("RevParse of a missing rev with the %s backend returned %t, %v", name, exists, err)
+		}
+
+		// Tags on earlier commits are reachable, and annotated tags are peeled
+		want := map[string]string{"light": commits["synced"], "v1": commits["synced"]}
+		tags, err := b.TagsReachable(dir, "master")
+		if err != nil || !reflect.DeepEqual(tags, want) {
+			t.Errorf("TagsReachable with the %s backend returned %q, %v, want %q", name, tags, err, want)
+		}
+
+		tags, err = b.TagsReachable(dir, commits["first"])
+		if err != nil || len(tags) > 0 {
+			t.Errorf("TagsReachable of a commit before the tags with the %s backend returned %q, %v", name, tags, err)
+		}
+
+		msg, err := b.TagMessage(dir, "v1")
+		if err != nil || strings.TrimSpace(msg) != "Release v1\n\nNotes" {
+			t.Errorf("TagMessage of an annotated tag with the %s backend returned %q, %v", name, msg, err)
+		}
+
+		msg, err = b.TagMessage(dir, "light")
+		if err != nil || len(msg) > 0 {
+			t.Errorf("TagMessage of a lightweight tag with the %s backend returned %q, %v", name, msg, err)
+		}
+	}
+}
+
+func TestBackendArchive(t *testing.T) {
+	dir, commits := testRepo(t)
+	archives := make(map[string]string)
+	for name, b := range testBackends {
+		if name == "exec" {
+			if _, exists := Which("tar"); !exists {
+				continue
+			}
+		}
+
+		dst := t.TempDir()
+		err := b.Archive(dir, commits["main"], dst)
+		if err != nil {
+			t.Fatalf("Archive with the %s backend failed: %s", name, err)
+		}
+		archives[name] = dst
+
+		if _, err := os.Stat(filepath.Join(dst, "topic.txt")); !os.IsNotExist(err) {
+			t.Errorf("Archive with the %s backend has a file of another branch", name)
+		}
+	}
+
+	if len(archives) == 2 {
+		diff, err := CompareTrees(archives["exec"], archives["gogit"])
+		if err != nil || len(diff) > 0 {
+			t.Errorf("Archives of the backends differ: %v\n%s", err, diff.String())
+		}
+	}
+}
+
+func TestBackendSnapshot(t *testing.T) {
+	dir, _ := testRepo(t)
+	attrs := "d.txt export-ignore\na.txt export-subst\n"
+	err := ioutil.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(attrs), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("$Format:%H$\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "--quiet", "-m", "Add attributes"}} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Ann", "-c", "user.email=ann@example.org"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+
+	for name, b := range testBackends {
+		dst := t.TempDir()
+		err := b.Snapshot(dir, "master", dst)
+		if err != nil {
+			t.Fatalf("Snapshot with the %s backend failed: %s", name, err)
+		}
+
+		// Files are copied as they're committed, regardless of the export attributes
+		diff, err := CompareTrees(dir, dst, ".git")
+		if err != nil || len(diff) > 0 {
+			t.Errorf("Snapshot with the %s backend differs from the worktree: %v\n%s", name, err, diff.String())
+		}
+	}
+}
+
+func TestGoGitBackendSigning(t *testing.T) {
+	if _, exists := Which("git"); !exists {
+		t.Skip("The git command is needed to sign commits and tags")
+	}
+
+	// A gpg program that signs anything, as git expects gpg to report it
+	dir := t.TempDir()
+	gpg := filepath.Join(t.TempDir(), "gpg")
+	script := "#!/bin/sh\ncat >/dev/null\nprintf '\\n[GNUPG:] SIG_CREATED D 1 8 00 0 0\\n' >&2\n" +
+		"printf -- '-----BEGIN PGP SIGNATURE-----\\n\\nfake\\n-----END PGP SIGNATURE-----\\n'\n"
+	err := ioutil.WriteFile(gpg, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewGoGitBackend()
+	for _, setting := range [][]string{
+		{"user.name", "Ann"}, {"user.email", "ann@example.org"}, {"user.signingKey", "ann@example.org"},
+		{"commit.gpgSign", "true"}, {"tag.gpgSign", "true"}, {"gpg.program", gpg},
+	} {
+		err = b.LocalConfig(dir, setting[0], setting[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Add(dir, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Commit(dir, "Signed commit")
+	if err != nil {
+		t.Fatalf("Signed commit with the gogit backend failed: %s", err)
+	}
+	err = b.Tag(dir, "v1", "HEAD", "Signed tag")
+	if err != nil {
+		t.Fatalf("Signed tag with the gogit backend failed: %s", err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil || !strings.Contains(commit.PGPSignature, "fake") {
+		t.Errorf("Commit with the gogit backend isn't signed: %v", err)
+	}
+
+	ref, err := r.Tag("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := r.TagObject(ref.Hash())
+	if err != nil || !strings.Contains(tag.PGPSignature, "fake") {
+		t.Errorf("Tag with the gogit backend isn't signed: %v", err)
+	}
+}
+
+func TestBackendCommitNothing(t *testing.T) {
+	dir, commits := testRepo(t)
+	for _, setting := range [][]string{{"user.name", "Ann"}, {"user.email", "ann@example.org"}} {
+		err := testBackends["exec"].LocalConfig(dir, setting[0], setting[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// git's messages are translated in other locales, if it has translations for them
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("LANGUAGE", "de")
+	for name, b := range testBackends {
+		err := b.Commit(dir, "Nothing")
+		if err != nil {
+			t.Errorf("Commit without changes with the %s backend failed: %s", name, err)
+		}
+
+		head, _, err := b.RevParse(dir, "HEAD")
+		if err != nil || head != commits["last"] {
+			t.Errorf("Commit without changes with the %s backend made commit %s, %v", name, head, err)
+		}
+	}
+}
+
+func TestAuthConfig(t *testing.T) {
+	helper := func(user, env string) string {
+		return fmt.Sprintf(`credential.helper=!f() { test "$1" = get && printf 'username=%%s\npassword=%%s\n' '%s' "$%s"; }; f`, user, env)
+	}
+
+	for _, tc := range []struct {
+		name string
+		auth *GitAuth
+		want []string
+	}{
+		{"none", nil, []string{}},
+		{"empty", &GitAuth{}, []string{}},
+		{
+			name: "key with spaces",
+			auth: &GitAuth{SSHKey: "/home/ann/my keys/id_ed25519"},
+			want: []string{"core.sshCommand=ssh -i '/home/ann/my keys/id_ed25519' -o IdentitiesOnly=yes"},
+		},
+		{
+			name: "key with a quote",
+			auth: &GitAuth{SSHKey: "/keys/ann's"},
+			want: []string{`core.sshCommand=ssh -i '/keys/ann'\''s' -o IdentitiesOnly=yes`},
+		},
+		{
+			name: "agent",
+			auth: &GitAuth{SSHAgent: "/run/agent sock"},
+			want: []string{"core.sshCommand=ssh -o 'IdentityAgent=/run/agent sock'"},
+		},
+		{
+			name: "token",
+			auth: &GitAuth{TokenEnv: "SOTERIUM_TOKEN"},
+			want: []string{"credential.helper=", helper("x-access-token", "SOTERIUM_TOKEN")},
+		},
+		{
+			name: "token with user",
+			auth: &GitAuth{TokenEnv: "_TOKEN2", TokenUser: "ann.b@example.org"},
+			want: []string{"credential.helper=", helper("ann.b@example.org", "_TOKEN2")},
+		},
+		{
+			name: "credentials file",
+			auth: &GitAuth{CredentialsFile: "/home/ann/git credentials"},
+			want: []string{"credential.helper=", "credential.helper=store --file='/home/ann/git credentials'"},
+		},
+	} {
+		got, err := authConfig(tc.auth)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: authConfig returned %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestAuthConfigInvalidNames(t *testing.T) {
+	// Names are embedded in a shell command, so only those that can't break out of it are accepted
+	for _, auth := range []*GitAuth{
+		{TokenEnv: "TOKEN; rm -rf ~"},
+		{TokenEnv: "1TOKEN"},
+		{TokenEnv: "TOKEN}"},
+		{TokenEnv: "TOKEN", TokenUser: "ann b"},
+		{TokenEnv: "TOKEN", TokenUser: "ann'; id; '"},
+		{TokenEnv: "TOKEN", TokenUser: "$(id)"},
+	} {
+		_, err := authConfig(auth)
+		if err == nil {
+			t.Errorf("authConfig with the token environment variable %q and user %q didn't fail", auth.TokenEnv, auth.TokenUser)
+		}
+	}
+}
+
+func TestAuthConfigShell(t *testing.T) {
+	if _, exists := Which("sh"); !exists {
+		t.Skip("The sh command is needed to run the credential helper")
+	}
+
+	// git runs a helper starting with ! with the shell, and the action as its argument
+	t.Setenv("SYNC_PRIV_PUB_TEST_TOKEN", "s3cret 'quoted'")
+	config, err := authConfig(&GitAuth{TokenEnv: "SYNC_PRIV_PUB_TEST_TOKEN", TokenUser: "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper := strings.TrimPrefix(config[len(config)-1], "credential.helper=!")
+	for action, want := range map[string]string{"get": "username=ann\npassword=s3cret 'quoted'\n", "store": ""} {
+		output, _ := exec.Command("sh", "-c", helper+` "$@"`, "helper", action).Output()
+		if string(output) != want {
+			t.Errorf("Credential helper printed %q for %s, want %q", output, action, want)
+		}
+	}
+
+	// The shell splits the ssh command into the same words, whatever the paths have in them
+	auth := &GitAuth{SSHKey: "/home/ann/my keys/ann's key", SSHAgent: "/run/$USER/agent"}
+	words := strings.Replace(auth.sshCommand(), "ssh", `printf '%s\n'`, 1)
+	output, err := exec.Command("sh", "-c", words).Output()
+	want := "-i\n/home/ann/my keys/ann's key\n-o\nIdentitiesOnly=yes\n-o\nIdentityAgent=/run/$USER/agent\n"
+	if err != nil || string(output) != want {
+		t.Errorf("ssh command %q has the words %q, %v, want %q", auth.sshCommand(), output, err, want)
+	}
+}
+
+func TestExecBackendSSHCommand(t *testing.T) {
+	if _, exists := Which("git"); !exists {
+		t.Skip("The git command is needed to test the ssh command")
+	}
+	if _, exists := Which("sh"); !exists {
+		t.Skip("The sh command is needed to test the ssh command")
+	}
+
+	// A fake ssh that records its arguments instead of connecting
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$SSH_ARGS_FILE\"\nexit 1\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_ARGS_FILE", argsFile)
+	t.Setenv("GIT_SSH_COMMAND", "ssh -i /home/ann/.ssh/id_rsa")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	sshArgs := func() string {
+		args, err := ioutil.ReadFile(argsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(argsFile)
+		return string(args)
+	}
+
+	backend := &ExecBackend{}
+	key := "/keys/sync key"
+	url := "ssh://git@example.invalid/repo.git"
+	want := "-i\n" + key + "\n-o\nIdentitiesOnly=yes\n"
+
+	// The key of the pair overrides the GIT_SSH_COMMAND of the environment for cloning
+	if err := backend.Clone(url, filepath.Join(t.TempDir(), "clone"), &GitAuth{SSHKey: key}); err == nil {
+		t.Fatalf("Clone from %s didn't fail", url)
+	}
+	if args := sshArgs(); !strings.HasPrefix(args, want) {
+		t.Errorf("Clone ran ssh with %q, want it to start with %q", args, want)
+	}
+
+	// and for fetching, from the core.sshCommand the clone keeps
+	path := t.TempDir()
+	for _, args := range [][]string{{"init", "--quiet"}, {"remote", "add", "origin", url}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = path
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s\n%s", output, err)
+		}
+	}
+
+	config, err := authConfig(&GitAuth{SSHKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range config {
+		setting := strings.SplitN(c, "=", 2)
+		if err := backend.LocalConfig(path, setting[0], setting[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.FetchAll(path); err == nil {
+		t.Fatalf("Fetch from %s didn't fail", url)
+	}
+	if args := sshArgs(); !strings.HasPrefix(args, want) {
+		t.Errorf("Fetch ran ssh with %q, want it to start with %q", args, want)
+	}
+
+	// Without an ssh key or agent for the pair, the GIT_SSH_COMMAND of the environment is used
+	if err := backend.Clone(url, filepath.Join(t.TempDir(), "clone"), nil); err == nil {
+		t.Fatalf("Clone from %s didn't fail", url)
+	}
+	if args, want := sshArgs(), "-i\n/home/ann/.ssh/id_rsa\n"; !strings.HasPrefix(args, want) {
+		t.Errorf("Clone without a key ran ssh with %q, want it to start with %q", args, want)
+	}
+}
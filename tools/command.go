@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RunCommand runs the command (its name or path, followed by its arguments) in dir with the environment env, and
+// returns its combined output. Relative paths of commands (ex: ./scripts/gen.sh) are relative to dir, and names are
+// looked up like Which does.
+func RunCommand(dir string, command []string, env []string) ([]byte, error) {
+	if len(command) == 0 || len(command[0]) == 0 {
+		return nil, fmt.Errorf("No command to run")
+	}
+
+	name := command[0]
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+	} else {
+		found, exists := Which(name)
+		if !exists {
+			return nil, fmt.Errorf("Couldn't find %s command", name)
+		}
+		name = found
+	}
+
+	cmd := exec.Command(name, command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,402 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ContentPolicy determines what's done with a file of a special kind (binary or generated) that has matches
+type ContentPolicy int
+
+const (
+	// Leave the file as it is, and report it as skipped
+	ContentSkip ContentPolicy = iota
+	// Make the replacements in the file, like in any other file
+	ContentRewrite
+	// Fail, so that the file can be dealt with by hand
+	ContentFail
+)
+
+// Reasons that a file isn't rewritten
+const (
+	// The file has a NUL byte near its start
+	ReasonBinary = "binary content"
+	// The file has the binary or -diff attribute in a .gitattributes file
+	ReasonAttributes = "binary in .gitattributes"
+	// The file has a "Code generated ... DO NOT EDIT" header
+	ReasonGenerated = "generated code"
+	// The file is larger than the size limit
+	ReasonOversize = "too large"
+	// The file is a Git LFS pointer, which is never rewritten, since it must match the object it refers to
+	ReasonLFSPointer = "Git LFS pointer"
+)
+
+// Name of the files holding git attributes, in any directory of a tree
+const attributesFile = ".gitattributes"
+
+// Header of generated files (https://golang.org/s/generatedcode), also recognized in other comment styles.
+// It's matched against comment lines.
+var generatedHeader = regexp.MustCompile(`^\W*Code generated .* DO NOT EDIT`)
+
+// Comments that a generated header can be in: the prefixes of line comments, and the start and end of block comments
+var (
+	lineComments  = []string{"//", "#", "--", ";", "%"}
+	blockComments = [][]string{{"/*", "*/"}, {"<!--", "-->"}, {"(*", "*)"}, {"{-", "-}"}}
+)
+
+// Attributes holds the attributes set by the .gitattributes files of a tree.
+// Only the attributes that the tools use are kept: binary and diff, export-ignore and export-subst.
+type Attributes struct {
+	// The .gitattributes files, shallowest first
+	files []attributeFile
+}
+
+// attributeFile is a parsed .gitattributes file
+type attributeFile struct {
+	// Directory of the file, relative to the tree ("" for its root)
+	dir   string
+	rules []attributeRule
+}
+
+// attributeRule is a line of a .gitattributes file
+type attributeRule struct {
+	// Matches paths relative to the directory of the file
+	pattern *regexp.Regexp
+	// Matches only the name of the path, for patterns without a /
+	nameOnly bool
+	// Matches only directories, for patterns with a trailing /
+	dirOnly bool
+	// The state that the rule gives each attribute it names
+	values map[string]attributeState
+}
+
+// attributeState is the state that a .gitattributes rule gives an attribute
+type attributeState int
+
+const (
+	// The attribute isn't set or unset (!attr), which is also the default
+	attributeUnspecified attributeState = iota
+	// The attribute is set (attr, or attr=value)
+	attributeSet
+	// The attribute is unset (-attr)
+	attributeUnset
+)
+
+// Attributes that are kept, with the binary macro being kept as -diff
+var knownAttributes = map[string]bool{
+	"diff":          true,
+	"export-ignore": true,
+	"export-subst":  true,
+}
+
+// LoadAttributes reads the .gitattributes files under root, except for those under the exclude paths
+func LoadAttributes(root string, exclude ...string) (*Attributes, error) {
+	a := &Attributes{}
+	loader := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range exclude {
+			if IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if info.Name() != attributesFile || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		dir := path.Dir(rel)
+		if dir == "." {
+			dir = ""
+		}
+
+		in, err := os.Open(n)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		err = a.add(dir, in)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %s", n, err)
+		}
+
+		return nil
+	}
+
+	err := filepath.Walk(root, loader)
+	return a, err
+}
+
+// Binary returns true if the path (relative to the tree, with / separators) has the binary or -diff attribute
+func (a *Attributes) Binary(rel string) bool {
+	return a.lookup(rel, "diff", false) == attributeUnset
+}
+
+// ExportIgnore returns true if the file at rel, or a directory it's under, has the export-ignore attribute, so that
+// git archive leaves it out
+func (a *Attributes) ExportIgnore(rel string) bool {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if a.lookup(dir, "export-ignore", true) == attributeSet {
+			return true
+		}
+	}
+
+	return a.lookup(rel, "export-ignore", false) == attributeSet
+}
+
+// ExportSubst returns true if the file at rel has the export-subst attribute, so that git archive expands the
+// $Format:...$ placeholders in it
+func (a *Attributes) ExportSubst(rel string) bool {
+	return a.lookup(rel, "export-subst", false) == attributeSet
+}
+
+// add parses the .gitattributes file read from in, in the directory dir of the tree
+func (a *Attributes) add(dir string, in io.Reader) error {
+	f, err := parseAttributes(in, dir)
+	if err != nil {
+		return err
+	}
+
+	a.files = append(a.files, f)
+
+	// Attributes of deeper files take precedence, so they're applied last
+	sort.SliceStable(a.files, func(i, j int) bool {
+		return depth(a.files[i].dir) < depth(a.files[j].dir)
+	})
+
+	return nil
+}
+
+// lookup returns the state of the attribute for the path rel (a directory if isDir is true)
+func (a *Attributes) lookup(rel, attr string, isDir bool) attributeState {
+	state := attributeUnspecified
+	if a == nil {
+		return state
+	}
+
+	for _, f := range a.files {
+		if len(f.dir) > 0 && !IsUnder(rel, f.dir) {
+			continue
+		}
+
+		sub := strings.TrimPrefix(strings.TrimPrefix(rel, f.dir), "/")
+		if len(sub) == 0 {
+			// The directory of the file itself
+			continue
+		}
+
+		for _, rule := range f.rules {
+			v, names := rule.values[attr]
+			if !names || (rule.dirOnly && !isDir) {
+				continue
+			}
+
+			name := sub
+			if rule.nameOnly {
+				name = path.Base(sub)
+			}
+
+			if rule.pattern.MatchString(name) {
+				state = v
+			}
+		}
+	}
+
+	return state
+}
+
+// IsGenerated returns true if the data (the start of a file) has a "Code generated ... DO NOT EDIT" header.
+// Like the go convention requires, the header is a comment line before the first line that isn't blank or a comment,
+// so the same text in a string or a doc comment of a handwritten file doesn't count.
+func IsGenerated(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+
+	// End of the block comment that the line is in, if it's in one
+	blockEnd := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if len(blockEnd) == 0 && !hasPrefix(line, lineComments) {
+			start := -1
+			for i, block := range blockComments {
+				if strings.HasPrefix(line, block[0]) {
+					start = i
+					break
+				}
+			}
+			if start < 0 {
+				return false
+			}
+
+			blockEnd = blockComments[start][1]
+			line = strings.TrimPrefix(line, blockComments[start][0])
+		}
+
+		if generatedHeader.MatchString(line) {
+			return true
+		}
+		if len(blockEnd) > 0 && strings.Contains(line, blockEnd) {
+			blockEnd = ""
+		}
+	}
+
+	return false
+}
+
+// hasPrefix returns true if s starts with one of the prefixes
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClassifyContent returns why the file at rel (relative to the tree of attrs) with the data is special:
+// ReasonLFSPointer, ReasonBinary, ReasonAttributes or ReasonGenerated. It returns an empty string for other files.
+func ClassifyContent(rel string, data []byte, attrs *Attributes) string {
+	_, isPointer := ParseLFSPointer(data)
+	switch {
+	case isPointer:
+		return ReasonLFSPointer
+	case IsBinary(data):
+		return ReasonBinary
+	case attrs.Binary(rel):
+		return ReasonAttributes
+	case IsGenerated(data):
+		return ReasonGenerated
+	default:
+		return ""
+	}
+}
+
+// depth returns the number of directories in the relative directory dir
+func depth(dir string) int {
+	if len(dir) == 0 {
+		return 0
+	}
+
+	return strings.Count(dir, "/") + 1
+}
+
+// parseAttributes parses a .gitattributes file read from in, in the directory dir of the tree.
+// Only the known attributes are kept.
+func parseAttributes(in io.Reader, dir string) (attributeFile, error) {
+	f := attributeFile{dir: dir}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+
+		// Patterns that match directories don't apply to the files in them, but they do apply to the directories
+		// themselves, which matters for export-ignore
+		pattern := fields[0]
+		rule := attributeRule{dirOnly: strings.HasSuffix(pattern, "/"), values: make(map[string]attributeState)}
+		pattern = strings.TrimSuffix(pattern, "/")
+		rule.nameOnly = !strings.Contains(pattern, "/")
+
+		for _, attr := range fields[1:] {
+			// The value of attr=value doesn't matter for the known attributes
+			attr = strings.SplitN(attr, "=", 2)[0]
+			switch attr {
+			case "binary":
+				rule.values["diff"] = attributeUnset
+			case "-binary", "!binary":
+				rule.values["diff"] = attributeUnspecified
+			default:
+				state, name := attributeSet, attr
+				switch {
+				case strings.HasPrefix(attr, "-"):
+					state, name = attributeUnset, attr[1:]
+				case strings.HasPrefix(attr, "!"):
+					state, name = attributeUnspecified, attr[1:]
+				}
+
+				if knownAttributes[name] {
+					rule.values[name] = state
+				}
+			}
+		}
+		if len(rule.values) == 0 {
+			continue
+		}
+
+		var err error
+		rule.pattern, err = attributePattern(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return f, fmt.Errorf("Invalid pattern %s: %s", pattern, err)
+		}
+
+		f.rules = append(f.rules, rule)
+	}
+
+	return f, scanner.Err()
+}
+
+// attributePattern returns the regexp for a .gitattributes pattern, where * and ? don't match a /,
+// and ** matches any number of directories.
+func attributePattern(pattern string) (*regexp.Regexp, error) {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated [")
+			}
+
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
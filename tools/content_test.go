@@ -0,0 +1,203 @@
+package tools
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+// testAttributes returns the attributes of the .gitattributes files, by directory
+func testAttributes(t *testing.T, files map[string]string) *Attributes {
+	tree := make(map[string]string)
+	for dir, text := range files {
+		tree[path.Join(dir, attributesFile)] = text
+	}
+
+	a, err := LoadAttributes(writeTree(t, tree))
+	if err != nil {
+		t.Fatalf("LoadAttributes returned %v", err)
+	}
+
+	return a
+}
+
+func TestAttributesBinary(t *testing.T) {
+	a := testAttributes(t, map[string]string{
+		"": `# Comment
+*.png binary
+*.svg -diff
+*.txt diff
+/root.dat binary
+docs/*.pdf binary
+[attr]custom binary
+data/** -diff
+*.bin binary
+kept.bin -binary
+`,
+		"sub": `*.txt binary
+*.png !binary
+`,
+	})
+
+	for _, tc := range []struct {
+		rel    string
+		binary bool
+	}{
+		{"a.png", true},
+		{"img/a.png", true},
+		{"a.svg", true},
+		{"a.txt", false},
+		{"a.go", false},
+		// Patterns with a / match from the directory of the file
+		{"root.dat", true},
+		{"dir/root.dat", false},
+		{"docs/a.pdf", true},
+		{"docs/sub/a.pdf", false},
+		{"data/a/b/c.csv", true},
+		// Later lines take precedence
+		{"kept.bin", false},
+		{"other.bin", true},
+		// Deeper files take precedence, and only apply under their directory
+		{"sub/a.txt", true},
+		{"sub/deeper/a.txt", true},
+		{"sub/a.png", false},
+		{"subdir/a.txt", false},
+	} {
+		if got := a.Binary(tc.rel); got != tc.binary {
+			t.Errorf("Binary(%q) = %t, want %t", tc.rel, got, tc.binary)
+		}
+	}
+
+	var none *Attributes
+	if none.Binary("a.png") {
+		t.Errorf("Binary of nil attributes returned true")
+	}
+}
+
+func TestClassifyContent(t *testing.T) {
+	a := testAttributes(t, map[string]string{"": "*.png binary\n"})
+	pointer := "version https://git-lfs.github.com/spec/v1\n" +
+		"oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+
+	for _, tc := range []struct {
+		rel    string
+		data   string
+		reason string
+	}{
+		{"a.go", "package a\n", ""},
+		{"a.go", "package a\x00", ReasonBinary},
+		{"a.png", "not really an image", ReasonAttributes},
+		{"a.go", "// Code generated by stringer. DO NOT EDIT.\n\npackage a\n", ReasonGenerated},
+		{"a.py", "# Code generated by a tool. DO NOT EDIT.\n", ReasonGenerated},
+		{"a.go", "package a\n\n// Code generated by hand, but not a header\n", ""},
+		{"a.go", "// Code generated, maybe\npackage a\n", ""},
+		// The header is a comment before the first line that isn't blank or a comment
+		{"a.go", "package a\n\nconst header = `\n// Code generated by x. DO NOT EDIT.\n`\n", ""},
+		{"a.go", "package a\n\n/*\nCode generated by x. DO NOT EDIT.\n*/\n", ""},
+		{"a.md", "Files start with\n\n    // Code generated by x. DO NOT EDIT.\n", ""},
+		{"a.go", "// Copyright 2020\n\n//go:build linux\n\n// Code generated by x. DO NOT EDIT.\n\npackage a\n", ReasonGenerated},
+		{"a.py", "#!/usr/bin/env python\n# Code generated by x. DO NOT EDIT.\n", ReasonGenerated},
+		{"a.c", "/*\n * Copyright 2020\n *\n * Code generated by x. DO NOT EDIT.\n */\n", ReasonGenerated},
+		{"a.c", "/* Copyright 2020 */\n/* Code generated by x. DO NOT EDIT. */\nint a;\n", ReasonGenerated},
+		{"a.c", "/* Copyright 2020 */\nint a;\n/* Code generated by x. DO NOT EDIT. */\n", ""},
+		{"a.html", "<!--\n  Code generated by x. DO NOT EDIT.\n-->\n<html>\n", ReasonGenerated},
+		{"a.sql", "-- Code generated by x. DO NOT EDIT.\r\nSELECT 1;\r\n", ReasonGenerated},
+		{"a.bin", pointer, ReasonLFSPointer},
+		// Binary content takes precedence over a generated header
+		{"a.go", "// Code generated by x. DO NOT EDIT.\n\x00", ReasonBinary},
+		// Only the start of a file is sniffed
+		{"a.txt", strings.Repeat("a", binarySniffLen) + "\x00", ""},
+	} {
+		if got := ClassifyContent(tc.rel, []byte(tc.data), a); got != tc.reason {
+			t.Errorf("ClassifyContent(%q, %.40q) = %q, want %q", tc.rel, tc.data, got, tc.reason)
+		}
+	}
+}
+
+func TestAttributesExport(t *testing.T) {
+	a := testAttributes(t, map[string]string{
+		"": `internal/ export-ignore
+/private export-ignore
+*.secret export-ignore
+**/testdata/** export-ignore
+version.go export-subst
+docs/**/*.md export-subst
+keep.secret -export-ignore
+`,
+		"cmd": `tool/ export-ignore
+tool/main.go -export-ignore
+`,
+	})
+
+	for _, tc := range []struct {
+		rel    string
+		ignore bool
+		subst  bool
+	}{
+		{rel: "a.go"},
+		// Patterns of directories apply to everything under them, but not to files with the same name
+		{rel: "internal/a.go", ignore: true},
+		{rel: "pkg/internal/deep/a.go", ignore: true},
+		{rel: "internal", ignore: false},
+		{rel: "private/a.go", ignore: true},
+		{rel: "pkg/private/a.go", ignore: false},
+		{rel: "a.secret", ignore: true},
+		{rel: "keep.secret", ignore: false},
+		{rel: "testdata/x", ignore: true},
+		{rel: "pkg/testdata/x/y", ignore: true},
+		{rel: "version.go", subst: true},
+		{rel: "pkg/version.go", subst: true},
+		{rel: "docs/a.md", subst: true},
+		{rel: "docs/a/b/c.md", subst: true},
+		{rel: "other/a.md"},
+		// A file can't be brought back from a directory that's ignored, like with git archive
+		{rel: "cmd/tool/main.go", ignore: true},
+		{rel: "cmd/tool/other.go", ignore: true},
+		{rel: "tool/main.go", ignore: false},
+	} {
+		if got := a.ExportIgnore(tc.rel); got != tc.ignore {
+			t.Errorf("ExportIgnore(%q) = %t, want %t", tc.rel, got, tc.ignore)
+		}
+		if got := a.ExportSubst(tc.rel); got != tc.subst {
+			t.Errorf("ExportSubst(%q) = %t, want %t", tc.rel, got, tc.subst)
+		}
+	}
+}
+
+func TestAttributePattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "dir/a.go", false},
+		{"a?.go", "ab.go", true},
+		{"a?.go", "a/.go", false},
+		{"**/a.go", "a.go", true},
+		{"**/a.go", "x/y/a.go", true},
+		{"dir/**", "dir/x/y", true},
+		{"dir/**", "other/x", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "b.txt", false},
+		{"[!abc].txt", "d.txt", true},
+		{"a+b.txt", "a+b.txt", true},
+		{"a.txt", "abtxt", false},
+	} {
+		re, err := attributePattern(tc.pattern)
+		if err != nil {
+			t.Errorf("attributePattern(%q) returned %v", tc.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tc.name); got != tc.match {
+			t.Errorf("attributePattern(%q) matching %q = %t, want %t", tc.pattern, tc.name, got, tc.match)
+		}
+	}
+
+	_, err := attributePattern("[abc")
+	if err == nil {
+		t.Errorf("attributePattern didn't fail for an unterminated [")
+	}
+}
@@ -5,84 +5,273 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 )
 
-// DiffR performs a recursive diff between two directories, and returns whether or not their contents are identical
-func DiffR(a, b string, exclude ...string) (bool, error) {
-	diff, exists := Which("diff")
-	if !exists {
-		return false, fmt.Errorf("Couldn't find diff command")
-	}
+// Number of bytes at the start of a file that are checked for NUL bytes and generated code headers (as git does)
+const binarySniffLen = 8000
+
+// ReplaceOptions configures which files ReplaceR rewrites
+type ReplaceOptions struct {
+	// Files under these paths aren't rewritten
+	Exclude []string
+	// Files larger than this many bytes aren't rewritten, if it's above 0
+	MaxSize int64
+	// What's done with binary files (by content or .gitattributes) that have matches
+	Binary ContentPolicy
+	// What's done with generated files that have matches
+	Generated ContentPolicy
+}
+
+// ReplaceReport describes the replacements made by ReplaceR
+type ReplaceReport struct {
+	// Number of matches of each rule (by index of the rule), in each file that was changed, by relative path
+	Files map[string][]int
+	// Files that weren't rewritten, sorted by path
+	Skipped []SkippedFile
+}
 
-	args := []string{
-		"--recursive",
-		"--brief",
+// SkippedFile is a file that ReplaceR or RewriteFiles didn't rewrite
+type SkippedFile struct {
+	// Path relative to the tree
+	Path string
+	// Why the file wasn't rewritten (ex: ReasonBinary)
+	Reason string
+	// Number of matches in the file. It's 0 for files that weren't read, because they're too large, and for the files
+	// of RewriteFiles, which has no rules to match.
+	Matches int
+}
+
+// Total returns the number of matches of the rule (by index), and the number of files it matched in
+func (r *ReplaceReport) Total(rule int) (int, int) {
+	matches, files := 0, 0
+	for _, counts := range r.Files {
+		if counts[rule] > 0 {
+			matches += counts[rule]
+			files++
+		}
 	}
-	for _, p := range exclude {
-		args = append(args, fmt.Sprintf("--exclude=%s", p))
+
+	return matches, files
+}
+
+// Changed returns the relative paths of the files that were changed, sorted
+func (r *ReplaceReport) Changed() []string {
+	changed := make([]string, 0, len(r.Files))
+	for f := range r.Files {
+		changed = append(changed, f)
 	}
-	args = append(args, a)
-	args = append(args, b)
+	sort.Strings(changed)
 
-	cmd := exec.Command(diff, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return false, fmt.Errorf("%s\n%s", output, err)
+	return changed
+}
+
+// IsBinary returns true if the data (the start of a file) looks binary, because it contains a NUL byte
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
 	}
 
-	return true, nil
+	return bytes.IndexByte(data, 0) >= 0
 }
 
-// ReplaceR replaces all non-overlapping occurrences of old with new
-// in all files under the path, except for those under the exclude paths.
-func ReplaceR(path, old, new string, exclude ...string) (int, error) {
-	count := 0
-	oldBytes := []byte(old)
-	newBytes := []byte(new)
+// ReplaceR applies the rules of the replacer to all files under the path, in a single walk of the tree.
+// Files are read and rewritten by a pool of workers.
+//
+// Symlinks, Git LFS pointers and files that are excluded or too large by the options aren't rewritten. Binary and
+// generated files with matches are skipped, rewritten or fail the replacement, depending on the policies of the
+// options. When the policy is to fail, all files are still processed, so that the error lists all of the files that
+// need to be dealt with.
+func ReplaceR(path string, r *Replacer, opts ReplaceOptions) (*ReplaceReport, error) {
+	report := &ReplaceReport{Files: make(map[string][]int)}
+	files := make([]string, 0)
 
-	rename := func(n string, info os.FileInfo, err error) error {
+	attrs, err := LoadAttributes(path, opts.Exclude...)
+	if err != nil {
+		return report, err
+	}
+
+	lister := func(n string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
-		}
-
 		rel, err := filepath.Rel(path, n)
 		if err != nil {
 			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
 		}
+		rel = filepath.ToSlash(rel)
 
 		// Skip renaming files that are under an excluded directory
-		for _, x := range exclude {
+		for _, x := range opts.Exclude {
 			if IsUnder(rel, x) {
-				// The file is under the excluded path, so skip it
-				//fmt.Println("Skipping", rel)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
 		}
 
-		in, err := ioutil.ReadFile(n)
-		if err != nil {
-			return err
+		if !info.Mode().IsRegular() {
+			return nil
 		}
 
-		out := bytes.ReplaceAll(in, oldBytes, newBytes)
-		if bytes.Equal(in, out) {
-			// No replacements made, so no need to re-write the file
+		if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+			report.Skipped = append(report.Skipped, SkippedFile{Path: rel, Reason: ReasonOversize})
 			return nil
 		}
 
-		err = ioutil.WriteFile(n, out, info.Mode())
-		//fmt.Println("Modified", rel)
-		count += 1
+		files = append(files, rel)
+		return nil
+	}
+
+	err = filepath.Walk(path, lister)
+	if err != nil {
+		return report, err
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	failed := make([]string, 0)
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+
+	worker := func() {
+		defer wg.Done()
+		for rel := range jobs {
+			reason, policy, counts, err := replaceFile(path, rel, r, attrs, opts)
+
+			mu.Lock()
+			switch {
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+				}
+			case counts == nil:
+				// No matches
+			case len(reason) == 0 || policy == ContentRewrite:
+				report.Files[rel] = counts
+			case policy == ContentFail:
+				failed = append(failed, fmt.Sprintf("%s (%s)", rel, reason))
+			default:
+				report.Skipped = append(report.Skipped, SkippedFile{Path: rel, Reason: reason, Matches: sum(counts)})
+			}
+			mu.Unlock()
+		}
+	}
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go worker()
+	}
+	for _, rel := range files {
+		jobs <- rel
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(report.Skipped, func(i, j int) bool {
+		return report.Skipped[i].Path < report.Skipped[j].Path
+	})
 
-		return err
+	if firstErr != nil {
+		return report, firstErr
 	}
 
-	err := filepath.Walk(path, rename)
-	return count, err
-}
\ No newline at end of file
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return report, fmt.Errorf("Replacements found in files that the policy doesn't allow rewriting: %s", strings.Join(failed, ", "))
+	}
+
+	return report, nil
+}
+
+// replaceFile applies the rules of the replacer to the file at rel under path, unless its policy is to leave it as it
+// is. It returns why the file is special (see ClassifyContent), the policy for it, and the number of matches of each
+// rule if the file has any.
+func replaceFile(path, rel string, r *Replacer, attrs *Attributes, opts ReplaceOptions) (string, ContentPolicy, []int, error) {
+	n := filepath.Join(path, filepath.FromSlash(rel))
+	info, err := os.Stat(n)
+	if err != nil {
+		return "", ContentRewrite, nil, err
+	}
+
+	in, err := ioutil.ReadFile(n)
+	if err != nil {
+		return "", ContentRewrite, nil, err
+	}
+
+	out, counts := r.Replace(in)
+	if bytes.Equal(in, out) {
+		// No replacements made, so no need to re-write the file
+		return "", ContentRewrite, nil, nil
+	}
+
+	reason := ClassifyContent(rel, in, attrs)
+	policy := opts.policy(reason)
+	if policy != ContentRewrite {
+		return reason, policy, counts, nil
+	}
+
+	err = ioutil.WriteFile(n, out, info.Mode())
+	return reason, policy, counts, err
+}
+
+// policy returns what's done with a file with matches, that's special for the reason (see ClassifyContent)
+func (o ReplaceOptions) policy(reason string) ContentPolicy {
+	switch reason {
+	case ReasonLFSPointer:
+		return ContentSkip
+	case ReasonBinary, ReasonAttributes:
+		return o.Binary
+	case ReasonGenerated:
+		return o.Generated
+	default:
+		return ContentRewrite
+	}
+}
+
+// Rewrites returns true if ReplaceR would make replacements in the file at rel under path with the options, if it has
+// matches: it isn't excluded, a symlink or too large, and its policy is to rewrite it. attrs are the attributes of the
+// tree at path. Files that don't exist aren't rewritten.
+func (o ReplaceOptions) Rewrites(path, rel string, attrs *Attributes) (bool, error) {
+	for _, x := range o.Exclude {
+		if IsUnder(rel, x) {
+			return false, nil
+		}
+	}
+
+	n := filepath.Join(path, filepath.FromSlash(rel))
+	info, err := os.Lstat(n)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !info.Mode().IsRegular() || (o.MaxSize > 0 && info.Size() > o.MaxSize) {
+		return false, nil
+	}
+
+	in, err := ioutil.ReadFile(n)
+	if err != nil {
+		return false, err
+	}
+
+	return o.policy(ClassifyContent(rel, in, attrs)) == ContentRewrite, nil
+}
+
+// sum returns the sum of the counts
+func sum(counts []int) int {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+
+	return total
+}
@@ -1,7 +1,6 @@
 package tools
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"os"
@@ -13,22 +12,15 @@ import (
 // GitAddNew stages new files for commit, and returns the names of those that were added
 func GitAddNew(path string) ([]string, error) {
 	added := make([]string, 0)
-	git, exists := Which("git")
-	if !exists {
-		return added, fmt.Errorf("Couldn't find git command")
-	}
-
 	untracked, err := GitUntracked(path)
 	if err != nil {
 		return added, err
 	}
 
 	for _, n := range untracked {
-		cmd := exec.Command(git, "add", n)
-		cmd.Dir = path
-		output, err := cmd.CombinedOutput()
+		err := backend.Add(path, n)
 		if err != nil {
-			return added, fmt.Errorf("%s\n%s", output, err)
+			return added, err
 		}
 
 		added = append(added, n)
@@ -37,87 +29,91 @@ func GitAddNew(path string) ([]string, error) {
 	return added, nil
 }
 
-// GitArchive takes a copy of files from src tree (branch, commit, etc) and outputs them to dst
-func GitArchive(src, tree, dst string) error {
+// GitAddGitlink records a submodule at name pointing at the commit in the index, and creates its empty directory
+func GitAddGitlink(path, name, commit string) error {
+	return backend.AddGitlink(path, name, commit)
+}
+
+// GitAm applies a patch in mailbox format (as created by GitFormatPatch) as a commit, keeping its original author.
+// If the patch can't be applied, the attempt is aborted so that the repository is left at the last applied commit.
+func GitAm(path string, patch []byte) error {
 	git, exists := Which("git")
 	if !exists {
 		return fmt.Errorf("Couldn't find git command")
 	}
-	tar, exists := Which("tar")
-	if !exists {
-		return fmt.Errorf("Couldn't find tar command")
-	}
-
-	// Issue git archive on the src, and pipe the output to tar at the dst
-	archive := exec.Command(git, "archive", "--format=tar", tree)
-	archive.Dir = src
 
-	out, err := archive.StdoutPipe()
+	cmd := exec.Command(git, "am", "--quiet")
+	cmd.Dir = path
+	cmd.Stdin = bytes.NewReader(patch)
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return err
+		abort := exec.Command(git, "am", "--abort")
+		abort.Dir = path
+		_ = abort.Run()
+
+		return fmt.Errorf("%s\n%s", output, err)
 	}
 
-	untar := exec.Command(tar, "-C", dst, "-xvf", "-")
-	untar.Stdin = out
+	return nil
+}
 
-	err = archive.Start()
-	if err != nil {
-		return err
-	}
-	err = untar.Start()
-	if err != nil {
-		return err
-	}
+// GitArchive takes a copy of files from src tree (branch, commit, etc) and outputs them to dst
+func GitArchive(src, tree, dst string) error {
+	return backend.Archive(src, tree, dst)
+}
 
-	err = archive.Wait()
-	if err != nil {
-		return err
-	}
-	err = untar.Wait()
-	if err != nil {
-		return err
-	}
+// GitAuthors returns the authors of the commits reachable from to but not from, as "name <email>", sorted and without
+// duplicates. If from is empty, only the author of the to commit is returned.
+func GitAuthors(path, from, to string) ([]string, error) {
+	return backend.Authors(path, from, to)
+}
 
-	return nil
+// GitBranches returns the names of branches on the remote, as known by the repository at path
+func GitBranches(path, remote string) ([]string, error) {
+	return backend.Branches(path, remote)
 }
 
 // GitCheckout switches the checkout to the given tree
 func GitCheckout(path, tree string) error {
-	git, exists := Which("git")
-	if !exists {
-		return fmt.Errorf("Couldn't find git command")
-	}
+	return backend.Checkout(path, tree)
+}
 
-	cmd := exec.Command(git, "checkout", tree)
-	cmd.Dir = path
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%s\n%s", output, err)
-	}
+// GitCheckoutNew creates a new branch from the start tree, and switches the checkout to it
+func GitCheckoutNew(path, branch, start string) error {
+	return backend.CheckoutNew(path, branch, start)
+}
 
-	return nil
+// GitClone clones the repository at url to dst, with the credentials of auth (which may be nil)
+func GitClone(url, dst string, auth *GitAuth) error {
+	return backend.Clone(url, dst, auth)
 }
 
 // GitCommit commits all tracked files to the repository
-func GitCommit(path, msg string) (error) {
+func GitCommit(path, msg string) error {
+	return backend.Commit(path, msg)
+}
+
+// GitDiffStat returns a summary of the files changed between the from and to trees. If to is empty, the from tree is
+// compared to the working tree.
+func GitDiffStat(path, from, to string) (string, error) {
 	git, exists := Which("git")
 	if !exists {
-		return fmt.Errorf("Couldn't find git command")
+		return "", fmt.Errorf("Couldn't find git command")
+	}
+
+	args := []string{"diff", "--stat", from}
+	if len(to) > 0 {
+		args = append(args, to)
 	}
 
-	cmd := exec.Command(git, "commit", "-a", "-m", msg)
+	cmd := exec.Command(git, args...)
 	cmd.Dir = path
-	output, err := cmd.CombinedOutput()
+	output, err := cmd.Output()
 	if err != nil {
-		if strings.Contains(string(output), "nothing to commit") {
-			fmt.Print(string(output))
-			return nil
-		}
-
-		return fmt.Errorf("%s\n%s", output, err)
+		return "", fmt.Errorf("%s\n%s", output, err)
 	}
 
-	return nil
+	return string(output), nil
 }
 
 // GitEmail sets the email address for commits in local git config
@@ -127,41 +123,71 @@ func GitEmail(path, email string) error {
 
 // GitFetchAll fetches all remote branches
 func GitFetchAll(path string) error {
+	return backend.FetchAll(path)
+}
+
+// GitFormatPatch returns the changes of a commit as a patch in mailbox format,
+// leaving out changes to files matching the exclude paths.
+func GitFormatPatch(path, commit string, exclude ...string) ([]byte, error) {
 	git, exists := Which("git")
 	if !exists {
-		return fmt.Errorf("Couldn't find git command")
+		return nil, fmt.Errorf("Couldn't find git command")
 	}
 
-	cmd := exec.Command(git, "fetch", "--all")
+	args := []string{"format-patch", "-1", "--stdout", commit, "--", "."}
+	for _, x := range exclude {
+		args = append(args, fmt.Sprintf(":(exclude)%s", x))
+	}
+
+	cmd := exec.Command(git, args...)
 	cmd.Dir = path
-	output, err := cmd.CombinedOutput()
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("%s\n%s", output, err)
+		return nil, fmt.Errorf("%s\n%s", output, err)
 	}
 
-	return nil
+	return output, nil
+}
+
+// GitGitlinks returns the commit of each submodule in the tree, by path
+func GitGitlinks(path, tree string) (map[string]string, error) {
+	return backend.Gitlinks(path, tree)
+}
+
+// GitLastTrailer returns the most recent commit reachable from tree with a trailer with the key in its message,
+// the value of that trailer, and a boolean of if such a commit was found.
+func GitLastTrailer(path, tree, key string) (string, string, bool, error) {
+	return backend.LastTrailer(path, tree, key)
 }
 
 // GitLocalConfig sets local git config setting
 func GitLocalConfig(path, setting, value string) error {
-	git, exists := Which("git")
-	if !exists {
-		return fmt.Errorf("Couldn't find git command")
-	}
+	return backend.LocalConfig(path, setting, value)
+}
 
-	cmd := exec.Command(git, "config", "--local", setting, value)
-	cmd.Dir = path
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%s\n%s", output, err)
-	}
+// GitLogOneline returns the abbreviated id and subject of the commits reachable from to but not from, newest first.
+// If from is empty, only the to commit is returned.
+func GitLogOneline(path, from, to string) ([]string, error) {
+	return backend.LogOneline(path, from, to)
+}
 
-	return nil
+// GitLsRemote lists the branches of the remote at url with the credentials of auth (which may be nil),
+// to check that it can be connected to.
+func GitLsRemote(url string, auth *GitAuth) error {
+	return backend.LsRemote(url, auth)
+}
+
+// GitMv moves the file from to to (both relative to path), in the working tree, and in the index if it's tracked.
+// A file at to is replaced.
+func GitMv(path, from, to string) error {
+	return backend.Mv(path, from, to)
 }
 
 // GitPrune issues "git rm" in path for items in path that aren't in cmp,
 // and returns a list of removed items.
-func GitPrune(path, cmp string) ([]string, error) {
+//
+// If rename isn't nil, the files of cmp are expected in path under the relative paths that rename maps them to.
+func GitPrune(path, cmp string, rename func(string) string) ([]string, error) {
 	pruned := make([]string, 0)
 	// Files in these paths are excluded from pruning.
 	//
@@ -171,9 +197,22 @@ func GitPrune(path, cmp string) ([]string, error) {
 	// Bad: secret
 	exclude := []string{".git"}
 
-	git, exists := Which("git")
-	if !exists {
-		return pruned, fmt.Errorf("Couldn't find git command")
+	// lookup returns true if the relative path is expected in path, and if it's expected to be a directory
+	lookup := func(rel string) (bool, bool) {
+		info, err := os.Stat(filepath.Join(cmp, rel))
+		return err == nil, err == nil && info.IsDir()
+	}
+
+	if rename != nil {
+		expected, err := renamedPaths(cmp, rename, exclude)
+		if err != nil {
+			return pruned, err
+		}
+
+		lookup = func(rel string) (bool, bool) {
+			isDir, exists := expected[filepath.ToSlash(rel)]
+			return exists, isDir
+		}
 	}
 
 	// This function compares files and removes ones that shouldn't exist in path
@@ -197,18 +236,16 @@ func GitPrune(path, cmp string) ([]string, error) {
 		}
 
 		o := filepath.Join(cmp, rel)
-		oInfo, err := os.Stat(o)
-		if os.IsNotExist(err) {
+		exists, isDir := lookup(rel)
+		if !exists {
 			// Remove the file, because it doesn't exist in cmp
-			cmd := exec.Command(git, "rm", "-r", rel)
-			cmd.Dir = path
-			output, err := cmd.CombinedOutput()
+			err := backend.Rm(path, rel)
 			if err != nil {
-				return fmt.Errorf("%s\n%s", string(output), err)
+				return err
 			}
 
 			pruned = append(pruned, rel)
-		} else if oInfo.IsDir() != info.IsDir() {
+		} else if isDir != info.IsDir() {
 			// Fail, because the path in one tree is a file, and in the other it's a directory
 			if info.IsDir() {
 				return fmt.Errorf("%s is a directory but %s is not", n, o)
@@ -230,51 +267,99 @@ func GitPrune(path, cmp string) ([]string, error) {
 
 // GitPush pushes commits to the default remote and tree
 func GitPush(path, remote, tree string) error {
-	git, exists := Which("git")
-	if !exists {
-		return fmt.Errorf("Couldn't find git command")
-	}
+	return backend.Push(path, remote, tree)
+}
 
-	cmd := exec.Command(git, "push", remote, tree)
-	cmd.Dir = path
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%s\n%s", output, err)
-	} else {
-		fmt.Print(string(output))
-	}
+// GitReset discards changes to tracked files and removes untracked files, in the checkout at path
+func GitReset(path string) error {
+	return backend.Reset(path)
+}
 
-	return nil
+// GitRevList returns the commits reachable from to but not from, oldest first, leaving out merge commits
+func GitRevList(path, from, to string) ([]string, error) {
+	return backend.RevList(path, from, to, false)
+}
+
+// GitRevListAll returns the commits reachable from to but not from, oldest first, including merge commits
+func GitRevListAll(path, from, to string) ([]string, error) {
+	return backend.RevList(path, from, to, true)
+}
+
+// GitRevParse returns the commit id that rev refers to, and a boolean of if rev exists in the repository at path
+func GitRevParse(path, rev string) (string, bool, error) {
+	return backend.RevParse(path, rev)
+}
+
+// GitRm removes the named file or directory from the working tree and the index
+func GitRm(path, name string) error {
+	return backend.Rm(path, name)
+}
+
+// GitSnapshot copies the files of the src tree (branch, commit, etc) to dst as they're committed. Unlike GitArchive,
+// the export-ignore and export-subst attributes of the tree aren't applied.
+func GitSnapshot(src, tree, dst string) error {
+	return backend.Snapshot(src, tree, dst)
+}
+
+// GitTag creates an annotated tag with the message, pointing at the tree
+func GitTag(path, name, tree, msg string) error {
+	return backend.Tag(path, name, tree, msg)
+}
+
+// GitTagMessage returns the message of an annotated tag, or an empty string for a lightweight tag
+func GitTagMessage(path, name string) (string, error) {
+	return backend.TagMessage(path, name)
+}
+
+// GitTagsReachable returns the commit that each tag reachable from the tree points at, by tag name
+func GitTagsReachable(path, tree string) (map[string]string, error) {
+	return backend.TagsReachable(path, tree)
+}
+
+// GitTrailers returns the most recent commit reachable from tree with a trailer with the key in its message for each
+// value of the trailer, by value
+func GitTrailers(path, tree, key string) (map[string]string, error) {
+	return backend.Trailers(path, tree, key)
 }
 
 // GitUntracked returns a list of untracked files in the git repository at path
 func GitUntracked(path string) ([]string, error) {
-	untracked := make([]string, 0)
+	return backend.Untracked(path)
+}
+
+// GitUserName sets the user name for commits in local git config
+func GitUserName(path, userName string) error {
+	return GitLocalConfig(path, "user.name", userName)
+}
+
+// GitVerifyCommit checks the signature of the commit with git verify-commit. The error holds the output of git when
+// the commit isn't signed, or its signature isn't valid or trusted. If env is given, git runs with it as its
+// environment (ex: with GNUPGHOME set to the keyring that signatures are trusted from).
+func GitVerifyCommit(path, commit string, env ...string) error {
+	return gitVerify(path, "verify-commit", commit, env)
+}
+
+// GitVerifyTag checks the signature of the annotated tag with git verify-tag, like GitVerifyCommit
+func GitVerifyTag(path, name string, env ...string) error {
+	return gitVerify(path, "verify-tag", name, env)
+}
+
+// gitVerify checks the signature of the object with the git verify command, in the environment env if it's given
+func gitVerify(path, verify, object string, env []string) error {
 	git, exists := Which("git")
 	if !exists {
-		return untracked, fmt.Errorf("Couldn't find git command")
+		return fmt.Errorf("Couldn't find git command")
 	}
 
-	cmd := exec.Command(git, "status", "--porcelain=v2")
+	cmd := exec.Command(git, verify, object)
 	cmd.Dir = path
-	output, err := cmd.Output()
-	if err != nil {
-		return untracked, fmt.Errorf("%s\n%s", output, err)
+	if len(env) > 0 {
+		cmd.Env = env
 	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	for scanner.Scan() {
-		parts := strings.SplitN(scanner.Text(), " ", 2)
-		if parts[0] == "?" {
-			// This is an untracked file
-			untracked = append(untracked, parts[1])
-		}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", strings.TrimSpace(string(output)), err)
 	}
 
-	return untracked, nil
+	return nil
 }
-
-// GitUserName sets the user name for commits in local git config
-func GitUserName(path, userName string) error {
-	return GitLocalConfig(path, "user.name", userName)
-}
\ No newline at end of file
@@ -0,0 +1,754 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExecBackend is the GitBackend that runs the git command (and tar, for archives)
+type ExecBackend struct{}
+
+// Add stages the named file for commit
+func (b *ExecBackend) Add(path, name string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "add", name)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// AddGitlink records a submodule at name pointing at the commit in the index, and creates its empty directory
+func (b *ExecBackend) AddGitlink(path, name, commit string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	err := os.MkdirAll(filepath.Join(path, name), 0755)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(git, "update-index", "--add", "--cacheinfo", fmt.Sprintf("160000,%s,%s", commit, name))
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Archive takes a copy of files from src tree (branch, commit, etc) and outputs them to dst
+func (b *ExecBackend) Archive(src, tree, dst string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+	tar, exists := Which("tar")
+	if !exists {
+		return fmt.Errorf("Couldn't find tar command")
+	}
+
+	// Issue git archive on the src, and pipe the output to tar at the dst
+	archive := exec.Command(git, "archive", "--format=tar", tree)
+	archive.Dir = src
+	archive.Env = lfsPointerEnv()
+
+	out, err := archive.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	untar := exec.Command(tar, "-C", dst, "-xvf", "-")
+	untar.Stdin = out
+
+	err = archive.Start()
+	if err != nil {
+		return err
+	}
+	err = untar.Start()
+	if err != nil {
+		return err
+	}
+
+	err = archive.Wait()
+	if err != nil {
+		return err
+	}
+	err = untar.Wait()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Authors returns the authors of the commits reachable from to but not from, as "name <email>", sorted and without
+// duplicates. If from is empty, only the author of the to commit is returned.
+func (b *ExecBackend) Authors(path, from, to string) ([]string, error) {
+	authors := make([]string, 0)
+	git, exists := Which("git")
+	if !exists {
+		return authors, fmt.Errorf("Couldn't find git command")
+	}
+
+	args := []string{"log", "--format=%an <%ae>"}
+	if len(from) > 0 {
+		args = append(args, fmt.Sprintf("%s..%s", from, to))
+	} else {
+		args = append(args, "-1", to)
+	}
+
+	cmd := exec.Command(git, args...)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return authors, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		authors = append(authors, scanner.Text())
+	}
+
+	return uniqueSorted(authors), nil
+}
+
+// Branches returns the names of branches on the remote, as known by the repository at path
+func (b *ExecBackend) Branches(path, remote string) ([]string, error) {
+	branches := make([]string, 0)
+	git, exists := Which("git")
+	if !exists {
+		return branches, fmt.Errorf("Couldn't find git command")
+	}
+
+	prefix := fmt.Sprintf("refs/remotes/%s/", remote)
+	cmd := exec.Command(git, "for-each-ref", "--format=%(refname)", prefix)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return branches, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		name := strings.TrimPrefix(scanner.Text(), prefix)
+		if name == "HEAD" {
+			// This is the remote's symbolic ref to its default branch
+			continue
+		}
+
+		branches = append(branches, name)
+	}
+
+	return branches, nil
+}
+
+// Checkout switches the checkout to the given tree
+func (b *ExecBackend) Checkout(path, tree string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "checkout", tree)
+	cmd.Dir = path
+	cmd.Env = lfsPointerEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// CheckoutNew creates a new branch from the start tree, and switches the checkout to it
+func (b *ExecBackend) CheckoutNew(path, branch, start string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "checkout", "-b", branch, start)
+	cmd.Dir = path
+	cmd.Env = lfsPointerEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Clone clones the repository at url to dst, with the credentials of auth.
+// The credentials are kept in the local git config of the clone, so that they're used for fetching and pushing too.
+func (b *ExecBackend) Clone(url, dst string, auth *GitAuth) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	config, err := authConfig(auth)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"clone", "--quiet"}
+	for _, c := range config {
+		args = append(args, "--config", c)
+	}
+	args = append(args, url, dst)
+
+	cmd := exec.Command(git, args...)
+	cmd.Env = withSSHCommand(lfsPointerEnv(), auth)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Commit commits all tracked files to the repository
+func (b *ExecBackend) Commit(path, msg string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "commit", "-a", "-m", msg)
+	cmd.Dir = path
+	// A commit with nothing to commit is told apart by git's message, which is translated unless the locale is C
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "nothing to commit") {
+			fmt.Print(string(output))
+			return nil
+		}
+
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// FetchAll fetches all remote branches
+func (b *ExecBackend) FetchAll(path string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "fetch", "--all")
+	cmd.Dir = path
+	cmd.Env = sshEnv(git, path, os.Environ())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Gitlinks returns the commit of each submodule in the tree, by path
+func (b *ExecBackend) Gitlinks(path, tree string) (map[string]string, error) {
+	links := make(map[string]string)
+	git, exists := Which("git")
+	if !exists {
+		return links, fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "ls-tree", "-r", "-z", tree)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return links, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	// Each entry is "<mode> <type> <object>\t<path>"
+	for _, entry := range bytes.Split(output, []byte{0}) {
+		tab := bytes.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		fields := strings.Fields(string(entry[:tab]))
+		if len(fields) == 3 && fields[1] == "commit" {
+			links[string(entry[tab+1:])] = fields[2]
+		}
+	}
+
+	return links, nil
+}
+
+// LastTrailer returns the most recent commit reachable from tree with a trailer with the key in its message,
+// the value of that trailer, and a boolean of if such a commit was found.
+func (b *ExecBackend) LastTrailer(path, tree, key string) (string, string, bool, error) {
+	git, exists := Which("git")
+	if !exists {
+		return "", "", false, fmt.Errorf("Couldn't find git command")
+	}
+
+	format := fmt.Sprintf("--format=%%H%%x00%%(trailers:key=%s,valueonly,separator=%%x00)", key)
+	grep := fmt.Sprintf("--grep=^%s: ", key)
+	cmd := exec.Command(git, "log", "-1", format, grep, tree)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", false, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(output)), "\x00")
+	if len(parts) < 2 || len(parts[1]) == 0 {
+		return "", "", false, nil
+	}
+
+	return parts[0], strings.TrimSpace(parts[1]), true, nil
+}
+
+// LocalConfig sets local git config setting
+func (b *ExecBackend) LocalConfig(path, setting, value string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "config", "--local", setting, value)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// LogOneline returns the abbreviated id and subject of the commits reachable from to but not from, newest first.
+// If from is empty, only the to commit is returned.
+func (b *ExecBackend) LogOneline(path, from, to string) ([]string, error) {
+	commits := make([]string, 0)
+	git, exists := Which("git")
+	if !exists {
+		return commits, fmt.Errorf("Couldn't find git command")
+	}
+
+	args := []string{"log", "--oneline", "--no-decorate"}
+	if len(from) > 0 {
+		args = append(args, fmt.Sprintf("%s..%s", from, to))
+	} else {
+		args = append(args, "-1", to)
+	}
+
+	cmd := exec.Command(git, args...)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return commits, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		commits = append(commits, scanner.Text())
+	}
+
+	return commits, nil
+}
+
+// LsRemote lists the branches of the remote at url with the credentials of auth, to check that it can be connected to.
+// Git won't prompt for credentials.
+func (b *ExecBackend) LsRemote(url string, auth *GitAuth) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	config, err := authConfig(auth)
+	if err != nil {
+		return err
+	}
+
+	args := make([]string, 0)
+	for _, c := range config {
+		args = append(args, "-c", c)
+	}
+	args = append(args, "ls-remote", "--heads", url)
+
+	cmd := exec.Command(git, args...)
+	cmd.Env = withSSHCommand(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), auth)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Mv moves the file from to to (both relative to path), in the working tree, and in the index if it's tracked.
+// A file at to is replaced.
+func (b *ExecBackend) Mv(path, from, to string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	// git mv doesn't create the directories of the destination
+	err := os.MkdirAll(filepath.Dir(filepath.Join(path, to)), 0755)
+	if err != nil {
+		return err
+	}
+
+	tracked := exec.Command(git, "ls-files", "--error-unmatch", "--", from)
+	tracked.Dir = path
+	if tracked.Run() != nil {
+		return os.Rename(filepath.Join(path, from), filepath.Join(path, to))
+	}
+
+	cmd := exec.Command(git, "mv", "--force", "--", from, to)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// Push pushes commits to the default remote and tree
+func (b *ExecBackend) Push(path, remote, tree string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "push", remote, tree)
+	cmd.Dir = path
+	cmd.Env = sshEnv(git, path, os.Environ())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	} else {
+		fmt.Print(string(output))
+	}
+
+	return nil
+}
+
+// Reset discards changes to tracked files and removes untracked files, in the checkout at path
+func (b *ExecBackend) Reset(path string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	for _, args := range [][]string{{"reset", "--hard", "--quiet"}, {"clean", "-d", "--force", "--quiet"}} {
+		cmd := exec.Command(git, args...)
+		cmd.Dir = path
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
+	}
+
+	return nil
+}
+
+// RevList returns the commits reachable from to but not from, oldest first, leaving out merge commits unless merges
+// is true
+func (b *ExecBackend) RevList(path, from, to string, merges bool) ([]string, error) {
+	commits := make([]string, 0)
+	git, exists := Which("git")
+	if !exists {
+		return commits, fmt.Errorf("Couldn't find git command")
+	}
+
+	args := []string{"rev-list", "--reverse"}
+	if !merges {
+		args = append(args, "--no-merges")
+	}
+	args = append(args, fmt.Sprintf("%s..%s", from, to))
+	cmd := exec.Command(git, args...)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return commits, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		commits = append(commits, scanner.Text())
+	}
+
+	return commits, nil
+}
+
+// RevParse returns the commit id that rev refers to, and a boolean of if rev exists in the repository at path
+func (b *ExecBackend) RevParse(path, rev string) (string, bool, error) {
+	git, exists := Which("git")
+	if !exists {
+		return "", false, fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "rev-parse", "--quiet", "--verify", fmt.Sprintf("%s^{commit}", rev))
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// rev-parse --verify exits non-zero when the rev doesn't exist
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	return strings.TrimSpace(string(output)), true, nil
+}
+
+// Rm removes the named file or directory from the working tree and the index
+func (b *ExecBackend) Rm(path, name string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	// Forced, because removing a submodule stages changes to .gitmodules, which would keep it from being removed later
+	cmd := exec.Command(git, "rm", "-r", "--force", name)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", string(output), err)
+	}
+
+	return nil
+}
+
+// Snapshot copies the files of the src tree (branch, commit, etc) to dst as they're committed, keeping their modes and
+// symlinks. Unlike Archive, the export-ignore and export-subst attributes aren't applied.
+func (b *ExecBackend) Snapshot(src, tree, dst string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	// The tree is read into a temporary index and checked out from it, leaving the index of the repo as it is
+	index, err := ioutil.TempFile("", "sync_priv_pub-index-")
+	if err != nil {
+		return err
+	}
+	_ = index.Close()
+	defer os.Remove(index.Name())
+
+	prefix, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+
+	env := append(lfsPointerEnv(), "GIT_INDEX_FILE="+index.Name())
+	for _, args := range [][]string{{"read-tree", tree}, {"checkout-index", "--all", "--force", "--prefix=" + prefix + "/"}} {
+		cmd := exec.Command(git, args...)
+		cmd.Dir = src
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
+	}
+
+	// Submodules are copied as empty directories, as Archive does
+	links, err := b.Gitlinks(src, tree)
+	if err != nil {
+		return err
+	}
+	for name := range links {
+		err = os.MkdirAll(filepath.Join(dst, filepath.FromSlash(name)), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tag creates an annotated tag with the message, pointing at the tree
+func (b *ExecBackend) Tag(path, name, tree, msg string) error {
+	git, exists := Which("git")
+	if !exists {
+		return fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "tag", "--annotate", "--message", msg, name, tree)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", output, err)
+	}
+
+	return nil
+}
+
+// TagMessage returns the message of an annotated tag, or an empty string for a lightweight tag
+func (b *ExecBackend) TagMessage(path, name string) (string, error) {
+	git, exists := Which("git")
+	if !exists {
+		return "", fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "for-each-ref", "--format=%(objecttype)%00%(contents)", fmt.Sprintf("refs/tags/%s", name))
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s", output, err)
+	}
+
+	// The contents of a lightweight tag are those of the commit it points to
+	parts := strings.SplitN(string(output), "\x00", 2)
+	if len(parts) != 2 || parts[0] != "tag" {
+		return "", nil
+	}
+
+	return strings.TrimSpace(parts[1]), nil
+}
+
+// TagsReachable returns the commit that each tag reachable from the tree points at, by tag name
+func (b *ExecBackend) TagsReachable(path, tree string) (map[string]string, error) {
+	tags := make(map[string]string)
+	git, exists := Which("git")
+	if !exists {
+		return tags, fmt.Errorf("Couldn't find git command")
+	}
+
+	// Annotated tags are peeled to the commit they point at
+	format := "--format=%(refname:strip=2)%00%(objectname)%00%(*objectname)"
+	cmd := exec.Command(git, "for-each-ref", "--merged="+tree, format, "refs/tags")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return tags, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\x00")
+		if len(parts) != 3 {
+			continue
+		}
+
+		tags[parts[0]] = parts[1]
+		if len(parts[2]) > 0 {
+			tags[parts[0]] = parts[2]
+		}
+	}
+
+	return tags, nil
+}
+
+// Trailers returns the most recent commit reachable from tree with a trailer with the key in its message for each
+// value of the trailer, by value
+func (b *ExecBackend) Trailers(path, tree, key string) (map[string]string, error) {
+	commits := make(map[string]string)
+	git, exists := Which("git")
+	if !exists {
+		return commits, fmt.Errorf("Couldn't find git command")
+	}
+
+	// Each commit ends with a byte that messages don't have, since trailers are printed on several lines
+	format := fmt.Sprintf("--format=%%H%%x00%%(trailers:key=%s,valueonly,separator=%%x00)%%x01", key)
+	grep := fmt.Sprintf("--grep=^%s: ", key)
+	cmd := exec.Command(git, "log", format, grep, tree)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return commits, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	for _, record := range strings.Split(string(output), "\x01") {
+		parts := strings.Split(strings.TrimSpace(record), "\x00")
+		if len(parts) < 2 || len(parts[1]) == 0 {
+			continue
+		}
+
+		// Commits are listed newest first
+		value := strings.TrimSpace(parts[1])
+		if _, found := commits[value]; !found {
+			commits[value] = parts[0]
+		}
+	}
+
+	return commits, nil
+}
+
+// Untracked returns a list of untracked files in the git repository at path
+func (b *ExecBackend) Untracked(path string) ([]string, error) {
+	untracked := make([]string, 0)
+	git, exists := Which("git")
+	if !exists {
+		return untracked, fmt.Errorf("Couldn't find git command")
+	}
+
+	cmd := exec.Command(git, "status", "--porcelain=v2")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return untracked, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if parts[0] == "?" {
+			// This is an untracked file
+			untracked = append(untracked, parts[1])
+		}
+	}
+
+	return untracked, nil
+}
+
+// lfsPointerEnv returns the environment for git commands that check out or archive files, so that Git LFS files are
+// kept as pointers when git-lfs is installed, rather than being downloaded
+func lfsPointerEnv() []string {
+	return append(os.Environ(), "GIT_LFS_SKIP_SMUDGE=1")
+}
+
+// withSSHCommand returns env with GIT_SSH_COMMAND set to the ssh command of auth, if it has one, so that it's used even
+// when GIT_SSH_COMMAND is set in the environment of this process
+func withSSHCommand(env []string, auth *GitAuth) []string {
+	if auth == nil {
+		return env
+	}
+
+	if ssh := auth.sshCommand(); len(ssh) > 0 {
+		return append(env, "GIT_SSH_COMMAND="+ssh)
+	}
+
+	return env
+}
+
+// sshEnv returns env with GIT_SSH_COMMAND set to the ssh command kept in the local git config of the clone at path
+// (see authConfig), if it has one, for git commands that connect to its remotes
+func sshEnv(git, path string, env []string) []string {
+	cmd := exec.Command(git, "config", "--local", "--get", sshCommandConfig)
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		// The setting doesn't exist
+		return env
+	}
+
+	return append(env, "GIT_SSH_COMMAND="+strings.TrimSpace(string(output)))
+}
@@ -0,0 +1,388 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRemote creates a bare repo with go-git alone, for the GoGitBackend to clone and push to, and returns its path
+// and the ids of its commits by name. Its master branch has two commits, and its topic branch starts from the first
+// one, so that it can't be fast-forwarded to master.
+func testRemote(t *testing.T) (string, map[string]string) {
+	seed := t.TempDir()
+	r, err := git.PlainInit(seed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits := make(map[string]string)
+	step := 0
+	commit := func(name string, files map[string]string) {
+		for f, content := range files {
+			err := ioutil.WriteFile(filepath.Join(seed, f), []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = w.Add(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		step++
+		when := time.Date(2020, 1, 1, 0, step, 0, 0, time.UTC)
+		sig := &object.Signature{Name: "Ann", Email: "ann@example.org", When: when}
+		hash, err := w.Commit(name, &git.CommitOptions{Author: sig, Committer: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits[name] = hash.String()
+	}
+	checkout := func(branch string, create bool) {
+		err := w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit("first", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	checkout("topic", true)
+	commit("topic", map[string]string{"topic.txt": "topic\n"})
+	checkout("master", false)
+	commit("second", map[string]string{"a.txt": "a\na\n"})
+
+	bare := t.TempDir()
+	_, err = git.PlainClone(bare, true, &git.CloneOptions{URL: filepath.Join(seed, ".git")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bare clone only has master as a local branch
+	remote, err := git.PlainOpen(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("topic"),
+		plumbing.NewHash(commits["topic"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bare, commits
+}
+
+// headTree returns the paths of the files committed at the HEAD of the repo at path, sorted
+func headTree(t *testing.T, path string) []string {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := commit.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make([]string, 0)
+	err = files.ForEach(func(f *object.File) error {
+		paths = append(paths, f.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// remoteRef returns the commit that the ref points at in the repo at path, or an empty string if it doesn't exist
+func remoteRef(t *testing.T, path, ref string) string {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := r.Reference(plumbing.ReferenceName(ref), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resolved.Hash().String()
+}
+
+func TestGoGitBackendCloneCheckout(t *testing.T) {
+	remote, commits := testRemote(t)
+	b := NewGoGitBackend()
+	dst := filepath.Join(t.TempDir(), "clone")
+	err := b.Clone(remote, dst, nil)
+	if err != nil {
+		t.Fatalf("Clone failed: %s", err)
+	}
+
+	// The default branch of the remote is checked out
+	head, _, err := b.RevParse(dst, "HEAD")
+	if err != nil || head != commits["second"] {
+		t.Errorf("HEAD of the clone is %s, %v, want %s", head, err, commits["second"])
+	}
+
+	branches, err := b.Branches(dst, defaultRemote)
+	sort.Strings(branches)
+	if err != nil || !reflect.DeepEqual(branches, []string{"master", "topic"}) {
+		t.Errorf("Branches of the clone are %q, %v", branches, err)
+	}
+
+	// A remote branch is checked out as a local branch tracking it
+	err = b.Checkout(dst, "topic")
+	if err != nil {
+		t.Fatalf("Checkout of a remote branch failed: %s", err)
+	}
+	if got := headTree(t, dst); !reflect.DeepEqual(got, []string{"a.txt", "b.txt", "topic.txt"}) {
+		t.Errorf("Files of topic are %q", got)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "a.txt"))
+	if err != nil || string(content) != "a\n" {
+		t.Errorf("a.txt of topic has %q, %v", content, err)
+	}
+
+	err = b.Checkout(dst, "master")
+	if err != nil {
+		t.Fatalf("Checkout of a local branch failed: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "topic.txt")); !os.IsNotExist(err) {
+		t.Errorf("topic.txt is left in the checkout of master")
+	}
+
+	err = b.CheckoutNew(dst, "feature", commits["first"])
+	if err != nil {
+		t.Fatalf("CheckoutNew failed: %s", err)
+	}
+	head, _, err = b.RevParse(dst, "feature")
+	if err != nil || head != commits["first"] {
+		t.Errorf("New branch is at %s, %v, want %s", head, err, commits["first"])
+	}
+}
+
+func TestGoGitBackendCommit(t *testing.T) {
+	remote, commits := testRemote(t)
+	b := NewGoGitBackend()
+	dst := filepath.Join(t.TempDir(), "clone")
+	err := b.Clone(remote, dst, nil)
+	if err != nil {
+		t.Fatalf("Clone failed: %s", err)
+	}
+	for _, setting := range [][]string{{"user.name", "Bob"}, {"user.email", "bob@example.org"}} {
+		err = b.LocalConfig(dst, setting[0], setting[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to commit isn't an error
+	err = b.Commit(dst, "Nothing")
+	if err != nil {
+		t.Errorf("Commit without changes failed: %s", err)
+	}
+	head, _, _ := b.RevParse(dst, "HEAD")
+	if head != commits["second"] {
+		t.Errorf("Commit without changes made commit %s", head)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dst, "c.txt"), []byte("c\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untracked, err := b.Untracked(dst)
+	if err != nil || !reflect.DeepEqual(untracked, []string{"c.txt"}) {
+		t.Errorf("Untracked returned %q, %v", untracked, err)
+	}
+
+	err = b.Add(dst, "c.txt")
+	if err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	err = b.Rm(dst, "b.txt")
+	if err != nil {
+		t.Fatalf("Rm failed: %s", err)
+	}
+	err = b.Mv(dst, "a.txt", "dir/moved.txt")
+	if err != nil {
+		t.Fatalf("Mv failed: %s", err)
+	}
+	err = b.Commit(dst, "Change files")
+	if err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+
+	if got := headTree(t, dst); !reflect.DeepEqual(got, []string{"c.txt", "dir/moved.txt"}) {
+		t.Errorf("Files of the commit are %q", got)
+	}
+	authors, err := b.Authors(dst, "", "HEAD")
+	if err != nil || !reflect.DeepEqual(authors, []string{"Bob <bob@example.org>"}) {
+		t.Errorf("Author of the commit is %q, %v", authors, err)
+	}
+	log, err := b.LogOneline(dst, commits["second"], "HEAD")
+	if err != nil || len(log) != 1 || log[0][abbrevLen+1:] != "Change files" {
+		t.Errorf("Log of the commit is %q, %v", log, err)
+	}
+
+	// Changes to tracked files are committed without adding them
+	err = ioutil.WriteFile(filepath.Join(dst, "c.txt"), []byte("c\nc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Commit(dst, "Change c")
+	if err != nil {
+		t.Fatalf("Commit of a changed file failed: %s", err)
+	}
+	log, err = b.LogOneline(dst, commits["second"], "HEAD")
+	if err != nil || len(log) != 2 {
+		t.Errorf("Log after committing a changed file is %q, %v", log, err)
+	}
+
+	// Reset discards changes and untracked files
+	err = ioutil.WriteFile(filepath.Join(dst, "c.txt"), []byte("changed\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dst, "d.txt"), []byte("d\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Reset(dst)
+	if err != nil {
+		t.Fatalf("Reset failed: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "c.txt"))
+	if err != nil || string(content) != "c\nc\n" {
+		t.Errorf("c.txt after Reset has %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "d.txt")); !os.IsNotExist(err) {
+		t.Errorf("Untracked d.txt is left after Reset")
+	}
+}
+
+func TestGoGitBackendPushTag(t *testing.T) {
+	remote, commits := testRemote(t)
+	b := NewGoGitBackend()
+	dst := filepath.Join(t.TempDir(), "clone")
+	err := b.Clone(remote, dst, nil)
+	if err != nil {
+		t.Fatalf("Clone failed: %s", err)
+	}
+	for _, setting := range [][]string{{"user.name", "Bob"}, {"user.email", "bob@example.org"}} {
+		err = b.LocalConfig(dst, setting[0], setting[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dst, "c.txt"), []byte("c\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Add(dst, "c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Commit(dst, "Add c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _, _ := b.RevParse(dst, "HEAD")
+
+	err = b.Tag(dst, "v1", "HEAD", "Release v1")
+	if err != nil {
+		t.Fatalf("Tag failed: %s", err)
+	}
+	msg, err := b.TagMessage(dst, "v1")
+	if err != nil || msg != "Release v1" {
+		t.Errorf("TagMessage returned %q, %v", msg, err)
+	}
+	tags, err := b.TagsReachable(dst, head)
+	if err != nil || !reflect.DeepEqual(tags, map[string]string{"v1": head}) {
+		t.Errorf("TagsReachable returned %q, %v", tags, err)
+	}
+
+	err = b.Push(dst, defaultRemote, "master")
+	if err != nil {
+		t.Fatalf("Push of a branch failed: %s", err)
+	}
+	if got := remoteRef(t, remote, "refs/heads/master"); got != head {
+		t.Errorf("master of the remote is %s after Push, want %s", got, head)
+	}
+
+	err = b.Push(dst, defaultRemote, "refs/tags/v1")
+	if err != nil {
+		t.Fatalf("Push of a tag failed: %s", err)
+	}
+	if got := remoteRef(t, remote, "refs/tags/v1"); len(got) == 0 {
+		t.Errorf("v1 isn't in the remote after Push")
+	}
+
+	err = b.Push(dst, defaultRemote, "HEAD:refs/heads/sync/master")
+	if err != nil {
+		t.Fatalf("Push of HEAD to a new branch failed: %s", err)
+	}
+	if got := remoteRef(t, remote, "refs/heads/sync/master"); got != head {
+		t.Errorf("sync/master of the remote is %s after Push, want %s", got, head)
+	}
+
+	// topic can't be fast-forwarded to the new branch unless it's forced
+	err = b.Push(dst, defaultRemote, "HEAD:refs/heads/topic")
+	if err == nil {
+		t.Errorf("Push of a commit that isn't a fast-forward succeeded")
+	}
+	if got := remoteRef(t, remote, "refs/heads/topic"); got != commits["topic"] {
+		t.Errorf("topic of the remote is %s after a rejected Push, want %s", got, commits["topic"])
+	}
+
+	err = b.Push(dst, defaultRemote, "+HEAD:refs/heads/topic")
+	if err != nil {
+		t.Fatalf("Forced Push failed: %s", err)
+	}
+	if got := remoteRef(t, remote, "refs/heads/topic"); got != head {
+		t.Errorf("topic of the remote is %s after a forced Push, want %s", got, head)
+	}
+
+	// The remote has what was pushed, as a new clone shows
+	again := filepath.Join(t.TempDir(), "again")
+	err = b.Clone(remote, again, nil)
+	if err != nil {
+		t.Fatalf("Clone of the pushed remote failed: %s", err)
+	}
+	if got := headTree(t, again); !reflect.DeepEqual(got, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Errorf("Files of the pushed master are %q", got)
+	}
+
+	r, err := git.PlainOpen(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := r.Config()
+	if err != nil || !reflect.DeepEqual(cfg.Remotes[defaultRemote].URLs, []string{remote}) {
+		t.Errorf("Remote of the clone is %v, %v", cfg.Remotes[defaultRemote], err)
+	}
+}
@@ -2,9 +2,63 @@ package tools
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
 )
 
+// GoModule is a module version, as listed in go.mod files
+type GoModule struct {
+	Path    string
+	Version string `json:",omitempty"`
+}
+
+// GoModFile is the content of a go.mod file that's relevant to syncs
+type GoModFile struct {
+	Module  GoModule
+	Require []GoModule
+}
+
+// FindGoMods returns the directories under root (relative, with / separators) that have a go.mod file, with "." for
+// root itself. Directories that the go command ignores (vendor, testdata, and names starting with . or _) are skipped,
+// and so are the exclude paths.
+func FindGoMods(root string, exclude ...string) ([]string, error) {
+	return findGoDirs(root, "go.mod", exclude)
+}
+
+// FindGoWorks returns the directories under root that have a go.work file, like FindGoMods
+func FindGoWorks(root string, exclude ...string) ([]string, error) {
+	return findGoDirs(root, "go.work", exclude)
+}
+
+// GoReadMod returns the content of a go.mod file. It's parsed without the go command, so that the file can be read
+// wherever the tree is staged.
+func GoReadMod(modFile string) (*GoModFile, error) {
+	data, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := modfile.Parse(modFile, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mod GoModFile
+	if f.Module != nil {
+		mod.Module = GoModule{Path: f.Module.Mod.Path, Version: f.Module.Mod.Version}
+	}
+	for _, req := range f.Require {
+		mod.Require = append(mod.Require, GoModule{Path: req.Mod.Path, Version: req.Mod.Version})
+	}
+
+	return &mod, nil
+}
+
 // GoDropMod removes the module name from a go.mod file
 func GoDropMod(modFile, name string, env []string) error {
 	goCmd, exists := Which("go")
@@ -40,7 +94,6 @@ func GoGetMod(path, name string, env []string) error {
 	return nil
 }
 
-
 // GoSetMod sets the module name of a go.mod file
 func GoSetMod(modFile, name string, env []string) error {
 	goCmd, exists := Which("go")
@@ -75,3 +128,47 @@ func GoTidyMod(path string, env []string) error {
 
 	return nil
 }
+
+// findGoDirs returns the directories under root (relative, with / separators) that have a file with the name, skipping
+// the ones that the go command ignores and the exclude paths
+func findGoDirs(root, name string, exclude []string) ([]string, error) {
+	dirs := make([]string, 0)
+	finder := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." {
+			dir := info.Name()
+			if dir == "vendor" || dir == "testdata" || strings.HasPrefix(dir, ".") || strings.HasPrefix(dir, "_") {
+				return filepath.SkipDir
+			}
+
+			for _, x := range exclude {
+				if IsUnder(rel, x) {
+					return filepath.SkipDir
+				}
+			}
+		}
+
+		s, err := os.Stat(filepath.Join(n, name))
+		if err == nil && !s.IsDir() {
+			dirs = append(dirs, rel)
+		}
+
+		return nil
+	}
+
+	err := filepath.Walk(root, finder)
+	return dirs, err
+}
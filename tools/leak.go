@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Leak is text that must not be published, found by a LeakScanner
+type Leak struct {
+	// Where the text was found (ex: the relative path of a file, or "commit message")
+	Where string
+	// Line of the text, starting at 1, or 0 for file paths
+	Line int
+	// The text matched
+	Match string
+}
+
+// String returns where the leak is, and the text matched
+func (l Leak) String() string {
+	if l.Line == 0 {
+		return fmt.Sprintf("%s: %q", l.Where, l.Match)
+	}
+
+	return fmt.Sprintf("%s:%d: %q", l.Where, l.Line, l.Match)
+}
+
+// LeakScanner finds text that must not be published (ex: internal urls or ticket ids) with regular expressions, in file
+// paths and contents as well as in messages
+type LeakScanner struct {
+	patterns []*regexp.Regexp
+}
+
+// NewLeakScanner returns a scanner for the regular expressions, or nil if there are none
+func NewLeakScanner(patterns []string) (*LeakScanner, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	s := &LeakScanner{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid leak pattern %q: %s", p, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+
+	return s, nil
+}
+
+// ScanText returns the leaks in the lines of the text, found at where. A nil scanner finds none.
+func (s *LeakScanner) ScanText(where, text string) []Leak {
+	leaks := make([]Leak, 0)
+	if s == nil {
+		return leaks
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		for _, re := range s.patterns {
+			for _, m := range re.FindAllString(line, -1) {
+				leaks = append(leaks, Leak{Where: where, Line: i + 1, Match: m})
+			}
+		}
+	}
+
+	return leaks
+}
+
+// ScanTree returns the leaks in the paths (relative, with / separators) and contents of the files under root, and in
+// the targets of its symlinks. Files under the exclude paths are skipped. A nil scanner finds none.
+func (s *LeakScanner) ScanTree(root string, exclude ...string) ([]Leak, error) {
+	leaks := make([]Leak, 0)
+	if s == nil {
+		return leaks, nil
+	}
+
+	scanner := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		for _, x := range exclude {
+			if IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		for _, re := range s.patterns {
+			for _, m := range re.FindAllString(rel, -1) {
+				leaks = append(leaks, Leak{Where: rel, Match: m})
+			}
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(n)
+			if err != nil {
+				return err
+			}
+			leaks = append(leaks, s.ScanText(rel, target)...)
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(n)
+			if err != nil {
+				return err
+			}
+			leaks = append(leaks, s.ScanText(rel, string(data))...)
+		}
+
+		return nil
+	}
+
+	err := filepath.Walk(root, scanner)
+	return leaks, err
+}
@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLeakScanner(t *testing.T) {
+	s, err := NewLeakScanner([]string{`corp\.example\.com`, `PRIV-\d+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaks := s.ScanText("commit message", "Fix PRIV-12\n\nSee https://corp.example.com/PRIV-12")
+	want := []Leak{
+		{Where: "commit message", Line: 1, Match: "PRIV-12"},
+		{Where: "commit message", Line: 3, Match: "corp.example.com"},
+		{Where: "commit message", Line: 3, Match: "PRIV-12"},
+	}
+	if !reflect.DeepEqual(leaks, want) {
+		t.Errorf("ScanText returned %v, want %v", leaks, want)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"clean.txt":              "nothing to see",
+		"docs/PRIV-7.md":         "notes",
+		"config.yml":             "url: corp.example.com\n",
+		".git/config":            "url = corp.example.com",
+		"docs/nested/readme.txt": "clean",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(p, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink("https://corp.example.com/", filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths, contents and symlink targets are scanned, except under the excluded paths
+	leaks, err = s.ScanTree(dir, ".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Leak{
+		{Where: "config.yml", Line: 1, Match: "corp.example.com"},
+		{Where: "docs/PRIV-7.md", Match: "PRIV-7"},
+		{Where: "link", Line: 1, Match: "corp.example.com"},
+	}
+	if !reflect.DeepEqual(leaks, want) {
+		t.Errorf("ScanTree returned %v, want %v", leaks, want)
+	}
+
+	_, err = NewLeakScanner([]string{"("})
+	if err == nil {
+		t.Errorf("NewLeakScanner accepted an invalid pattern")
+	}
+
+	var none *LeakScanner
+	if leaks := none.ScanText("message", "PRIV-1"); len(leaks) > 0 {
+		t.Errorf("Scanner without patterns found %v", leaks)
+	}
+}
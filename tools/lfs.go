@@ -0,0 +1,343 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	// First line of Git LFS pointer files
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// Pointer files are smaller than this many bytes
+	lfsPointerMaxSize = 1024
+	// Media type of the requests and responses of the Git LFS batch API
+	lfsMediaType = "application/vnd.git-lfs+json"
+	// Timeout of Git LFS batch and verify requests. Transfers of objects aren't limited.
+	lfsRequestTimeout = 30 * time.Second
+)
+
+// Object ids of Git LFS objects
+var lfsOid = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// LFSObject is a Git LFS object, as referred to by a pointer file
+type LFSObject struct {
+	// Hex sha256 hash of the content of the object
+	Oid string `json:"oid"`
+	// Size of the object in bytes
+	Size int64 `json:"size"`
+}
+
+// LFSStore is the Git LFS server of a repository
+type LFSStore struct {
+	// URL of the server (ex: https://github.com/user/repo_name.git/info/lfs)
+	URL string
+	// Credentials for the server (a token or a credentials file), or nil
+	Auth *GitAuth
+}
+
+// lfsAction is a request that a Git LFS server tells its clients to make, to transfer an object
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// lfsBatchObject is an object in a response of the batch API
+type lfsBatchObject struct {
+	LFSObject
+	Actions map[string]lfsAction `json:"actions,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// ParseLFSPointer returns the object that the data refers to, and a boolean of if the data is a Git LFS pointer file
+func ParseLFSPointer(data []byte) (LFSObject, bool) {
+	var obj LFSObject
+	if len(data) >= lfsPointerMaxSize || !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return obj, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			obj.Oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			obj.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	return obj, lfsOid.MatchString(obj.Oid) && obj.Size >= 0
+}
+
+// FindLFSObjects returns the objects that the Git LFS pointer files under root refer to, by the relative path of the
+// pointer file. Files under the exclude paths are skipped.
+func FindLFSObjects(root string, exclude ...string) (map[string]LFSObject, error) {
+	objects := make(map[string]LFSObject)
+	finder := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range exclude {
+			if IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !info.Mode().IsRegular() || info.Size() >= lfsPointerMaxSize {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+
+		obj, isPointer := ParseLFSPointer(data)
+		if isPointer {
+			objects[rel] = obj
+		}
+
+		return nil
+	}
+
+	err := filepath.Walk(root, finder)
+	return objects, err
+}
+
+// CopyLFSObjects copies the objects from one Git LFS server to another, with the basic transfer adapter of the batch
+// API. Objects that the destination already has aren't transferred. It returns the number of objects copied.
+func CopyLFSObjects(from, to LFSStore, objects []LFSObject) (int, error) {
+	if len(objects) == 0 {
+		return 0, nil
+	}
+
+	// Ask the destination which objects it needs first, so that only those are downloaded
+	uploads, err := to.batch("upload", objects)
+	if err != nil {
+		return 0, err
+	}
+
+	needed := make([]LFSObject, 0)
+	for _, obj := range uploads {
+		if _, upload := obj.Actions["upload"]; upload {
+			needed = append(needed, obj.LFSObject)
+		}
+	}
+	if len(needed) == 0 {
+		return 0, nil
+	}
+
+	downloads, err := from.batch("download", needed)
+	if err != nil {
+		return 0, err
+	}
+
+	sources := make(map[string]lfsAction)
+	for _, obj := range downloads {
+		sources[obj.Oid] = obj.Actions["download"]
+	}
+
+	copied := 0
+	for _, obj := range uploads {
+		upload, exists := obj.Actions["upload"]
+		if !exists {
+			continue
+		}
+
+		download, exists := sources[obj.Oid]
+		if !exists || len(download.Href) == 0 {
+			return copied, fmt.Errorf("Object %s isn't available from %s", obj.Oid, from.URL)
+		}
+
+		err = copyLFSObject(from, to, obj, download, upload)
+		if err != nil {
+			return copied, fmt.Errorf("Failed to copy object %s: %s", obj.Oid, err)
+		}
+
+		copied++
+	}
+
+	return copied, nil
+}
+
+// copyLFSObject downloads the object with the download action, checks its hash, and uploads it with the upload action.
+// The upload is verified with the verify action of the object, if the destination has one.
+func copyLFSObject(from, to LFSStore, obj lfsBatchObject, download, upload lfsAction) error {
+	tmp, err := ioutil.TempFile("", "sync_priv_pub-lfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	resp, err := from.do(http.MethodGet, download, nil, 0, &http.Client{})
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if size != obj.Size || fmt.Sprintf("%x", h.Sum(nil)) != obj.Oid {
+		return fmt.Errorf("Downloaded content doesn't match the object")
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	resp, err = to.do(http.MethodPut, upload, tmp, size, &http.Client{})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	verify, exists := obj.Actions["verify"]
+	if !exists {
+		return nil
+	}
+
+	body, err := json.Marshal(obj.LFSObject)
+	if err != nil {
+		return err
+	}
+
+	resp, err = to.do(http.MethodPost, verify, bytes.NewReader(body), int64(len(body)), &http.Client{Timeout: lfsRequestTimeout})
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// batch makes a request to the batch API of the server for the operation (upload or download) on the objects,
+// and returns the objects of the response
+func (s LFSStore) batch(operation string, objects []LFSObject) ([]lfsBatchObject, error) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Oid < objects[j].Oid
+	})
+
+	body, err := json.Marshal(map[string]interface{}{
+		"operation": operation,
+		"transfers": []string{"basic"},
+		"objects":   objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	action := lfsAction{Href: strings.TrimSuffix(s.URL, "/") + "/objects/batch"}
+	resp, err := s.do(http.MethodPost, action, bytes.NewReader(body), int64(len(body)), &http.Client{Timeout: lfsRequestTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid response from %s: %s", action.Href, err)
+	}
+
+	for _, obj := range out.Objects {
+		if obj.Error != nil {
+			return nil, fmt.Errorf("Can't %s object %s at %s: %s (%d)", operation, obj.Oid, s.URL, obj.Error.Message, obj.Error.Code)
+		}
+	}
+
+	return out.Objects, nil
+}
+
+// do makes the request of the action, with the credentials of the server unless the action has its own
+// Authorization header. The credentials are only sent to the scheme and host of the server, since actions may point
+// at other hosts (ex: object storage). It fails for responses other than 2xx.
+func (s LFSStore) do(method string, action lfsAction, body io.Reader, size int64, client *http.Client) (*http.Response, error) {
+	req, err := http.NewRequest(method, action.Href, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	switch method {
+	case http.MethodPut:
+		req.Header.Set("Content-Type", "application/octet-stream")
+	case http.MethodPost:
+		req.Header.Set("Content-Type", lfsMediaType)
+		req.Header.Set("Accept", lfsMediaType)
+	}
+
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+
+	if len(req.Header.Get("Authorization")) == 0 && s.sameOrigin(req.URL) {
+		auth, err := authMethod(s.URL, s.Auth)
+		if err != nil {
+			return nil, err
+		}
+
+		basic, ok := auth.(*githttp.BasicAuth)
+		if ok {
+			req.SetBasicAuth(basic.Username, basic.Password)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s %s returned %s\n%s", method, action.Href, resp.Status, msg)
+	}
+
+	return resp, nil
+}
+
+// sameOrigin returns true if the URL has the same scheme and host as the server
+func (s LFSStore) sameOrigin(u *url.URL) bool {
+	server, err := url.Parse(s.URL)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(server.Scheme, u.Scheme) && strings.EqualFold(server.Host, u.Host)
+}
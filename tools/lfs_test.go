@@ -0,0 +1,231 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Environment variable holding the token used in tests
+const testLFSTokenEnv = "SYNC_PRIV_PUB_TEST_LFS_TOKEN"
+
+// lfsRequest is a request received by a stand-in of a Git LFS server
+type lfsRequest struct {
+	method string
+	url    string
+	auth   string
+}
+
+// lfsServer is a stand-in of a Git LFS server, which serves the batch API and the basic transfers of its objects
+type lfsServer struct {
+	t *testing.T
+	// Server of the batch API, and the one that actions point at (the same one unless storage is separate)
+	api     *httptest.Server
+	storage *httptest.Server
+	// Content of the objects by oid, and content served instead of the stored one by oid
+	objects  map[string][]byte
+	tampered map[string][]byte
+	// Whether uploads have a verify action, and the oids verified
+	verify   bool
+	verified []string
+
+	mu       sync.Mutex
+	requests []lfsRequest
+}
+
+// newLFSServer returns a stand-in of a Git LFS server with the objects. With separateStorage, the actions point at
+// another host than the batch API.
+func newLFSServer(t *testing.T, separateStorage bool, contents ...string) *lfsServer {
+	s := &lfsServer{t: t, objects: make(map[string][]byte), tampered: make(map[string][]byte)}
+	for _, content := range contents {
+		s.objects[testLFSObject(content).Oid] = []byte(content)
+	}
+
+	s.api = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.api.Close)
+	s.storage = s.api
+	if separateStorage {
+		s.storage = httptest.NewServer(http.HandlerFunc(s.serve))
+		t.Cleanup(s.storage.Close)
+	}
+
+	return s
+}
+
+// testLFSObject returns the object with the content
+func testLFSObject(content string) LFSObject {
+	return LFSObject{Oid: fmt.Sprintf("%x", sha256.Sum256([]byte(content))), Size: int64(len(content))}
+}
+
+// store returns the server as a store, with a token for credentials
+func (s *lfsServer) store() LFSStore {
+	s.t.Setenv(testLFSTokenEnv, "secret")
+	return LFSStore{URL: s.api.URL + "/info/lfs", Auth: &GitAuth{TokenEnv: testLFSTokenEnv}}
+}
+
+// serve handles a request to the batch API, a transfer of an object or a verify request
+func (s *lfsServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host := "http://" + req.Host
+	s.requests = append(s.requests, lfsRequest{method: req.Method, url: host + req.URL.Path, auth: req.Header.Get("Authorization")})
+
+	oid := strings.TrimPrefix(req.URL.Path, "/objects/")
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/info/lfs/objects/batch":
+		var in struct {
+			Operation string      `json:"operation"`
+			Objects   []LFSObject `json:"objects"`
+		}
+		err := json.NewDecoder(req.Body).Decode(&in)
+		if err != nil {
+			s.t.Errorf("Invalid batch request: %s", err)
+		}
+
+		out := make([]lfsBatchObject, 0, len(in.Objects))
+		for _, obj := range in.Objects {
+			o := lfsBatchObject{LFSObject: obj, Actions: make(map[string]lfsAction)}
+			href := s.storage.URL + "/objects/" + obj.Oid
+			_, exists := s.objects[obj.Oid]
+			switch {
+			case in.Operation == "download" && exists:
+				o.Actions["download"] = lfsAction{Href: href}
+			case in.Operation == "upload" && !exists:
+				o.Actions["upload"] = lfsAction{Href: href}
+				if s.verify {
+					o.Actions["verify"] = lfsAction{Href: s.api.URL + "/info/lfs/verify"}
+				}
+			}
+			out = append(out, o)
+		}
+
+		w.Header().Set("Content-Type", lfsMediaType)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"objects": out})
+	case req.Method == http.MethodPost && req.URL.Path == "/info/lfs/verify":
+		var obj LFSObject
+		err := json.NewDecoder(req.Body).Decode(&obj)
+		if err != nil || len(s.objects[obj.Oid]) != int(obj.Size) {
+			http.Error(w, "not verified", http.StatusUnprocessableEntity)
+			return
+		}
+		s.verified = append(s.verified, obj.Oid)
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/objects/"):
+		content, exists := s.tampered[oid]
+		if !exists {
+			content, exists = s.objects[oid]
+		}
+		if !exists {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write(content)
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/objects/"):
+		content, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			s.t.Errorf("Failed to read upload: %s", err)
+		}
+		s.objects[oid] = content
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestCopyLFSObjects(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verify=%t", verify), func(t *testing.T) {
+			from := newLFSServer(t, false, "kept", "copied")
+			to := newLFSServer(t, false, "kept")
+			to.verify = verify
+
+			kept, copied := testLFSObject("kept"), testLFSObject("copied")
+			n, err := CopyLFSObjects(from.store(), to.store(), []LFSObject{kept, copied})
+			if err != nil {
+				t.Fatalf("CopyLFSObjects failed: %s", err)
+			}
+			if n != 1 {
+				t.Errorf("CopyLFSObjects copied %d objects, want 1", n)
+			}
+			if string(to.objects[copied.Oid]) != "copied" {
+				t.Errorf("Destination has %q for the copied object", to.objects[copied.Oid])
+			}
+
+			// The object that the destination already has isn't downloaded
+			for _, r := range from.requests {
+				if strings.HasSuffix(r.url, kept.Oid) {
+					t.Errorf("Object the destination has was requested: %s %s", r.method, r.url)
+				}
+			}
+
+			if verify && (len(to.verified) != 1 || to.verified[0] != copied.Oid) {
+				t.Errorf("Destination verified %q, want %s", to.verified, copied.Oid)
+			}
+			if !verify && len(to.verified) > 0 {
+				t.Errorf("Destination verified %q without a verify action", to.verified)
+			}
+		})
+	}
+}
+
+func TestCopyLFSObjectsNothingNeeded(t *testing.T) {
+	from := newLFSServer(t, false, "kept")
+	to := newLFSServer(t, false, "kept")
+
+	n, err := CopyLFSObjects(from.store(), to.store(), []LFSObject{testLFSObject("kept")})
+	if err != nil || n != 0 {
+		t.Fatalf("CopyLFSObjects returned %d, %v", n, err)
+	}
+	if len(from.requests) > 0 {
+		t.Errorf("Source got requests when the destination has every object: %v", from.requests)
+	}
+}
+
+func TestCopyLFSObjectsHashMismatch(t *testing.T) {
+	from := newLFSServer(t, false, "content")
+	to := newLFSServer(t, false)
+
+	obj := testLFSObject("content")
+	from.tampered[obj.Oid] = []byte("CONTENT")
+
+	_, err := CopyLFSObjects(from.store(), to.store(), []LFSObject{obj})
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("CopyLFSObjects didn't fail for content that doesn't match the object: %v", err)
+	}
+	if _, exists := to.objects[obj.Oid]; exists {
+		t.Errorf("Content that doesn't match the object was uploaded")
+	}
+}
+
+func TestCopyLFSObjectsAuth(t *testing.T) {
+	for _, separateStorage := range []bool{false, true} {
+		t.Run(fmt.Sprintf("separateStorage=%t", separateStorage), func(t *testing.T) {
+			from := newLFSServer(t, separateStorage, "content")
+			to := newLFSServer(t, separateStorage)
+			to.verify = true
+
+			_, err := CopyLFSObjects(from.store(), to.store(), []LFSObject{testLFSObject("content")})
+			if err != nil {
+				t.Fatalf("CopyLFSObjects failed: %s", err)
+			}
+
+			// Credentials are sent to the batch API, and to actions only when they're on the same host
+			for _, s := range []*lfsServer{from, to} {
+				for _, r := range s.requests {
+					api := strings.HasPrefix(r.url, s.api.URL+"/")
+					if api && len(r.auth) == 0 {
+						t.Errorf("Request %s %s has no credentials", r.method, r.url)
+					}
+					if !api && len(r.auth) > 0 {
+						t.Errorf("Request %s %s to another host has credentials", r.method, r.url)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Entries of mailmaps: a name and email, optionally followed by the name and email of commits they replace
+	// (ex: Public Name <public@example.org> Private Name <private@example.com>)
+	mailmapLine = regexp.MustCompile(`^([^<]*?)\s*<([^>]*)>\s*(?:([^<]*?)\s*<([^>]*)>)?$`)
+	// Identities of commits, as git log prints them with %an <%ae>
+	identity = regexp.MustCompile(`^(.*?)\s*<([^>]*)>$`)
+)
+
+// Mailmap maps the identities of commits to other ones, like the .mailmap files of git do
+type Mailmap struct {
+	entries []mailmapEntry
+}
+
+// mailmapEntry maps the identities of commits with an email (and a name, if it's set) to a name and email.
+// What's empty in the new identity is kept.
+type mailmapEntry struct {
+	name     string
+	email    string
+	oldName  string
+	oldEmail string
+}
+
+// ParseMailmap parses the entries of a mailmap, one per line, in the formats that git supports:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// Blank lines and comments (from a #) are ignored.
+func ParseMailmap(text string) (*Mailmap, error) {
+	m := &Mailmap{}
+	for i, line := range strings.Split(text, "\n") {
+		if cut := strings.Index(line, "#"); cut >= 0 {
+			line = line[:cut]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		match := mailmapLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("Invalid mailmap entry on line %d: %s", i+1, line)
+		}
+
+		e := mailmapEntry{name: match[1], oldName: match[3], oldEmail: match[4]}
+		if len(e.oldEmail) == 0 {
+			// Only the name of commits with the email is replaced
+			e.oldEmail = match[2]
+		} else {
+			e.email = match[2]
+		}
+
+		m.entries = append(m.entries, e)
+	}
+
+	return m, nil
+}
+
+// Map returns the name and email that the identity with the name and email maps to, and true if an entry matched.
+// Emails and names are matched ignoring case, and entries with a name take precedence over those without one.
+func (m *Mailmap) Map(name, email string) (string, string, bool) {
+	var found *mailmapEntry
+	for i, e := range m.entries {
+		if !strings.EqualFold(e.oldEmail, email) {
+			continue
+		}
+
+		if len(e.oldName) == 0 && found == nil {
+			found = &m.entries[i]
+		}
+		if len(e.oldName) > 0 && strings.EqualFold(e.oldName, name) {
+			found = &m.entries[i]
+			break
+		}
+	}
+
+	if found == nil {
+		return name, email, false
+	}
+
+	if len(found.name) > 0 {
+		name = found.name
+	}
+	if len(found.email) > 0 {
+		email = found.email
+	}
+
+	return name, email, true
+}
+
+// ParseIdentity returns the name and email of an identity written as "Name <email>"
+func ParseIdentity(id string) (string, string, error) {
+	match := identity.FindStringSubmatch(strings.TrimSpace(id))
+	if match == nil {
+		return "", "", fmt.Errorf("Invalid identity %q, expected Name <email>", id)
+	}
+
+	return match[1], match[2], nil
+}
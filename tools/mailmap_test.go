@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"testing"
+)
+
+func TestMailmapMap(t *testing.T) {
+	m, err := ParseMailmap(`
+# Comments and blank lines are ignored
+
+Proper Name <commit@example.com>
+<proper@example.org> <email-only@example.com>
+Both <both@example.org> <both@example.com>  # trailing comment
+Named Old <named@example.org> Old Name <shared@example.com>
+Any Name <any@example.org> <shared@example.com>
+`)
+	if err != nil {
+		t.Fatalf("ParseMailmap returned %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		email     string
+		wantName  string
+		wantEmail string
+		mapped    bool
+	}{
+		// Only the name is replaced
+		{"Commit", "commit@example.com", "Proper Name", "commit@example.com", true},
+		// Only the email is replaced
+		{"Someone", "email-only@example.com", "Someone", "proper@example.org", true},
+		{"Someone", "both@example.com", "Both", "both@example.org", true},
+		// Emails are matched ignoring case
+		{"Someone", "BOTH@Example.com", "Both", "both@example.org", true},
+		// Entries with a name take precedence over those without one, wherever they are, and names ignore case
+		{"old name", "shared@example.com", "Named Old", "named@example.org", true},
+		{"Other Name", "shared@example.com", "Any Name", "any@example.org", true},
+		{"Stranger", "stranger@example.org", "Stranger", "stranger@example.org", false},
+		{"Stranger", "", "Stranger", "", false},
+	} {
+		name, email, mapped := m.Map(tc.name, tc.email)
+		if name != tc.wantName || email != tc.wantEmail || mapped != tc.mapped {
+			t.Errorf("Map(%q, %q) = %q, %q, %t, want %q, %q, %t", tc.name, tc.email, name, email, mapped,
+				tc.wantName, tc.wantEmail, tc.mapped)
+		}
+	}
+}
+
+func TestParseMailmapInvalid(t *testing.T) {
+	for _, text := range []string{
+		"No Email",
+		"Name <unterminated@example.org",
+		"Name <a@example.org> <b@example.org> <c@example.org>",
+		"Name <a@example.org> Old Name",
+	} {
+		_, err := ParseMailmap(text)
+		if err == nil {
+			t.Errorf("ParseMailmap(%q) didn't fail", text)
+		}
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	for _, tc := range []struct {
+		id    string
+		name  string
+		email string
+		fails bool
+	}{
+		{id: "Ann <ann@example.org>", name: "Ann", email: "ann@example.org"},
+		{id: "  Ann Lee   <ann@example.org>\n", name: "Ann Lee", email: "ann@example.org"},
+		{id: "<ann@example.org>", name: "", email: "ann@example.org"},
+		{id: "Ann <>", name: "Ann", email: ""},
+		{id: "Ann", fails: true},
+		{id: "Ann <ann@example.org> extra", fails: true},
+	} {
+		name, email, err := ParseIdentity(tc.id)
+		if tc.fails != (err != nil) || name != tc.name || email != tc.email {
+			t.Errorf("ParseIdentity(%q) returned %q, %q, %v", tc.id, name, email, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Lines of glide.yaml and glide.lock files with a value that's mapped (ex: "- package: github.com/user/repo_name")
+	glideLine = regexp.MustCompile(`^(\s*(?:-\s+)?)(package|name|repo|version)(:\s*)(["']?)([^"'\s#]+)(["']?.*)$`)
+	// Headers of the tables of Gopkg.toml and Gopkg.lock files (ex: [[constraint]], [[projects]] or [solve-meta])
+	depTable = regexp.MustCompile(`^\s*\[`)
+	// Lines of Gopkg.toml and Gopkg.lock files with a key whose value is mapped (ex: name = "github.com/user/repo_name")
+	depLine = regexp.MustCompile(`^(\s*)(name|source|revision)(\s*=\s*")([^"]*)(".*)$`)
+	// Quoted strings of Gopkg.toml and Gopkg.lock files, which are paths in lists like required and input-imports
+	depString = regexp.MustCompile(`"[^"]*"`)
+)
+
+// ManifestMapping maps the package paths, repository urls and revisions that glide and dep manifests refer to.
+// Nil functions keep them as they are.
+type ManifestMapping struct {
+	// Returns the new path of a package (ex: the package of glide.yaml, or the name of a dependency)
+	Package func(string) string
+	// Returns the new url of a repository that a dependency is fetched from
+	Repo func(string) string
+	// Returns the new revision of a dependency, given its path and revision (ex: a commit that's synced to another one)
+	Revision func(string, string) string
+}
+
+// RewriteGlide maps the package, the names and repos of the imports, and their pinned versions, in a glide.yaml or
+// glide.lock file. Other lines are kept as they are. The hash of glide.lock isn't updated. It returns the number of
+// lines changed.
+func RewriteGlide(file string, m ManifestMapping) (int, error) {
+	// Imports are listed by name (or package), before their version
+	name := ""
+	return rewriteLines(file, func(_ int, line string) string {
+		match := glideLine.FindStringSubmatch(line)
+		if match == nil {
+			return line
+		}
+
+		value := match[5]
+		switch match[2] {
+		case "package", "name":
+			name = value
+			value = m.pkg(value)
+		case "repo":
+			value = m.repo(value)
+		case "version":
+			value = m.revision(name, value)
+		}
+
+		return match[1] + match[2] + match[3] + match[4] + value + match[6]
+	})
+}
+
+// RewriteDep maps the names, sources and revisions of the projects of a Gopkg.toml or Gopkg.lock file, and the package
+// paths in its lists (ex: required, ignored and input-imports). Other lines are kept as they are. Digests aren't
+// updated. It returns the number of lines changed.
+func RewriteDep(file string, m ManifestMapping) (int, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	// Projects are listed by name, before or after their revision, so the name of the table of each line is found first
+	lines := strings.Split(string(data), "\n")
+	tables := make([]int, len(lines))
+	names := []string{""}
+	for i, line := range lines {
+		if depTable.MatchString(line) {
+			names = append(names, "")
+		}
+		tables[i] = len(names) - 1
+
+		match := depLine.FindStringSubmatch(line)
+		if match != nil && match[2] == "name" {
+			names[tables[i]] = match[4]
+		}
+	}
+
+	return rewriteLines(file, func(i int, line string) string {
+		match := depLine.FindStringSubmatch(line)
+		if match == nil {
+			return depString.ReplaceAllStringFunc(line, func(s string) string {
+				return `"` + m.pkg(strings.Trim(s, `"`)) + `"`
+			})
+		}
+
+		value := match[4]
+		switch match[2] {
+		case "name":
+			value = m.pkg(value)
+		case "source":
+			value = m.repo(value)
+		case "revision":
+			value = m.revision(names[tables[i]], value)
+		}
+
+		return match[1] + match[2] + match[3] + value + match[5]
+	})
+}
+
+// pkg returns the new path of a package
+func (m ManifestMapping) pkg(p string) string {
+	if m.Package == nil {
+		return p
+	}
+
+	return m.Package(p)
+}
+
+// repo returns the new url of a repository
+func (m ManifestMapping) repo(url string) string {
+	if m.Repo == nil {
+		return url
+	}
+
+	return m.Repo(url)
+}
+
+// revision returns the new revision of a dependency
+func (m ManifestMapping) revision(name, rev string) string {
+	if m.Revision == nil || len(name) == 0 {
+		return rev
+	}
+
+	return m.Revision(name, rev)
+}
+
+// rewriteLines replaces each line of the file with what rewrite returns for it, given its index and content, and
+// returns the number of lines that changed. The file is only written if any did.
+func rewriteLines(file string, rewrite func(int, string) string) (int, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	changed := 0
+	for i, line := range lines {
+		rewritten := rewrite(i, line)
+		if rewritten != line {
+			lines[i] = rewritten
+			changed++
+		}
+	}
+
+	if changed == 0 {
+		return 0, nil
+	}
+
+	return changed, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+}
@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+// testManifestMapping maps the packages and repos of github.com/soterium to github.com/soteria-dag, and the revision
+// 1111 of soterd to 2222
+var testManifestMapping = ManifestMapping{
+	Package: func(p string) string {
+		return strings.Replace(p, "github.com/soterium/", "github.com/soteria-dag/", 1)
+	},
+	Repo: func(url string) string {
+		return strings.Replace(url, "github.com/soterium/", "github.com/soteria-dag/", 1)
+	},
+	Revision: func(name, rev string) string {
+		if name == "github.com/soterium/soterd" && rev == "1111" {
+			return "2222"
+		}
+		return rev
+	},
+}
+
+func TestRewriteGlide(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		file    string
+		glide   string
+		want    string
+		changed int
+	}{
+		{
+			name: "glide.yaml",
+			file: "glide.yaml",
+			glide: "package: github.com/soterium/soterwallet\nimport:\n- package: github.com/soterium/soterd\n" +
+				"  version: 1111\n  repo: https://github.com/soterium/soterd.git\n  subpackages:\n  - dag\n" +
+				"- package: example.com/other\n  version: 1111 # Same revision, another package\n",
+			want: "package: github.com/soteria-dag/soterwallet\nimport:\n- package: github.com/soteria-dag/soterd\n" +
+				"  version: 2222\n  repo: https://github.com/soteria-dag/soterd.git\n  subpackages:\n  - dag\n" +
+				"- package: example.com/other\n  version: 1111 # Same revision, another package\n",
+			changed: 4,
+		},
+		{
+			name: "glide.lock, quoted",
+			file: "glide.lock",
+			glide: "hash: abc\nimports:\n- name: \"github.com/soterium/soterd\"\n  version: '1111'\n" +
+				"testImports: []\n",
+			want: "hash: abc\nimports:\n- name: \"github.com/soteria-dag/soterd\"\n  version: '2222'\n" +
+				"testImports: []\n",
+			changed: 2,
+		},
+		{
+			name:    "nothing to map",
+			file:    "glide.yaml",
+			glide:   "package: example.com/a\nimport: []\n",
+			want:    "package: example.com/a\nimport: []\n",
+			changed: 0,
+		},
+	} {
+		got, changed, err := rewriteFile(t, tc.file, tc.glide, func(n string) (int, error) {
+			return RewriteGlide(n, testManifestMapping)
+		})
+		if err != nil || got != tc.want || changed != tc.changed {
+			t.Errorf("%s: RewriteGlide returned %d, %v and wrote %q, want %d and %q", tc.name, changed, err, got, tc.changed, tc.want)
+		}
+	}
+}
+
+func TestRewriteDep(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		file    string
+		dep     string
+		want    string
+		changed int
+	}{
+		{
+			name: "Gopkg.toml",
+			file: "Gopkg.toml",
+			dep: "required = [\"github.com/soterium/soterd/cmd/gen\", \"example.com/tool\"]\n\n[[constraint]]\n" +
+				"  name = \"github.com/soterium/soterd\"\n  source = \"https://github.com/soterium/soterd.git\"\n" +
+				"  revision = \"1111\"\n\n[prune]\n  go-tests = true\n",
+			want: "required = [\"github.com/soteria-dag/soterd/cmd/gen\", \"example.com/tool\"]\n\n[[constraint]]\n" +
+				"  name = \"github.com/soteria-dag/soterd\"\n  source = \"https://github.com/soteria-dag/soterd.git\"\n" +
+				"  revision = \"2222\"\n\n[prune]\n  go-tests = true\n",
+			changed: 4,
+		},
+		{
+			// The revision comes before the name of its project in Gopkg.lock
+			name: "Gopkg.lock",
+			file: "Gopkg.lock",
+			dep: "[[projects]]\n  digest = \"1:abc\"\n  revision = \"1111\"\n  name = \"github.com/soterium/soterd\"\n" +
+				"  packages = [\".\", \"dag\"]\n\n[[projects]]\n  revision = \"1111\"\n  name = \"example.com/other\"\n\n" +
+				"[solve-meta]\n  input-imports = [\"github.com/soterium/soterd/dag\"]\n",
+			want: "[[projects]]\n  digest = \"1:abc\"\n  revision = \"2222\"\n  name = \"github.com/soteria-dag/soterd\"\n" +
+				"  packages = [\".\", \"dag\"]\n\n[[projects]]\n  revision = \"1111\"\n  name = \"example.com/other\"\n\n" +
+				"[solve-meta]\n  input-imports = [\"github.com/soteria-dag/soterd/dag\"]\n",
+			changed: 3,
+		},
+	} {
+		got, changed, err := rewriteFile(t, tc.file, tc.dep, func(n string) (int, error) {
+			return RewriteDep(n, testManifestMapping)
+		})
+		if err != nil || got != tc.want || changed != tc.changed {
+			t.Errorf("%s: RewriteDep returned %d, %v and wrote %q, want %d and %q", tc.name, changed, err, got, tc.changed, tc.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Words of comments that look like module or package paths (ex: github.com/user/repo_name/pkg)
+var modulePathWord = regexp.MustCompile(`[\w~+-][\w.~+-]*(/[\w.~+-]+)+`)
+
+// GoModMapping maps the module paths and directories that go.mod and go.work files refer to. Nil functions keep them
+// as they are.
+type GoModMapping struct {
+	// Returns the new path of a module, for replace directives and module paths in comments
+	Module func(string) string
+	// Returns the new path of a required module, which keeps its version
+	Require func(string) string
+	// Returns the new path of a directory that replaces a module, or that a workspace uses (ex: ../tools)
+	Dir func(string) string
+}
+
+// RewriteGoMod changes the requirements and replace directives of a go.mod file with the mapping, along with the
+// module paths in the comments of its require, replace and retract directives (ex: retraction rationales). Other lines and
+// comments are kept as they are, which go mod edit doesn't do. It returns the number of directives that changed.
+func RewriteGoMod(modFile string, m GoModMapping) (int, error) {
+	data, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := modfile.Parse(modFile, data, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, r := range f.Require {
+		p := m.require(r.Mod.Path)
+		moved := p != r.Mod.Path
+		if moved {
+			setToken(r.Syntax.Token, r.Mod.Path, p)
+			r.Mod.Path = p
+		}
+
+		if m.rewriteComments(&r.Syntax.Comments) || moved {
+			changed++
+		}
+	}
+
+	for _, r := range f.Replace {
+		if m.rewriteReplace(r) {
+			changed++
+		}
+	}
+
+	for _, r := range f.Retract {
+		if m.rewriteComments(&r.Syntax.Comments) {
+			changed++
+		}
+	}
+	changed += m.rewriteBlockComments(f.Syntax, "require", "replace", "retract")
+
+	if changed == 0 {
+		return 0, nil
+	}
+
+	return changed, ioutil.WriteFile(modFile, modfile.Format(f.Syntax), 0644)
+}
+
+// RewriteGoWork changes the replace directives of a go.work file with the mapping like RewriteGoMod does for go.mod
+// files, and the directories of its use directives. Module paths in the comments of both are mapped too
+// (ex: use ./tools // example.com/tools). It returns the number of directives that changed.
+func RewriteGoWork(workFile string, m GoModMapping) (int, error) {
+	data, err := ioutil.ReadFile(workFile)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := modfile.ParseWork(workFile, data, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, r := range f.Replace {
+		if m.rewriteReplace(r) {
+			changed++
+		}
+	}
+
+	for _, u := range f.Use {
+		dir := m.dir(u.Path)
+		moved := dir != u.Path
+		if moved {
+			setToken(u.Syntax.Token, u.Path, dir)
+			u.Path = dir
+		}
+
+		if m.rewriteComments(&u.Syntax.Comments) || moved {
+			changed++
+		}
+	}
+	changed += m.rewriteBlockComments(f.Syntax, "replace", "use")
+
+	if changed == 0 {
+		return 0, nil
+	}
+
+	return changed, ioutil.WriteFile(workFile, modfile.Format(f.Syntax), 0644)
+}
+
+// DropGoSums removes the lines of the modules that drop returns true for from a go.sum or go.work.sum file, so that
+// the go command records the checksums of their replacements instead. It returns the number of lines removed.
+func DropGoSums(sumFile string, drop func(string) bool) (int, error) {
+	data, err := ioutil.ReadFile(sumFile)
+	if err != nil {
+		return 0, err
+	}
+
+	var b bytes.Buffer
+	dropped := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 0 && drop(fields[0]) {
+			dropped++
+			continue
+		}
+
+		fmt.Fprintln(&b, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	return dropped, ioutil.WriteFile(sumFile, b.Bytes(), 0644)
+}
+
+// module returns the new path of a module
+func (m GoModMapping) module(p string) string {
+	if m.Module == nil {
+		return p
+	}
+
+	return m.Module(p)
+}
+
+// require returns the new path of a required module
+func (m GoModMapping) require(p string) string {
+	if m.Require == nil {
+		return p
+	}
+
+	return m.Require(p)
+}
+
+// dir returns the new path of a directory
+func (m GoModMapping) dir(d string) string {
+	if m.Dir == nil {
+		return d
+	}
+
+	return m.Dir(d)
+}
+
+// rewriteReplace maps the module paths of a replace directive, and those in its comments, and the directory that it
+// replaces them with. It returns true if the directive changed.
+func (m GoModMapping) rewriteReplace(r *modfile.Replace) bool {
+	oldPath := m.module(r.Old.Path)
+	newPath := m.module(r.New.Path)
+	if modfile.IsDirectoryPath(r.New.Path) {
+		newPath = m.dir(r.New.Path)
+	}
+
+	changed := m.rewriteComments(&r.Syntax.Comments)
+	if oldPath == r.Old.Path && newPath == r.New.Path {
+		return changed
+	}
+
+	// The old path comes before the arrow, and the new one after it
+	arrow := 0
+	for i, t := range r.Syntax.Token {
+		if t == "=>" {
+			arrow = i
+		}
+	}
+	setToken(r.Syntax.Token[:arrow], r.Old.Path, oldPath)
+	setToken(r.Syntax.Token[arrow:], r.New.Path, newPath)
+	r.Old.Path, r.New.Path = oldPath, newPath
+
+	return true
+}
+
+// rewriteBlockComments maps the module paths in the comments of the blocks of the verbs (ex: a rationale that applies
+// to every retraction of a retract block). It returns the number of blocks that changed.
+func (m GoModMapping) rewriteBlockComments(syntax *modfile.FileSyntax, verbs ...string) int {
+	changed := 0
+	for _, stmt := range syntax.Stmt {
+		block, isBlock := stmt.(*modfile.LineBlock)
+		if !isBlock || len(block.Token) == 0 {
+			continue
+		}
+
+		for _, v := range verbs {
+			if block.Token[0] == v && m.rewriteComments(&block.Comments) {
+				changed++
+			}
+		}
+	}
+
+	return changed
+}
+
+// rewriteComments maps the module paths in the comments. It returns true if any changed.
+func (m GoModMapping) rewriteComments(comments *modfile.Comments) bool {
+	changed := false
+	for _, list := range [][]modfile.Comment{comments.Before, comments.Suffix, comments.After} {
+		for i := range list {
+			token := modulePathWord.ReplaceAllStringFunc(list[i].Token, func(word string) string {
+				// Periods at the end of a path end the sentence
+				trimmed := strings.TrimRight(word, ".")
+				return m.module(trimmed) + word[len(trimmed):]
+			})
+
+			if token != list[i].Token {
+				list[i].Token = token
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// setToken replaces the token for the value old (quoted if it needs to be) with the one for new
+func setToken(tokens []string, old, new string) {
+	for i, t := range tokens {
+		if t == modfile.AutoQuote(old) {
+			tokens[i] = modfile.AutoQuote(new)
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMapping maps the modules of github.com/soterium to github.com/soteria-dag, and the ../soterd directory to
+// ../soterd-dag
+var testMapping = GoModMapping{
+	Module: func(p string) string {
+		return strings.Replace(p, "github.com/soterium/", "github.com/soteria-dag/", 1)
+	},
+	Require: func(p string) string {
+		return strings.Replace(p, "github.com/soterium/", "github.com/soteria-dag/", 1)
+	},
+	Dir: func(d string) string {
+		if d == "../soterd" {
+			return "../soterd-dag"
+		}
+		return d
+	},
+}
+
+// rewriteFile writes the content to a file in a new temporary directory, rewrites it, and returns its new content and
+// what rewrite returned
+func rewriteFile(t *testing.T, name, content string, rewrite func(string) (int, error)) (string, int, error) {
+	n := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(n, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := rewrite(n)
+	data, readErr := ioutil.ReadFile(n)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	return string(data), changed, err
+}
+
+func TestRewriteGoMod(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mod     string
+		want    string
+		changed int
+	}{
+		{
+			name:    "nothing to map",
+			mod:     "module example.com/a\n\n// Kept as it is\nrequire example.com/b v1.0.0 // indirect\n",
+			want:    "module example.com/a\n\n// Kept as it is\nrequire example.com/b v1.0.0 // indirect\n",
+			changed: 0,
+		},
+		{
+			name: "requirements",
+			mod: "module example.com/a\n\ngo 1.21\n\nrequire (\n\tgithub.com/soterium/soterd v0.1.0\n" +
+				"\texample.com/b v1.0.0 // indirect\n)\n",
+			want: "module example.com/a\n\ngo 1.21\n\nrequire (\n\tgithub.com/soteria-dag/soterd v0.1.0\n" +
+				"\texample.com/b v1.0.0 // indirect\n)\n",
+			changed: 1,
+		},
+		{
+			name: "replace directives",
+			mod: "module example.com/a\n\nreplace github.com/soterium/soterd => ../soterd\n\n" +
+				"replace github.com/soterium/soterwallet v0.1.0 => github.com/soterium/soterwallet v0.2.0\n\n" +
+				"replace example.com/b => ./b\n",
+			want: "module example.com/a\n\nreplace github.com/soteria-dag/soterd => ../soterd-dag\n\n" +
+				"replace github.com/soteria-dag/soterwallet v0.1.0 => github.com/soteria-dag/soterwallet v0.2.0\n\n" +
+				"replace example.com/b => ./b\n",
+			changed: 2,
+		},
+		{
+			name: "retractions and comments",
+			mod: "module example.com/a\n\n// Moved from github.com/soterium/soterd.\nretract (\n" +
+				"\tv1.0.0 // Published by mistake, use github.com/soterium/soterd/v2\n\tv1.0.1\n)\n",
+			want: "module example.com/a\n\n// Moved from github.com/soteria-dag/soterd.\nretract (\n" +
+				"\tv1.0.0 // Published by mistake, use github.com/soteria-dag/soterd/v2\n\tv1.0.1\n)\n",
+			changed: 2,
+		},
+		{
+			// Paths that need quotes keep them
+			name:    "quoted",
+			mod:     "module example.com/a\n\nreplace example.com/b => \"../soterd\"\n",
+			want:    "module example.com/a\n\nreplace example.com/b => ../soterd-dag\n",
+			changed: 1,
+		},
+	} {
+		got, changed, err := rewriteFile(t, "go.mod", tc.mod, func(n string) (int, error) {
+			return RewriteGoMod(n, testMapping)
+		})
+		if err != nil || got != tc.want || changed != tc.changed {
+			t.Errorf("%s: RewriteGoMod returned %d, %v and wrote %q, want %d and %q", tc.name, changed, err, got, tc.changed, tc.want)
+		}
+	}
+
+	_, _, err := rewriteFile(t, "go.mod", "module\n", func(n string) (int, error) {
+		return RewriteGoMod(n, testMapping)
+	})
+	if err == nil {
+		t.Errorf("RewriteGoMod didn't fail for an invalid go.mod")
+	}
+}
+
+func TestRewriteGoWork(t *testing.T) {
+	work := "go 1.21\n\nuse (\n\t.\n\t../soterd // github.com/soterium/soterd\n\t./tools\n)\n\n" +
+		"replace github.com/soterium/soterd => ../soterd\n"
+	want := "go 1.21\n\nuse (\n\t.\n\t../soterd-dag // github.com/soteria-dag/soterd\n\t./tools\n)\n\n" +
+		"replace github.com/soteria-dag/soterd => ../soterd-dag\n"
+
+	got, changed, err := rewriteFile(t, "go.work", work, func(n string) (int, error) {
+		return RewriteGoWork(n, testMapping)
+	})
+	if err != nil || got != want || changed != 2 {
+		t.Errorf("RewriteGoWork returned %d, %v and wrote %q, want 2 and %q", changed, err, got, want)
+	}
+
+	// Nil functions keep paths as they are
+	got, changed, err = rewriteFile(t, "go.work", work, func(n string) (int, error) {
+		return RewriteGoWork(n, GoModMapping{})
+	})
+	if err != nil || got != work || changed != 0 {
+		t.Errorf("RewriteGoWork without a mapping returned %d, %v and wrote %q", changed, err, got)
+	}
+}
+
+func TestDropGoSums(t *testing.T) {
+	sums := "example.com/b v1.0.0 h1:abc=\nexample.com/b v1.0.0/go.mod h1:def=\n" +
+		"github.com/soterium/soterd v0.1.0 h1:ghi=\ngithub.com/soterium/soterd v0.1.0/go.mod h1:jkl=\n"
+	drop := func(p string) bool {
+		return strings.HasPrefix(p, "github.com/soterium/")
+	}
+
+	got, dropped, err := rewriteFile(t, "go.sum", sums, func(n string) (int, error) {
+		return DropGoSums(n, drop)
+	})
+	want := "example.com/b v1.0.0 h1:abc=\nexample.com/b v1.0.0/go.mod h1:def=\n"
+	if err != nil || got != want || dropped != 2 {
+		t.Errorf("DropGoSums returned %d, %v and wrote %q, want 2 and %q", dropped, err, got, want)
+	}
+}
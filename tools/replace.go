@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Replacer replaces many strings at once, in a single pass over the input.
+//
+// At each position of the input, the longest old string that matches is replaced, and the replaced text isn't matched
+// again, so rules don't see the output of other rules. If several rules have the same old string, the first one wins.
+type Replacer struct {
+	rules [][]string
+	olds  [][]byte
+	// Indexes of the rules, by the first byte of their old string, longest old string first
+	byFirst [256][]int
+}
+
+// NewReplacer returns a Replacer for the sets of strings {old, new}
+func NewReplacer(rules [][]string) (*Replacer, error) {
+	r := &Replacer{rules: rules}
+	for i, rule := range rules {
+		if len(rule) != 2 {
+			return nil, fmt.Errorf("Can't apply replacement (%s): Need to specify an old and new string", rule)
+		}
+		if len(rule[0]) == 0 {
+			return nil, fmt.Errorf("Can't apply replacement (%s): The old string is empty", rule)
+		}
+
+		r.olds = append(r.olds, []byte(rule[0]))
+		first := rule[0][0]
+		r.byFirst[first] = append(r.byFirst[first], i)
+	}
+
+	for _, indexes := range r.byFirst {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return len(rules[indexes[a]][0]) > len(rules[indexes[b]][0])
+		})
+	}
+
+	return r, nil
+}
+
+// Rules returns the sets of strings {old, new} of the Replacer
+func (r *Replacer) Rules() [][]string {
+	return r.rules
+}
+
+// Replace returns in with the rules applied, and the number of matches of each rule (by index of the rule)
+func (r *Replacer) Replace(in []byte) ([]byte, []int) {
+	counts := make([]int, len(r.rules))
+	var out bytes.Buffer
+	// Start of the input that hasn't been copied to out yet
+	last := 0
+
+	for i := 0; i < len(in); {
+		match := -1
+		for _, rule := range r.byFirst[in[i]] {
+			if bytes.HasPrefix(in[i:], r.olds[rule]) {
+				match = rule
+				break
+			}
+		}
+
+		if match < 0 {
+			i++
+			continue
+		}
+
+		out.Write(in[last:i])
+		out.WriteString(r.rules[match][1])
+		counts[match]++
+		i += len(r.olds[match])
+		last = i
+	}
+
+	if last == 0 {
+		// No matches
+		return in, counts
+	}
+
+	out.Write(in[last:])
+	return out.Bytes(), counts
+}
+
+// ReplaceString returns s with the rules applied
+func (r *Replacer) ReplaceString(s string) string {
+	out, _ := r.Replace([]byte(s))
+	return string(out)
+}
@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplacer(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rules  [][]string
+		in     string
+		want   string
+		counts []int
+	}{
+		{
+			name:   "no matches",
+			rules:  [][]string{{"soterium", "soteria-dag"}},
+			in:     "nothing to see",
+			want:   "nothing to see",
+			counts: []int{0},
+		},
+		{
+			name:   "several matches",
+			rules:  [][]string{{"soterium", "soteria-dag"}},
+			in:     "github.com/soterium/soterd, soterium",
+			want:   "github.com/soteria-dag/soterd, soteria-dag",
+			counts: []int{2},
+		},
+		{
+			// Longest old string first, whatever the order of the rules
+			name:   "overlapping",
+			rules:  [][]string{{"soter", "S"}, {"soterium", "soteria-dag"}, {"github.com/soterium", "github.com/soteria-dag"}},
+			in:     "github.com/soterium/soterd soterium soter",
+			want:   "github.com/soteria-dag/Sd soteria-dag S",
+			counts: []int{2, 1, 1},
+		},
+		{
+			// Replaced text isn't matched again by other rules
+			name:   "no chaining",
+			rules:  [][]string{{"a", "b"}, {"b", "c"}},
+			in:     "ab",
+			want:   "bc",
+			counts: []int{1, 1},
+		},
+		{
+			name:   "swap",
+			rules:  [][]string{{"Soterium", "Soteria DAG"}, {"Soteria DAG", "Soterium"}},
+			in:     "Soterium and Soteria DAG",
+			want:   "Soteria DAG and Soterium",
+			counts: []int{1, 1},
+		},
+		{
+			// The first of the rules with the same old string wins
+			name:   "same old string",
+			rules:  [][]string{{"x", "1"}, {"x", "2"}},
+			in:     "xx",
+			want:   "11",
+			counts: []int{2, 0},
+		},
+		{
+			// Matches don't overlap; the leftmost one is replaced
+			name:   "adjacent",
+			rules:  [][]string{{"aa", "b"}},
+			in:     "aaa",
+			want:   "ba",
+			counts: []int{1},
+		},
+		{
+			name:   "multibyte",
+			rules:  [][]string{{"é", "e"}, {"日本", "nihon"}},
+			in:     "café 日本語",
+			want:   "cafe nihon語",
+			counts: []int{1, 1},
+		},
+	} {
+		r, err := NewReplacer(tc.rules)
+		if err != nil {
+			t.Errorf("%s: NewReplacer returned %v", tc.name, err)
+			continue
+		}
+
+		out, counts := r.Replace([]byte(tc.in))
+		if string(out) != tc.want || !reflect.DeepEqual(counts, tc.counts) {
+			t.Errorf("%s: Replace(%q) returned %q, %v, want %q, %v", tc.name, tc.in, out, counts, tc.want, tc.counts)
+		}
+		if got := r.ReplaceString(tc.in); got != tc.want {
+			t.Errorf("%s: ReplaceString(%q) returned %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNewReplacerInvalid(t *testing.T) {
+	for _, rules := range [][][]string{
+		{{"old"}},
+		{{"old", "new", "extra"}},
+		{{"", "new"}},
+	} {
+		_, err := NewReplacer(rules)
+		if err == nil {
+			t.Errorf("NewReplacer(%q) didn't fail", rules)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RewriteFiles replaces the content of the files under root that match any of the include glob patterns (by path or
+// name, see IsExcluded), or of all files if there are none, with what rewrite returns for their relative path and
+// content. It returns the relative paths of the files that changed, sorted, and the files that weren't rewritten
+// because of the options, sorted by path.
+//
+// Files are chosen like ReplaceR chooses them: symlinks, Git LFS pointers and files that are excluded or too large by
+// the options aren't rewritten, and binary and generated files that rewrite would change are skipped, rewritten or
+// fail the rewrite, depending on the policies of the options. When the policy is to fail, all files are still
+// processed, so that the error lists all of the files that need to be dealt with.
+func RewriteFiles(root string, include []string, opts ReplaceOptions, rewrite func(string, []byte) ([]byte, error)) ([]string, []SkippedFile, error) {
+	changed := make([]string, 0)
+	skipped := make([]SkippedFile, 0)
+	failed := make([]string, 0)
+	attrs, err := LoadAttributes(root, opts.Exclude...)
+	if err != nil {
+		return changed, skipped, err
+	}
+
+	walker := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range opts.Exclude {
+			if IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !info.Mode().IsRegular() || (len(include) > 0 && !IsExcluded(rel, include...)) {
+			return nil
+		}
+
+		if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+			skipped = append(skipped, SkippedFile{Path: rel, Reason: ReasonOversize})
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+
+		reason := ClassifyContent(rel, data, attrs)
+		policy := opts.policy(reason)
+		rewritten, err := rewrite(rel, data)
+		if err != nil {
+			if policy != ContentRewrite {
+				// Files that aren't rewritten don't need to be valid input to rewrite
+				return nil
+			}
+			return fmt.Errorf("%s: %s", rel, err)
+		}
+		if bytes.Equal(rewritten, data) {
+			return nil
+		}
+
+		switch policy {
+		case ContentRewrite:
+			changed = append(changed, rel)
+			return ioutil.WriteFile(n, rewritten, info.Mode().Perm())
+		case ContentFail:
+			failed = append(failed, fmt.Sprintf("%s (%s)", rel, reason))
+		default:
+			skipped = append(skipped, SkippedFile{Path: rel, Reason: reason})
+		}
+
+		return nil
+	}
+
+	err = filepath.Walk(root, walker)
+	sort.Strings(changed)
+	if err != nil {
+		return changed, skipped, err
+	}
+
+	if len(failed) > 0 {
+		return changed, skipped, fmt.Errorf("Changes to files that the policy doesn't allow rewriting: %s", strings.Join(failed, ", "))
+	}
+
+	return changed, skipped, nil
+}
+
+// StripRegions removes the regions of the data that start with a line containing the begin marker, and end with a line
+// containing the end marker, along with those lines. It returns the data without them, and the number of regions
+// removed. Regions can't be nested, and must be terminated.
+func StripRegions(data []byte, begin, end string) ([]byte, int, error) {
+	if len(begin) == 0 || len(end) == 0 {
+		return data, 0, fmt.Errorf("Regions need a begin and end marker")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	var b strings.Builder
+	regions := 0
+	// Line number of the start of the region being removed, if one is
+	start := 0
+	for i, line := range lines {
+		switch {
+		case strings.Contains(line, begin):
+			if start > 0 {
+				return data, 0, fmt.Errorf("Region starting on line %d is nested in the one starting on line %d", i+1, start)
+			}
+			start = i + 1
+		case strings.Contains(line, end):
+			if start == 0 {
+				return data, 0, fmt.Errorf("Region ending on line %d wasn't started", i+1)
+			}
+			start = 0
+			regions++
+		case start == 0:
+			b.WriteString(line)
+		}
+	}
+
+	if start > 0 {
+		return data, 0, fmt.Errorf("Region starting on line %d isn't terminated", start)
+	}
+
+	if regions == 0 {
+		return data, 0, nil
+	}
+
+	return []byte(b.String()), regions, nil
+}
+
+// AddHeader returns the data with the header at its start, after a #! line if it has one, followed by a blank line so
+// that it stays apart from what follows (ex: a go package comment). If the data starts with one of the old headers, it's
+// replaced. Data that already starts with the header is returned as it is.
+func AddHeader(data []byte, header string, old ...string) []byte {
+	header = strings.TrimRight(header, "\n") + "\n"
+	content := string(data)
+
+	shebang := ""
+	if strings.HasPrefix(content, "#!") {
+		cut := strings.Index(content, "\n") + 1
+		if cut == 0 {
+			content += "\n"
+			cut = len(content)
+		}
+		shebang, content = content[:cut], content[cut:]
+	}
+
+	if strings.HasPrefix(content, header) {
+		return data
+	}
+
+	for _, o := range old {
+		o = strings.TrimRight(o, "\n") + "\n"
+		if len(o) > 1 && strings.HasPrefix(content, o) {
+			content = content[len(o):]
+			break
+		}
+	}
+
+	if len(content) > 0 && !strings.HasPrefix(content, "\n") {
+		header += "\n"
+	}
+
+	return []byte(shebang + header + content)
+}
@@ -0,0 +1,157 @@
+package tools
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripRegions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    string
+		want    string
+		regions int
+		fails   bool
+	}{
+		{name: "none", data: "a\nb\n", want: "a\nb\n"},
+		{name: "one", data: "a\n// +private\nb\n// -private\nc\n", want: "a\nc\n", regions: 1},
+		{name: "two", data: "// +private\na\n// -private\nb\n// +private\n// -private\n", want: "b\n", regions: 2},
+		{name: "no trailing newline", data: "a\n// +private\nb\n// -private", want: "a\n", regions: 1},
+		{name: "marker in a line", data: "x := 1 // +private\ny := 2 // -private\nz := 3\n", want: "z := 3\n", regions: 1},
+		{name: "nested", data: "// +private\n// +private\n// -private\n// -private\n", fails: true},
+		{name: "not started", data: "a\n// -private\n", fails: true},
+		{name: "not terminated", data: "a\n// +private\nb\n", fails: true},
+	} {
+		got, regions, err := StripRegions([]byte(tc.data), "// +private", "// -private")
+		switch {
+		case tc.fails:
+			if err == nil || string(got) != tc.data {
+				t.Errorf("%s: StripRegions returned %q, %v, want the data and an error", tc.name, got, err)
+			}
+		case err != nil || string(got) != tc.want || regions != tc.regions:
+			t.Errorf("%s: StripRegions returned %q, %d, %v, want %q, %d", tc.name, got, regions, err, tc.want, tc.regions)
+		}
+	}
+
+	_, _, err := StripRegions([]byte("a\n"), "", "// -private")
+	if err == nil {
+		t.Errorf("StripRegions didn't fail without a begin marker")
+	}
+}
+
+func TestAddHeader(t *testing.T) {
+	const header = "// Copyright (c) The Soteria DAG developers"
+	for _, tc := range []struct {
+		name string
+		data string
+		old  []string
+		want string
+	}{
+		{name: "plain", data: "package a\n", want: header + "\n\npackage a\n"},
+		{name: "empty", data: "", want: header + "\n"},
+		{name: "already there", data: header + "\n\npackage a\n", want: header + "\n\npackage a\n"},
+		{name: "shebang", data: "#!/bin/sh\necho\n", want: "#!/bin/sh\n" + header + "\n\necho\n"},
+		{name: "shebang only", data: "#!/bin/sh", want: "#!/bin/sh\n" + header + "\n"},
+		{
+			name: "replaced",
+			data: "// Copyright (c) The Soterium developers\n\npackage a\n",
+			old:  []string{"// Copyright (c) The Soterium developers"},
+			want: header + "\n\npackage a\n",
+		},
+		{
+			name: "other old header",
+			data: "// Copyright (c) Someone else\npackage a\n",
+			old:  []string{"// Copyright (c) The Soterium developers"},
+			want: header + "\n\n// Copyright (c) Someone else\npackage a\n",
+		},
+	} {
+		got := AddHeader([]byte(tc.data), header, tc.old...)
+		if string(got) != tc.want {
+			t.Errorf("%s: AddHeader returned %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRewriteFilesPolicies(t *testing.T) {
+	files := map[string]string{
+		"a.go":           "package a\n",
+		"gen.go":         "// Code generated by stringer. DO NOT EDIT.\n\npackage a\n",
+		"bin.go":         "package a\x00\n",
+		"attr.go":        "package a\n",
+		"big.go":         "package a\n" + strings.Repeat("//\n", 100),
+		"go.mod":         "module a\n",
+		"sub/b.go":       "package b\n",
+		"sub/b.txt":      "text\n",
+		".gitattributes": "attr.go binary\n",
+		"lfs.go":         "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+	}
+	header := func(_ string, data []byte) ([]byte, error) {
+		return AddHeader(data, "// H"), nil
+	}
+
+	for _, tc := range []struct {
+		name    string
+		opts    ReplaceOptions
+		changed []string
+		skipped []SkippedFile
+		fails   bool
+	}{
+		{
+			name:    "skip",
+			opts:    ReplaceOptions{Exclude: []string{"go.mod"}, MaxSize: 200},
+			changed: []string{"a.go", "sub/b.go"},
+			skipped: []SkippedFile{
+				{Path: "attr.go", Reason: ReasonAttributes},
+				{Path: "big.go", Reason: ReasonOversize},
+				{Path: "bin.go", Reason: ReasonBinary},
+				{Path: "gen.go", Reason: ReasonGenerated},
+				{Path: "lfs.go", Reason: ReasonLFSPointer},
+			},
+		},
+		{
+			name:    "rewrite",
+			opts:    ReplaceOptions{Exclude: []string{"go.mod"}, Binary: ContentRewrite, Generated: ContentRewrite},
+			changed: []string{"a.go", "attr.go", "big.go", "bin.go", "gen.go", "sub/b.go"},
+			skipped: []SkippedFile{{Path: "lfs.go", Reason: ReasonLFSPointer}},
+		},
+		{
+			name:  "fail",
+			opts:  ReplaceOptions{Generated: ContentFail},
+			fails: true,
+		},
+	} {
+		root := writeTree(t, files)
+		changed, skipped, err := RewriteFiles(root, []string{"*.go"}, tc.opts, header)
+		if tc.fails {
+			if err == nil || !strings.Contains(err.Error(), "gen.go") {
+				t.Errorf("%s: RewriteFiles returned %v, want an error listing gen.go", tc.name, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(changed, tc.changed) || !reflect.DeepEqual(skipped, tc.skipped) {
+			t.Errorf("%s: RewriteFiles returned %v, %v, %v, want %v, %v", tc.name, changed, skipped, err, tc.changed, tc.skipped)
+		}
+
+		for _, rel := range tc.changed {
+			data, err := ioutil.ReadFile(filepath.Join(root, rel))
+			if err != nil || !strings.HasPrefix(string(data), "// H\n\n") {
+				t.Errorf("%s: %s has %q, %v after RewriteFiles", tc.name, rel, data, err)
+			}
+		}
+	}
+}
+
+func TestRewriteFilesSkippedErrors(t *testing.T) {
+	// Binary files that are skipped don't fail the rewrite, even when they aren't valid input
+	root := writeTree(t, map[string]string{"a.txt": "a\n", "b.bin": "\x00// +private\n"})
+	changed, skipped, err := RewriteFiles(root, nil, ReplaceOptions{}, func(_ string, data []byte) ([]byte, error) {
+		out, _, err := StripRegions(data, "// +private", "// -private")
+		return out, err
+	})
+	if err != nil || len(changed) != 0 || len(skipped) != 0 {
+		t.Errorf("RewriteFiles returned %v, %v, %v", changed, skipped, err)
+	}
+}
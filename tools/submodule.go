@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// Name of the file listing the submodules of a tree, at its root
+const submodulesFile = ".gitmodules"
+
+// ReadSubmodules returns the url of each submodule listed in the .gitmodules file at root, by path.
+// It's empty if there's no .gitmodules file.
+func ReadSubmodules(root string) (map[string]string, error) {
+	urls := make(map[string]string)
+	cfg, err := readSubmodulesFile(root)
+	if err != nil || cfg == nil {
+		return urls, err
+	}
+
+	for _, s := range cfg.Section("submodule").Subsections {
+		urls[s.Option("path")] = s.Option("url")
+	}
+
+	return urls, nil
+}
+
+// RemoveSubmodules removes the submodules at the paths from the .gitmodules file at root, keeping the others in
+// order. The file is removed if no submodules are left in it.
+func RemoveSubmodules(root string, paths []string) error {
+	cfg, err := readSubmodulesFile(root)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	removed := make(map[string]bool)
+	for _, p := range paths {
+		removed[p] = true
+	}
+
+	section := cfg.Section("submodule")
+	kept := make(format.Subsections, 0, len(section.Subsections))
+	for _, s := range section.Subsections {
+		if !removed[s.Option("path")] {
+			kept = append(kept, s)
+		}
+	}
+	section.Subsections = kept
+
+	if len(kept) == 0 && len(section.Options) == 0 && len(cfg.Sections) == 1 {
+		return os.Remove(filepath.Join(root, submodulesFile))
+	}
+
+	return writeSubmodulesFile(root, cfg)
+}
+
+// RenameSubmodules changes the paths of the submodules in the .gitmodules file at root to the paths that rename maps
+// them to. Their names are kept.
+func RenameSubmodules(root string, rename func(string) string) error {
+	cfg, err := readSubmodulesFile(root)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	for _, s := range cfg.Section("submodule").Subsections {
+		// Options are changed in place, to keep their order
+		for _, o := range s.Options {
+			if o.IsKey("path") {
+				o.Value = rename(o.Value)
+			}
+		}
+	}
+
+	return writeSubmodulesFile(root, cfg)
+}
+
+// ResolveSubmoduleURL returns the url of a submodule, resolving urls relative to the url of the superproject
+// (./name or ../name) the way git does. Other urls are returned as they are.
+func ResolveSubmoduleURL(base, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+
+	base = strings.TrimSuffix(base, "/")
+	for {
+		switch {
+		case strings.HasPrefix(url, "./"):
+			url = url[2:]
+		case strings.HasPrefix(url, "../"):
+			url = url[3:]
+			// Drop the last element of the base, which is separated by a : in scp-like urls (git@host:repo.git)
+			cut := strings.LastIndexAny(base, "/:")
+			switch {
+			case cut < 0:
+			case base[cut] == ':':
+				base = base[:cut+1]
+			default:
+				base = base[:cut]
+			}
+		default:
+			if strings.HasSuffix(base, ":") {
+				return base + url
+			}
+			return base + "/" + url
+		}
+	}
+}
+
+// readSubmodulesFile parses the .gitmodules file at root, or returns nil if there isn't one
+func readSubmodulesFile(root string) (*format.Config, error) {
+	n := filepath.Join(root, submodulesFile)
+	in, err := os.Open(n)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	cfg := format.New()
+	err = format.NewDecoder(in).Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", n, err)
+	}
+
+	return cfg, nil
+}
+
+// writeSubmodulesFile writes the .gitmodules file at root
+func writeSubmodulesFile(root string, cfg *format.Config) error {
+	var b bytes.Buffer
+	err := format.NewEncoder(&b).Encode(cfg)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(root, submodulesFile), b.Bytes(), 0644)
+}
@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Number of hex digits of abbreviated hashes
+const abbrevLen = 7
+
+// Placeholders that git archive expands in files with the export-subst attribute
+var formatPlaceholder = regexp.MustCompile(`\$Format:([^$]*)\$`)
+
+// Date formats of the git pretty formats
+const (
+	// %ad, %cd (the default date format)
+	gitDateDefault = "Mon Jan 2 15:04:05 2006 -0700"
+	// %aD, %cD
+	gitDateRFC2822 = "Mon, 2 Jan 2006 15:04:05 -0700"
+	// %ai, %ci
+	gitDateISO = "2006-01-02 15:04:05 -0700"
+	// %aI, %cI
+	gitDateStrictISO = "2006-01-02T15:04:05-07:00"
+)
+
+// expandFormat replaces the $Format:...$ placeholders in data with the git pretty format inside of them, for the
+// commit. The commonly used placeholders are supported (hashes, names, emails, dates, the subject and body); others
+// are kept as they are.
+func expandFormat(data []byte, commit *object.Commit) []byte {
+	return formatPlaceholder.ReplaceAllFunc(data, func(m []byte) []byte {
+		format := formatPlaceholder.FindSubmatch(m)[1]
+		return []byte(prettyFormat(string(format), commit))
+	})
+}
+
+// prettyFormat returns the git pretty format (ex: "%H %an") for the commit
+func prettyFormat(format string, commit *object.Commit) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		n, value := formatPlaceholderValue(format[i+1:], commit)
+		if n == 0 {
+			// Unsupported placeholder
+			b.WriteByte(format[i])
+			continue
+		}
+
+		b.WriteString(value)
+		i += n
+	}
+
+	return b.String()
+}
+
+// formatPlaceholderValue returns the length of the placeholder at the start of s (after a %), and its value for the
+// commit. The length is 0 if the placeholder isn't supported.
+func formatPlaceholderValue(s string, commit *object.Commit) (int, string) {
+	subject, body := splitMessage(commit.Message)
+
+	switch s[0] {
+	case '%':
+		return 1, "%"
+	case 'n':
+		return 1, "\n"
+	case 'H':
+		return 1, commit.Hash.String()
+	case 'h':
+		return 1, commit.Hash.String()[:abbrevLen]
+	case 'T':
+		return 1, commit.TreeHash.String()
+	case 't':
+		return 1, commit.TreeHash.String()[:abbrevLen]
+	case 'P', 'p':
+		parents := make([]string, 0, len(commit.ParentHashes))
+		for _, h := range commit.ParentHashes {
+			if s[0] == 'p' {
+				parents = append(parents, h.String()[:abbrevLen])
+			} else {
+				parents = append(parents, h.String())
+			}
+		}
+		return 1, strings.Join(parents, " ")
+	case 's':
+		return 1, subject
+	case 'b':
+		return 1, body
+	case 'B':
+		return 1, commit.Message
+	case 'a', 'c':
+		if len(s) < 2 {
+			return 0, ""
+		}
+
+		sig := commit.Author
+		if s[0] == 'c' {
+			sig = commit.Committer
+		}
+
+		switch s[1] {
+		case 'n':
+			return 2, sig.Name
+		case 'e':
+			return 2, sig.Email
+		case 'd':
+			return 2, sig.When.Format(gitDateDefault)
+		case 'D':
+			return 2, sig.When.Format(gitDateRFC2822)
+		case 'i':
+			return 2, sig.When.Format(gitDateISO)
+		case 'I':
+			return 2, sig.When.Format(gitDateStrictISO)
+		case 't':
+			return 2, fmt.Sprintf("%d", sig.When.Unix())
+		}
+	}
+
+	return 0, ""
+}
+
+// splitMessage returns the subject of a commit message (its first paragraph, on one line) and its body
+func splitMessage(msg string) (string, string) {
+	paragraphs := strings.SplitN(strings.TrimLeft(msg, "\n"), "\n\n", 2)
+
+	lines := strings.Split(strings.TrimSpace(paragraphs[0]), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	subject := strings.Join(lines, " ")
+
+	body := ""
+	if len(paragraphs) > 1 {
+		body = strings.TrimLeft(paragraphs[1], "\n")
+	}
+
+	return subject, body
+}
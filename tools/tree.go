@@ -0,0 +1,363 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of change between two trees
+const (
+	// The path only exists in the second tree
+	TreeAdded = "added"
+	// The path only exists in the first tree
+	TreeRemoved = "removed"
+	// The path exists in both trees, but differs
+	TreeModified = "modified"
+)
+
+// Formats that a TreeDiff can be rendered in
+const (
+	TreeDiffText = "text"
+	TreeDiffJSON = "json"
+)
+
+// TreeChange is a path that differs between two trees
+type TreeChange struct {
+	// Path relative to the trees, with / separators
+	Path string `json:"path"`
+	// TreeAdded, TreeRemoved or TreeModified
+	Kind string `json:"kind"`
+	// What differs for a modified path: content, mode (the executable bit), symlink (its target) or type
+	// (ex: a file in one tree is a symlink or directory in the other)
+	Reason string `json:"reason,omitempty"`
+}
+
+// TreeDiff is the list of paths that differ between two trees, sorted by path
+type TreeDiff []TreeChange
+
+// treeEntry is what's compared of a path in a tree
+type treeEntry struct {
+	mode os.FileMode
+	// Executable bit of files
+	exec bool
+	// Hash of the content of files, or the target of symlinks
+	sum string
+}
+
+// String returns the changes one per line, as kind, path and reason separated by tabs
+func (d TreeDiff) String() string {
+	var b bytes.Buffer
+	for _, c := range d {
+		if len(c.Reason) > 0 {
+			fmt.Fprintf(&b, "%s\t%s\t%s\n", c.Kind, c.Path, c.Reason)
+		} else {
+			fmt.Fprintf(&b, "%s\t%s\n", c.Kind, c.Path)
+		}
+	}
+
+	return b.String()
+}
+
+// Render returns the changes in the format, TreeDiffText or TreeDiffJSON
+func (d TreeDiff) Render(format string) (string, error) {
+	switch format {
+	case "", TreeDiffText:
+		return d.String(), nil
+	case TreeDiffJSON:
+		if d == nil {
+			// Render no changes as an empty list rather than null
+			d = TreeDiff{}
+		}
+
+		out, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return "", err
+		}
+
+		return string(out) + "\n", nil
+	default:
+		return "", fmt.Errorf("Unsupported tree diff format %q", format)
+	}
+}
+
+// CompareTrees compares the files under directories a and b, and returns the paths that differ.
+// Added paths are only in b, and removed paths are only in a.
+//
+// Files are compared by a hash of their content and their executable bit, and symlinks by their target (they aren't
+// followed). Other permission bits are ignored, as they are by git, and so are directories, except for when a path is
+// a directory in one tree but not the other.
+//
+// Paths matching any of the exclude glob patterns (see path.Match), or whose name matches them, are skipped along with
+// everything under them (ex: ".git", "vendor/*" or "*.pb.go").
+func CompareTrees(a, b string, exclude ...string) (TreeDiff, error) {
+	return CompareTreesRenamed(a, b, nil, exclude...)
+}
+
+// CompareTreesRenamed compares the files under directories a and b like CompareTrees, except that the files of a are
+// expected in b under the relative paths (with / separators) that rename maps them to. If rename is nil, paths are
+// kept as they are.
+func CompareTreesRenamed(a, b string, rename func(string) string, exclude ...string) (TreeDiff, error) {
+	var diff TreeDiff
+	aEntries, err := treeEntries(a, exclude)
+	if err != nil {
+		return diff, err
+	}
+
+	if rename != nil {
+		renamed := make(map[string]treeEntry)
+		for p, e := range aEntries {
+			if !e.mode.IsDir() {
+				// Directories are only compared to find type changes, which renamed files are still checked for
+				renamed[rename(p)] = e
+			}
+		}
+		aEntries = renamed
+	}
+
+	bEntries, err := treeEntries(b, exclude)
+	if err != nil {
+		return diff, err
+	}
+
+	for p, ae := range aEntries {
+		be, exists := bEntries[p]
+		if !exists {
+			if !ae.mode.IsDir() {
+				diff = append(diff, TreeChange{Path: p, Kind: TreeRemoved})
+			}
+			continue
+		}
+
+		var reason string
+		switch {
+		case ae.mode.Type() != be.mode.Type():
+			reason = "type"
+		case ae.mode.IsDir():
+		case ae.mode&os.ModeSymlink != 0 && ae.sum != be.sum:
+			reason = "symlink"
+		case ae.sum != be.sum:
+			reason = "content"
+		case ae.exec != be.exec:
+			reason = "mode"
+		}
+
+		if len(reason) > 0 {
+			diff = append(diff, TreeChange{Path: p, Kind: TreeModified, Reason: reason})
+		}
+	}
+
+	for p, be := range bEntries {
+		_, exists := aEntries[p]
+		if !exists && !be.mode.IsDir() {
+			diff = append(diff, TreeChange{Path: p, Kind: TreeAdded})
+		}
+	}
+
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Path < diff[j].Path
+	})
+
+	return diff, nil
+}
+
+// CopyTree copies the files and symlinks under src to the same paths under dst, creating their directories and keeping
+// the executable bit of files. Files and symlinks at those paths in dst are replaced, as tar does, and other paths in
+// dst are left alone. Empty directories aren't copied, since git doesn't track them.
+func CopyTree(src, dst string) error {
+	copier := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+
+		target := filepath.Join(dst, rel)
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(n)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		}
+
+		perm := os.FileMode(0644)
+		if info.Mode()&0111 != 0 {
+			perm = 0755
+		}
+
+		return copyFile(n, target, perm)
+	}
+
+	return filepath.Walk(src, copier)
+}
+
+// IsExcluded returns true if the relative path rel (with / separators), or its name, matches any of the glob patterns
+func IsExcluded(rel string, exclude ...string) bool {
+	name := path.Base(rel)
+	for _, x := range exclude {
+		if m, _ := path.Match(x, rel); m {
+			return true
+		}
+		if m, _ := path.Match(x, name); m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// treeEntries returns the entries of the paths under root, by their relative path
+func treeEntries(root string, exclude []string) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+	walker := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if n == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		if IsExcluded(rel, exclude...) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		e := treeEntry{mode: info.Mode()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			e.sum, err = os.Readlink(n)
+		case info.Mode().IsRegular():
+			e.exec = info.Mode()&0111 != 0
+			e.sum, err = hashFile(n)
+		}
+		if err != nil {
+			return err
+		}
+
+		entries[rel] = e
+		return nil
+	}
+
+	err := filepath.Walk(root, walker)
+	return entries, err
+}
+
+// renamedPaths returns the relative paths (with / separators) that rename maps the files and empty directories under
+// root to, along with the directories of those paths, and a boolean of if each path is a directory.
+// Paths under the exclude paths are left out. Empty directories are kept because git archive creates them for
+// submodules.
+func renamedPaths(root string, rename func(string) string, exclude []string) (map[string]bool, error) {
+	paths := map[string]bool{".": true}
+	walker := func(n string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, n)
+		if err != nil {
+			return fmt.Errorf("Can't determine relative path for %s: %s", n, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, x := range exclude {
+			if IsUnder(rel, x) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		isDir := info.IsDir()
+		if isDir {
+			entries, err := os.ReadDir(n)
+			if err != nil || len(entries) > 0 || n == root {
+				return err
+			}
+		}
+
+		p := rename(rel)
+		paths[p] = isDir
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			paths[dir] = true
+		}
+
+		return nil
+	}
+
+	err := filepath.Walk(root, walker)
+	return paths, err
+}
+
+// copyFile copies the content of the file src to a new file dst, with the permissions perm
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// hashFile returns the hex sha256 hash of the content of the file
+func hashFile(n string) (string, error) {
+	f, err := os.Open(n)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree writes the files to a new temporary directory, by relative path, and returns its path
+func writeTree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for rel, data := range files {
+		n := filepath.Join(root, filepath.FromSlash(rel))
+		err := os.MkdirAll(filepath.Dir(n), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(n, []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestCompareTrees(t *testing.T) {
+	a := writeTree(t, map[string]string{
+		"same.txt":        "same\n",
+		"content.txt":     "a\n",
+		"mode.sh":         "#!/bin/sh\n",
+		"perm.txt":        "perm\n",
+		"removed.txt":     "gone\n",
+		"type/file.txt":   "file\n",
+		"dir/nested.txt":  "nested\n",
+		".git/HEAD":       "ref: refs/heads/a\n",
+		"vendor/x/x.go":   "package x\n",
+		"api/api.pb.go":   "package api\n",
+		"vendor.txt":      "kept\n",
+		"link-target.txt": "target\n",
+	})
+	b := writeTree(t, map[string]string{
+		"same.txt":        "same\n",
+		"content.txt":     "b\n",
+		"mode.sh":         "#!/bin/sh\n",
+		"perm.txt":        "perm\n",
+		"added.txt":       "new\n",
+		"type":            "now a file\n",
+		"dir/nested.txt":  "nested\n",
+		".git/HEAD":       "ref: refs/heads/b\n",
+		"vendor/x/x.go":   "package y\n",
+		"api/api.pb.go":   "package changed\n",
+		"vendor.txt":      "changed\n",
+		"link-target.txt": "target\n",
+	})
+	for _, err := range []error{
+		os.Chmod(filepath.Join(b, "mode.sh"), 0755),
+		// Permission bits other than the executable bit are ignored
+		os.Chmod(filepath.Join(b, "perm.txt"), 0600),
+		os.Symlink("link-target.txt", filepath.Join(a, "link")),
+		os.Symlink("same.txt", filepath.Join(b, "link")),
+		os.Symlink("same.txt", filepath.Join(a, "same-link")),
+		os.Symlink("same.txt", filepath.Join(b, "same-link")),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := TreeDiff{
+		{Path: "added.txt", Kind: TreeAdded},
+		{Path: "content.txt", Kind: TreeModified, Reason: "content"},
+		{Path: "link", Kind: TreeModified, Reason: "symlink"},
+		{Path: "mode.sh", Kind: TreeModified, Reason: "mode"},
+		{Path: "removed.txt", Kind: TreeRemoved},
+		{Path: "type", Kind: TreeModified, Reason: "type"},
+		{Path: "type/file.txt", Kind: TreeRemoved},
+		{Path: "vendor.txt", Kind: TreeModified, Reason: "content"},
+	}
+	diff, err := CompareTrees(a, b, ".git", "vendor/*", "*.pb.go")
+	if err != nil || !reflect.DeepEqual(diff, want) {
+		t.Errorf("CompareTrees returned %v, %v, want %v", diff, err, want)
+	}
+
+	diff, err = CompareTrees(a, a, ".git")
+	if err != nil || len(diff) != 0 {
+		t.Errorf("CompareTrees of a tree with itself returned %v, %v", diff, err)
+	}
+}
+
+func TestCompareTreesRenamed(t *testing.T) {
+	a := writeTree(t, map[string]string{"soterium/a.txt": "a\n", "b.txt": "b\n"})
+	b := writeTree(t, map[string]string{"soteria/a.txt": "a\n", "b.txt": "changed\n"})
+	rename := func(p string) string {
+		return strings.Replace(p, "soterium", "soteria", 1)
+	}
+
+	want := TreeDiff{{Path: "b.txt", Kind: TreeModified, Reason: "content"}}
+	diff, err := CompareTreesRenamed(a, b, rename)
+	if err != nil || !reflect.DeepEqual(diff, want) {
+		t.Errorf("CompareTreesRenamed returned %v, %v, want %v", diff, err, want)
+	}
+}
+
+func TestTreeDiffRender(t *testing.T) {
+	diff := TreeDiff{
+		{Path: "a.txt", Kind: TreeAdded},
+		{Path: "b.txt", Kind: TreeModified, Reason: "content"},
+	}
+	for _, tc := range []struct {
+		diff   TreeDiff
+		format string
+		want   string
+	}{
+		{diff: diff, format: "", want: "added\ta.txt\nmodified\tb.txt\tcontent\n"},
+		{diff: diff, format: TreeDiffText, want: "added\ta.txt\nmodified\tb.txt\tcontent\n"},
+		{
+			diff:   diff,
+			format: TreeDiffJSON,
+			want: "[\n  {\n    \"path\": \"a.txt\",\n    \"kind\": \"added\"\n  },\n" +
+				"  {\n    \"path\": \"b.txt\",\n    \"kind\": \"modified\",\n    \"reason\": \"content\"\n  }\n]\n",
+		},
+		{diff: nil, format: TreeDiffJSON, want: "[]\n"},
+		{diff: nil, format: TreeDiffText, want: ""},
+	} {
+		got, err := tc.diff.Render(tc.format)
+		if err != nil || got != tc.want {
+			t.Errorf("Render(%q) of %v returned %q, %v, want %q", tc.format, tc.diff, got, err, tc.want)
+		}
+	}
+
+	_, err := diff.Render("xml")
+	if err == nil {
+		t.Errorf("Render didn't fail for an unsupported format")
+	}
+}